@@ -1,10 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"log"
+	"os"
 	"outlook-helper/backend/internal/api"
 	"outlook-helper/backend/internal/config"
 	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/secure"
 )
 
 func main() {
@@ -26,8 +29,33 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// 加载凭据加密主密钥
+	masterKey, err := secure.LoadMasterKey(cfg.CredentialKey, cfg.CredentialKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load credential key: %v", err)
+	}
+
+	// 轮换凭据加密密钥：./main rotate-credential-key
+	if len(os.Args) > 1 && os.Args[1] == "rotate-credential-key" {
+		rotateCredentialKey(conn, cfg, masterKey)
+		return
+	}
+
+	// 初始化凭据加密，并加密旧版本保存的明文凭据
+	keyring, err := database.LoadKeyring(conn, masterKey)
+	if err != nil {
+		log.Fatalf("Failed to load credential keyring: %v", err)
+	}
+	if keyring == nil {
+		log.Printf("Warning: CREDENTIAL_KEY is not set, email credentials are stored in plaintext")
+	} else if count, err := database.EncryptCredentials(conn, keyring); err != nil {
+		log.Fatalf("Failed to encrypt existing credentials: %v", err)
+	} else if count > 0 {
+		log.Printf("Encrypted credentials of %d existing emails", count)
+	}
+
 	// 创建数据库管理器
-	db := database.NewDB(conn)
+	db := database.NewDB(conn, keyring)
 
 	// 初始化种子数据
 	if err := database.SeedData(conn); err != nil {
@@ -41,8 +69,39 @@ func main() {
 
 	// 启动API服务器
 	server := api.NewServer(cfg, db)
+
+	// 继续执行上次退出时未完成的后台任务
+	if count, err := server.ResumeJobs(); err != nil {
+		log.Printf("Warning: Failed to resume background jobs: %v", err)
+	} else if count > 0 {
+		log.Printf("Resuming %d unfinished background jobs", count)
+	}
+
 	log.Printf("Starting server on port %s", cfg.Port)
 	if err := server.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// rotateCredentialKey 生成新的数据密钥重新加密所有凭据；
+// 配置了 CREDENTIAL_NEW_KEY 时同时更换主密钥。执行前需停止服务
+func rotateCredentialKey(conn *sql.DB, cfg *config.Config, masterKey []byte) {
+	if masterKey == nil {
+		log.Fatalf("CREDENTIAL_KEY or CREDENTIAL_KEY_FILE is required to rotate credential key")
+	}
+
+	newMasterKey, err := secure.LoadMasterKey(cfg.CredentialNewKey, cfg.CredentialNewKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load new credential key: %v", err)
+	}
+
+	count, err := database.RotateCredentialKey(conn, masterKey, newMasterKey)
+	if err != nil {
+		log.Fatalf("Failed to rotate credential key: %v", err)
+	}
+
+	log.Printf("Re-encrypted credentials of %d emails with a new data key", count)
+	if newMasterKey != nil {
+		log.Printf("Master key replaced, update CREDENTIAL_KEY/CREDENTIAL_KEY_FILE to the new key before restarting")
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListAPIKeys 获取当前用户的API密钥列表
+func (s *Server) handleListAPIKeys(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	keys, err := s.authService.ListAPIKeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取API密钥列表失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取API密钥列表成功",
+		Data:    keys,
+	})
+}
+
+// handleCreateAPIKey 创建API密钥，完整密钥只在响应中返回一次
+func (s *Server) handleCreateAPIKey(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result, err := s.authService.CreateAPIKey(userID, &req, auth.GetClientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "创建API密钥失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "创建API密钥成功，请立即保存密钥，之后将无法再次查看",
+		Data:    result,
+	})
+}
+
+// handleRevokeAPIKey 吊销API密钥
+func (s *Server) handleRevokeAPIKey(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的API密钥ID",
+			Error:   "invalid api key id",
+		})
+		return
+	}
+
+	if err := s.authService.RevokeAPIKey(userID, keyID, auth.GetClientInfo(c)); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: "吊销API密钥失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "吊销API密钥成功",
+	})
+}
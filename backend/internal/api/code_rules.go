@@ -0,0 +1,223 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetCodeRules 获取验证码提取规则列表
+func (s *Server) handleGetCodeRules(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	rules, err := s.codeExtractor.GetRules(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取验证码规则失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取验证码规则成功",
+		Data:    rules,
+	})
+}
+
+// handleCreateCodeRule 创建验证码提取规则
+func (s *Server) handleCreateCodeRule(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.CodeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := s.codeExtractor.CreateRule(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "创建验证码规则失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 记录操作日志
+	client := auth.GetClientInfo(c)
+	s.db.Log.LogCodeRule(userID, "code_rule_created", rule.ID,
+		fmt.Sprintf("创建验证码规则: %s", rule.Pattern),
+		client)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "创建验证码规则成功",
+		Data:    rule,
+	})
+}
+
+// handleUpdateCodeRule 更新验证码提取规则
+func (s *Server) handleUpdateCodeRule(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取规则ID
+	ruleIDStr := c.Param("id")
+	ruleID, err := strconv.Atoi(ruleIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的规则ID",
+			Error:   "invalid rule id",
+		})
+		return
+	}
+
+	var req models.CodeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	rule, err := s.codeExtractor.UpdateRule(userID, ruleID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "更新验证码规则失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 记录操作日志
+	client := auth.GetClientInfo(c)
+	s.db.Log.LogCodeRule(userID, "code_rule_updated", rule.ID,
+		fmt.Sprintf("更新验证码规则: %s", rule.Pattern),
+		client)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "更新验证码规则成功",
+		Data:    rule,
+	})
+}
+
+// handleDeleteCodeRule 删除验证码提取规则
+func (s *Server) handleDeleteCodeRule(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取规则ID
+	ruleIDStr := c.Param("id")
+	ruleID, err := strconv.Atoi(ruleIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的规则ID",
+			Error:   "invalid rule id",
+		})
+		return
+	}
+
+	rule, err := s.codeExtractor.DeleteRule(userID, ruleID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "删除验证码规则失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 记录操作日志
+	client := auth.GetClientInfo(c)
+	s.db.Log.LogCodeRule(userID, "code_rule_deleted", rule.ID,
+		fmt.Sprintf("删除验证码规则: %s", rule.Pattern),
+		client)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "删除验证码规则成功",
+	})
+}
+
+// handleTestCodeRule 使用当前规则测试提取结果
+func (s *Server) handleTestCodeRule(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.TestCodeRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	code, link := s.codeExtractor.Extract(userID, req.From, req.Subject, req.Body)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "测试提取完成",
+		Data: map[string]interface{}{
+			"verify_code": code,
+			"verify_link": link,
+		},
+	})
+}
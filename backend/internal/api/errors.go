@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 错误代码
+const (
+	ErrCodeInvalidGrant        = "invalid_grant"
+	ErrCodeAccountLocked       = "account_locked"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeUpstreamUnavailable = "upstream_unavailable"
+	ErrCodeUpstreamTimeout     = "upstream_timeout"
+	ErrCodeBadUpstreamResponse = "bad_upstream_response"
+	ErrCodeUpstreamRejected    = "upstream_rejected"
+	ErrCodeNoMail              = "no_mail"
+	ErrCodePartiallyCleared    = "partially_cleared"
+	ErrCodeWaitCodeTimeout     = "wait_code_timeout"
+)
+
+// errorStatus 将错误映射为HTTP状态码和错误代码，无法识别的错误使用 fallback 状态码
+func errorStatus(err error, fallback int) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrWaitCodeTimeout):
+		// 不使用408，浏览器和HTTP客户端会把408当作空闲连接被关闭而自动重试长轮询
+		return http.StatusGatewayTimeout, ErrCodeWaitCodeTimeout
+	case errors.Is(err, services.ErrInvalidGrant):
+		return http.StatusUnprocessableEntity, ErrCodeInvalidGrant
+	case errors.Is(err, services.ErrAccountLocked):
+		return http.StatusLocked, ErrCodeAccountLocked
+	case errors.Is(err, services.ErrRateLimited):
+		return http.StatusTooManyRequests, ErrCodeRateLimited
+	case errors.Is(err, services.ErrUpstreamUnavailable):
+		if isTimeout(err) {
+			return http.StatusGatewayTimeout, ErrCodeUpstreamTimeout
+		}
+		return http.StatusServiceUnavailable, ErrCodeUpstreamUnavailable
+	case errors.Is(err, services.ErrBadResponse):
+		return http.StatusBadGateway, ErrCodeBadUpstreamResponse
+	case errors.Is(err, services.ErrUpstreamRejected):
+		return http.StatusBadRequest, ErrCodeUpstreamRejected
+	case errors.Is(err, services.ErrNoMail):
+		return http.StatusNotFound, ErrCodeNoMail
+	case errors.Is(err, services.ErrPartiallyCleared):
+		return http.StatusBadGateway, ErrCodePartiallyCleared
+	}
+	return fallback, ""
+}
+
+// respondError 返回失败响应，上游错误按类型映射HTTP状态码和错误代码
+func respondError(c *gin.Context, fallback int, message string, err error) {
+	status, code := errorStatus(err, fallback)
+	if retryAfter := services.RetryAfterOf(err); retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Message: message,
+		Error:   err.Error(),
+		Code:    code,
+	})
+}
+
+// isTimeout 判断是否为超时错误
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
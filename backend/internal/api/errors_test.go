@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"outlook-helper/backend/internal/services"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid grant", &services.UpstreamError{Kind: services.ErrInvalidGrant}, http.StatusUnprocessableEntity, ErrCodeInvalidGrant},
+		{"no mail", services.ErrNoMail, http.StatusNotFound, ErrCodeNoMail},
+		{"partially cleared", &services.UpstreamError{Kind: services.ErrPartiallyCleared}, http.StatusBadGateway, ErrCodePartiallyCleared},
+		{"wrapped", fmt.Errorf("清空收件箱失败: %w", &services.UpstreamError{Kind: services.ErrPartiallyCleared}), http.StatusBadGateway, ErrCodePartiallyCleared},
+		{"unknown", errors.New("其他错误"), http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code := errorStatus(tt.err, http.StatusBadRequest)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Fatalf("errorStatus() = (%d, %q), want (%d, %q)", status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeatInterval SSE心跳间隔，防止代理断开空闲连接
+const sseHeartbeatInterval = 25 * time.Second
+
+// handleCreateEventTicket 签发SSE连接票据，有效期很短，只能用于建立事件推送连接
+func (s *Server) handleCreateEventTicket(c *gin.Context) {
+	user, userExists := auth.GetCurrentUser(c)
+	session, sessionExists := auth.GetCurrentSession(c)
+	if !userExists || !sessionExists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	ticket, expiresAt, err := s.authService.IssueSSETicket(user, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "签发连接票据失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "签发连接票据成功",
+		Data: gin.H{
+			"ticket":     ticket,
+			"expires_at": expiresAt,
+		},
+	})
+}
+
+// handleEvents 实时事件推送（Server-Sent Events）
+func (s *Server) handleEvents(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 每个连接独立订阅，支持多个浏览器标签页同时接收
+	events, unsubscribe := s.eventHub.Subscribe(userID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 禁用Nginx缓冲
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// 连接建立后立即发送一次，便于前端确认订阅成功
+	c.SSEvent("connected", gin.H{"time": time.Now()})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleStartHealthCheck 启动账户健康检查任务
+func (s *Server) handleStartHealthCheck(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 请求体可为空，为空时检查全部邮箱
+	var req models.HealthCheckRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "请求参数错误",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	client := auth.GetClientInfo(c)
+
+	job, err := s.healthCheck.Start(userID, &req, client)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrHealthCheckRunning) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "启动健康检查失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Message: "健康检查任务已启动",
+		Data:    job,
+	})
+}
+
+// handleGetHealthCheck 获取健康检查任务进度和报告
+func (s *Server) handleGetHealthCheck(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	job, err := s.healthCheck.GetJob(userID, c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: "获取健康检查任务失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取健康检查任务成功",
+		Data:    job,
+	})
+}
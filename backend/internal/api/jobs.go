@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetJob 获取后台任务的进度和逐项结果
+func (s *Server) handleGetJob(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	job, err := s.jobManager.Get(userID, c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "获取任务失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取任务成功",
+		Data:    job,
+	})
+}
+
+// handleCancelJob 取消后台任务，执行中的任务在当前对象处理完后停止，已处理的结果会保留
+func (s *Server) handleCancelJob(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	job, err := s.jobManager.Cancel(userID, c.Param("id"), auth.GetClientInfo(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrJobFinished):
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "取消任务失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	message := "已取消任务"
+	if !job.Finished() {
+		message = "正在取消任务，当前处理中的对象完成后停止"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    job,
+	})
+}
+
+// wantsAsync 请求是否指定 async=true，以后台任务方式执行批量操作
+func wantsAsync(c *gin.Context) bool {
+	async, _ := strconv.ParseBool(c.Query("async"))
+	return async
+}
+
+// respondJobStarted 返回 202 和新创建的后台任务，通过 GET /api/jobs/:id 查询进度
+func respondJobStarted(c *gin.Context, job *models.Job, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "创建后台任务失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Message: "已转为后台任务",
+		Data:    job,
+	})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleSearchMessages 全文搜索所有邮箱的本地邮件
+func (s *Server) handleSearchMessages(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	keyword := c.Query("q")
+	if keyword == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "搜索关键词不能为空",
+			Error:   "q is required",
+		})
+		return
+	}
+
+	// 获取分页参数
+	limit := 20
+	offset := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	// 获取标签过滤参数
+	tagID := 0
+	if tagIDStr := c.Query("tag_id"); tagIDStr != "" {
+		id, err := strconv.Atoi(tagIDStr)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "无效的标签ID",
+				Error:   "invalid tag_id",
+			})
+			return
+		}
+		tagID = id
+	}
+
+	// 获取起始时间参数，支持 2006-01-02 和 RFC3339 格式
+	var since time.Time
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := parseSince(sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "无效的起始时间",
+				Error:   "since must be YYYY-MM-DD or RFC3339",
+			})
+			return
+		}
+		since = parsed
+	}
+
+	results, total, err := s.emailService.SearchMessages(userID, keyword, tagID, since, limit, offset)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, database.ErrSearchUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "搜索邮件失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 返回分页格式的数据
+	response := map[string]interface{}{
+		"list":  results,
+		"total": total,
+		"page":  (offset / limit) + 1,
+		"size":  limit,
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "搜索邮件成功",
+		Data:    response,
+	})
+}
+
+// parseSince 解析起始时间参数
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetMonitors 获取邮箱监控列表
+func (s *Server) handleGetMonitors(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	monitors, err := s.monitorService.GetUserMonitors(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取邮箱监控列表失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取邮箱监控列表成功",
+		Data:    monitors,
+	})
+}
+
+// handleGetMonitorStatus 获取轮询器运行状态
+func (s *Server) handleGetMonitorStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取监控状态成功",
+		Data:    s.monitorService.GetStatus(),
+	})
+}
+
+// handleUpdateMonitorSettings 更新轮询间隔和并发数
+func (s *Server) handleUpdateMonitorSettings(c *gin.Context) {
+	var req models.MonitorSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	s.monitorService.UpdateSettings(req.IntervalSeconds, req.Workers)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "更新监控设置成功",
+		Data:    s.monitorService.GetStatus(),
+	})
+}
+
+// handleSetMonitor 开启或关闭邮箱监控
+func (s *Server) handleSetMonitor(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
+		})
+		return
+	}
+
+	var req models.SetMonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	monitor, err := s.monitorService.SetMonitor(userID, emailID, *req.Enabled, client)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "设置邮箱监控失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "设置邮箱监控成功",
+		Data:    monitor,
+	})
+}
+
+// handleDeleteMonitor 删除邮箱监控
+func (s *Server) handleDeleteMonitor(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
+		})
+		return
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	if err := s.monitorService.DeleteMonitor(userID, emailID, client); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "删除邮箱监控失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "删除邮箱监控成功",
+	})
+}
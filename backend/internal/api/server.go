@@ -1,10 +1,14 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"outlook-helper/backend/internal/auth"
 	"outlook-helper/backend/internal/config"
@@ -16,13 +20,27 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// maxSyncBatchAdd 批量添加时同步处理的最大数量，超过时转为后台导入任务
+	maxSyncBatchAdd = 30
+	// maxImportFileSize 导入文件的最大大小
+	maxImportFileSize = 64 << 20
+)
+
 // Server API服务器
 type Server struct {
-	config       *config.Config
-	db           *database.DB
-	router       *gin.Engine
-	authService  *auth.Service
-	emailService *services.EmailService
+	config         *config.Config
+	db             *database.DB
+	router         *gin.Engine
+	authService    *auth.Service
+	emailService   *services.EmailService
+	outlookService *services.OutlookService
+	monitorService *services.MonitorService
+	eventHub       *services.EventHub
+	codeExtractor  *services.CodeExtractor
+	healthCheck    *services.HealthCheckService
+	importService  *services.ImportService
+	jobManager     *services.JobManager
 }
 
 // NewServer 创建新的API服务器
@@ -31,16 +49,42 @@ func NewServer(cfg *config.Config, db *database.DB) *Server {
 	authService := auth.NewService(db, cfg.JWTSecret, cfg.JWTExpire, cfg)
 
 	// 创建Outlook服务
-	outlookService := services.NewOutlookService(cfg.OutlookAPI)
+	outlookService := services.NewOutlookService(db, cfg)
+
+	// 创建实时事件分发中心
+	eventHub := services.NewEventHub()
+
+	// 创建验证码提取引擎
+	codeExtractor := services.NewCodeExtractor(db)
+
+	// 创建后台任务执行器，各服务在创建时注册自己的任务类型
+	jobManager := services.NewJobManager(db, eventHub, cfg)
 
 	// 创建邮件服务
-	emailService := services.NewEmailService(db, outlookService, cfg)
+	emailService := services.NewEmailService(db, outlookService, codeExtractor, eventHub, jobManager, cfg)
+
+	// 创建邮箱轮询服务，检测到新邮件时推送实时事件
+	monitorService := services.NewMonitorService(db, outlookService, codeExtractor, cfg)
+	monitorService.OnNewMail(eventHub.PublishMail)
+
+	// 创建账户健康检查服务
+	healthCheck := services.NewHealthCheckService(db, outlookService, jobManager, cfg)
+
+	// 创建邮箱批量导入服务
+	importService := services.NewImportService(db, outlookService, jobManager, cfg)
 
 	server := &Server{
-		config:       cfg,
-		db:           db,
-		authService:  authService,
-		emailService: emailService,
+		config:         cfg,
+		db:             db,
+		authService:    authService,
+		emailService:   emailService,
+		outlookService: outlookService,
+		monitorService: monitorService,
+		eventHub:       eventHub,
+		codeExtractor:  codeExtractor,
+		healthCheck:    healthCheck,
+		importService:  importService,
+		jobManager:     jobManager,
 	}
 
 	server.setupRouter()
@@ -56,11 +100,19 @@ func (s *Server) setupRouter() {
 
 	s.router = gin.Default()
 
+	// 只信任配置的反向代理传递的客户端IP，未配置时保持gin默认行为
+	if len(s.config.TrustedProxies) > 0 {
+		if err := s.router.SetTrustedProxies(s.config.TrustedProxies); err != nil {
+			log.Printf("Warning: invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
 	// 配置CORS - 允许所有来源访问
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
 	corsConfig.AllowCredentials = true
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", auth.APIKeyHeader}
+	corsConfig.ExposeHeaders = []string{"Retry-After"}
 	s.router.Use(cors.New(corsConfig))
 
 	// 静态文件服务 - 为assets目录设置正确的MIME类型
@@ -105,60 +157,170 @@ func (s *Server) setupRouter() {
 		// 健康检查接口（无需认证）
 		api.GET("/health", s.handleHealth)
 
+		// 按客户端IP限流：登录接口单独限制，防止暴力猜测授权码和密码
+		loginLimiter := auth.NewRateLimiter(s.config.RateLimitLoginPerMinute, 0)
+		apiLimiter := auth.NewRateLimiter(s.config.RateLimitAPIPerMinute, 0)
+
 		// 认证相关
 		authGroup := api.Group("/auth")
+		authGroup.Use(auth.RateLimitMiddleware(loginLimiter, "login"))
 		{
 			authGroup.POST("/login", s.handleLogin)
+			authGroup.POST("/2fa/verify", s.handleVerifyTwoFactor)
 		}
 
 		// 需要认证的认证相关接口
 		authProtected := api.Group("/auth")
-		authProtected.Use(auth.AuthMiddleware(s.authService))
+		authProtected.Use(auth.RateLimitMiddleware(apiLimiter, "api"), auth.AuthMiddleware(s.authService))
 		{
 			authProtected.POST("/logout", s.handleLogout)
+			authProtected.POST("/change-password", auth.SessionOnly(), s.handleChangePassword)
+			authProtected.POST("/refresh", auth.SessionOnly(), s.handleRefreshToken)
+
+			// 登录会话管理
+			sessions := authProtected.Group("/sessions")
+			sessions.Use(auth.SessionOnly())
+			{
+				sessions.GET("", s.handleListSessions)
+				sessions.DELETE("", s.handleRevokeOtherSessions)
+				sessions.DELETE("/:id", s.handleRevokeSession)
+			}
+
+			// 个人API密钥管理，只能在登录会话中操作
+			apiKeys := authProtected.Group("/api-keys")
+			apiKeys.Use(auth.SessionOnly())
+			{
+				apiKeys.GET("", s.handleListAPIKeys)
+				apiKeys.POST("", s.handleCreateAPIKey)
+				apiKeys.DELETE("/:id", s.handleRevokeAPIKey)
+			}
+
+			// 两步验证管理
+			twoFactor := authProtected.Group("/2fa")
+			twoFactor.Use(auth.SessionOnly())
+			{
+				twoFactor.GET("", s.handleGetTwoFactorStatus)
+				twoFactor.POST("/setup", s.handleSetupTwoFactor)
+				twoFactor.POST("/enable", s.handleEnableTwoFactor)
+				twoFactor.POST("/disable", s.handleDisableTwoFactor)
+				twoFactor.POST("/recovery-codes", s.handleRegenerateRecoveryCodes)
+			}
 		}
 
-		// 需要认证的路由
+		// 实时事件推送（SSE），浏览器使用短期票据认证，脚本可直接使用请求头
+		api.GET("/events", auth.RateLimitMiddleware(apiLimiter, "api"), auth.EventStreamAuthMiddleware(s.authService),
+			auth.RequirePermission(s.authService, auth.PermEmailRead), s.handleEvents)
+
+		// 需要认证的路由，只读接口对所有角色开放，其他接口按权限校验。
+		// 所有角色都有读取权限，组级别的读取校验只对API密钥的权限范围生效
 		protected := api.Group("/")
-		protected.Use(auth.AuthMiddleware(s.authService))
+		protected.Use(auth.RateLimitMiddleware(apiLimiter, "api"), auth.AuthMiddleware(s.authService), auth.RequirePermission(s.authService, auth.PermEmailRead))
 		{
+			canWrite := auth.RequirePermission(s.authService, auth.PermEmailWrite)
+			canDelete := auth.RequirePermission(s.authService, auth.PermEmailDelete)
+			canClear := auth.RequirePermission(s.authService, auth.PermMailClear)
+			canExport := auth.RequirePermission(s.authService, auth.PermCredentialExport)
+
 			// 仪表盘
 			protected.GET("/dashboard", s.handleDashboard)
 			protected.GET("/dashboard/stats", s.handleDashboardStats)
 
+			// SSE连接票据，EventSource 通过 GET /api/events?ticket= 建立连接
+			protected.POST("/events/ticket", auth.SessionOnly(), s.handleCreateEventTicket)
+
+			// 后台任务进度和取消
+			protected.GET("/jobs/:id", s.handleGetJob)
+			protected.DELETE("/jobs/:id", canWrite, s.handleCancelJob)
+
 			// 邮箱管理
 			emails := protected.Group("/emails")
 			{
 				emails.GET("", s.handleGetEmails)
-				emails.POST("", s.handleAddEmail)
-				emails.POST("/batch", s.handleBatchAddEmails)
-				emails.POST("/import", s.handleImportEmails)
-				emails.POST("/export", s.handleExportEmails)
-				emails.DELETE("/batch", s.handleBatchDeleteEmails)
-				emails.POST("/batch-clear-inbox", s.handleBatchClearInbox)
+				emails.POST("", canWrite, s.handleAddEmail)
+				emails.POST("/batch", canWrite, s.handleBatchAddEmails)
+				emails.POST("/import", canWrite, s.handleImportEmails)
+				emails.POST("/export", canExport, s.handleExportEmails)
+				emails.DELETE("/batch", canDelete, s.handleBatchDeleteEmails)
+				emails.POST("/batch-clear-inbox", canClear, s.handleBatchClearInbox)
+				emails.POST("/batch-clear-junk", canClear, s.handleBatchClearJunk)
+				emails.POST("/health-check", canWrite, s.handleStartHealthCheck)
+				emails.GET("/health-check/:job_id", s.handleGetHealthCheck)
 				emails.GET("/:id/latest", s.handleGetLatestMail)
 				emails.GET("/:id/all", s.handleGetAllMails)
-				emails.DELETE("/:id/inbox", s.handleClearInbox)
-				emails.PUT("/:id/tags", s.handleTagEmail)
-				emails.DELETE("/:id", s.handleDeleteEmail)
+				emails.GET("/:id/wait-code", s.handleWaitVerifyCode)
+				emails.GET("/:id/messages", s.handleGetStoredMessages)
+				emails.DELETE("/:id/inbox", canClear, s.handleClearInbox)
+				emails.DELETE("/:id/junk", canClear, s.handleClearJunk)
+				emails.PUT("/:id/tags", canWrite, s.handleTagEmail)
+				emails.PUT("/:id/shared", canWrite, s.handleShareEmail)
+				emails.DELETE("/:id", canDelete, s.handleDeleteEmail)
 			}
 
 			// 标记管理
 			tags := protected.Group("/tags")
 			{
 				tags.GET("", s.handleGetTags)
-				tags.POST("", s.handleCreateTag)
-				tags.PUT("/:id", s.handleUpdateTag)
-				tags.DELETE("/:id", s.handleDeleteTag)
-				tags.POST("/batch-tag", s.handleBatchTagEmails)
-				tags.POST("/batch-untag", s.handleBatchUntagEmails)
+				tags.POST("", canWrite, s.handleCreateTag)
+				tags.PUT("/:id", canWrite, s.handleUpdateTag)
+				tags.DELETE("/:id", canWrite, s.handleDeleteTag)
+				tags.POST("/batch-tag", canWrite, s.handleBatchTagEmails)
+				tags.POST("/batch-untag", canWrite, s.handleBatchUntagEmails)
+			}
+
+			// 本地邮件搜索
+			messages := protected.Group("/messages")
+			{
+				messages.GET("/search", s.handleSearchMessages)
+			}
+
+			// 邮箱监控管理
+			monitor := protected.Group("/monitor")
+			{
+				monitor.GET("", s.handleGetMonitors)
+				monitor.GET("/status", s.handleGetMonitorStatus)
+				monitor.PUT("/settings", auth.RequirePermission(s.authService, auth.PermSystemManage), s.handleUpdateMonitorSettings)
+				monitor.PUT("/:id", canWrite, s.handleSetMonitor)
+				monitor.DELETE("/:id", canWrite, s.handleDeleteMonitor)
+			}
+
+			// 验证码提取规则管理
+			codeRules := protected.Group("/code-rules")
+			{
+				codeRules.GET("", s.handleGetCodeRules)
+				codeRules.POST("", canWrite, s.handleCreateCodeRule)
+				codeRules.POST("/test", s.handleTestCodeRule)
+				codeRules.PUT("/:id", canWrite, s.handleUpdateCodeRule)
+				codeRules.DELETE("/:id", canWrite, s.handleDeleteCodeRule)
+			}
+
+			// 系统管理
+			admin := protected.Group("/admin")
+			{
+				upstreams := admin.Group("/upstreams")
+				upstreams.Use(auth.RequirePermission(s.authService, auth.PermSystemManage))
+				{
+					upstreams.GET("", s.handleGetUpstreams)
+					upstreams.POST("/probe", s.handleProbeUpstreams)
+				}
+
+				// 用户管理
+				users := admin.Group("/users")
+				users.Use(auth.RequirePermission(s.authService, auth.PermUserManage))
+				{
+					users.GET("", s.handleListUsers)
+					users.POST("", s.handleCreateUser)
+					users.PUT("/:id/disabled", s.handleSetUserDisabled)
+					users.PUT("/:id/role", s.handleSetUserRole)
+					users.POST("/:id/reset-password", s.handleResetPassword)
+					users.DELETE("/:id/2fa", s.handleResetTwoFactor)
+				}
 			}
 
 			// 操作日志管理
 			logs := protected.Group("/logs")
 			{
 				logs.GET("", s.handleGetLogs)
-				logs.DELETE("", s.handleClearLogs)
+				logs.DELETE("", auth.RequirePermission(s.authService, auth.PermLogManage), s.handleClearLogs)
 			}
 		}
 	}
@@ -177,15 +339,39 @@ func (s *Server) setupRouter() {
 
 // Start 启动服务器
 func (s *Server) Start() error {
+	// 启动上游端点健康探测
+	s.outlookService.Start()
+	defer s.outlookService.Stop()
+
+	// 启动后台邮箱轮询
+	if s.config.MonitorEnabled {
+		s.monitorService.Start()
+		defer s.monitorService.Stop()
+	}
+
+	// 启动后台任务执行
+	s.jobManager.Start()
+	defer s.jobManager.Stop()
+
 	return s.router.Run(":" + s.config.Port)
 }
 
+// ResumeJobs 把上次退出时未完成的后台任务放回等待队列，服务启动后继续执行，返回等待执行的任务数
+func (s *Server) ResumeJobs() (int, error) {
+	return s.jobManager.Resume()
+}
+
 // handleHealth 健康检查接口
 func (s *Server) handleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "ok",
 		"message": "Outlook取件助手服务运行正常",
 		"version": "1.0.0",
+		"upstream": gin.H{
+			"provider":  s.outlookService.DefaultProvider(),
+			"strategy":  s.outlookService.UpstreamStrategy(),
+			"endpoints": s.outlookService.UpstreamSummary(),
+		},
 	})
 }
 
@@ -202,27 +388,42 @@ func (s *Server) handleLogin(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 执行登录
-	response, err := s.authService.Login(req.AuthToken, ipAddress, userAgent)
+	response, err := s.authService.Login(&req, client)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Message: "登录失败",
-			Error:   err.Error(),
-		})
+		respondLoginError(c, err)
 		return
 	}
 
+	message := "登录成功"
+	if response.TwoFactorRequired {
+		message = "请输入两步验证码"
+	}
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "登录成功",
+		Message: message,
 		Data:    response,
 	})
 }
 
+// respondLoginError 返回登录失败响应，IP被锁定时返回429和 Retry-After
+func respondLoginError(c *gin.Context, err error) {
+	status := http.StatusUnauthorized
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		auth.WriteRetryAfter(c, lockoutErr.RetryAfter)
+		status = http.StatusTooManyRequests
+	}
+
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Message: "登录失败",
+		Error:   err.Error(),
+	})
+}
+
 // handleLogout 处理用户登出
 func (s *Server) handleLogout(c *gin.Context) {
 	// 获取当前用户ID
@@ -237,11 +438,14 @@ func (s *Server) handleLogout(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
-	// 执行登出
-	if err := s.authService.Logout(userID, ipAddress, userAgent); err != nil {
+	// 执行登出，吊销当前会话
+	sessionID := 0
+	if session, ok := auth.GetCurrentSession(c); ok {
+		sessionID = session.ID
+	}
+	if err := s.authService.Logout(userID, sessionID, client); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: "登出失败",
@@ -391,7 +595,7 @@ func (s *Server) handleDashboardStats(c *gin.Context) {
 
 	case "tags":
 		// 标记相关统计
-		tags, err := s.db.Tag.GetAllTags()
+		tags, err := s.db.Tag.GetTagsWithEmailCount(userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
@@ -448,7 +652,7 @@ func (s *Server) handleDashboardStats(c *gin.Context) {
 	default:
 		// 综合统计
 		totalEmails, _ := s.emailService.CountUserEmails(userID)
-		tags, _ := s.db.Tag.GetAllTags()
+		tags, _ := s.db.Tag.GetTagsWithEmailCount(userID)
 		operationStats, _ := s.db.Log.GetOperationStats(userID)
 
 		data = map[string]interface{}{
@@ -496,12 +700,23 @@ func (s *Server) handleGetEmails(c *gin.Context) {
 	// 获取搜索关键词
 	keyword := c.Query("keyword")
 
+	// 获取账户状态过滤参数
+	status := c.Query("status")
+	if status != "" && !models.IsValidEmailStatus(status) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的账户状态",
+			Error:   "status must be one of active, invalid_token, locked, unknown",
+		})
+		return
+	}
+
 	var emails []models.Email
 	var total int
 	var err error
 
-	if keyword != "" {
-		emails, err = s.emailService.SearchEmails(userID, keyword, limit, offset)
+	if keyword != "" || status != "" {
+		emails, err = s.emailService.SearchEmails(userID, keyword, status, limit, offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
@@ -511,7 +726,7 @@ func (s *Server) handleGetEmails(c *gin.Context) {
 			return
 		}
 		// 获取搜索结果总数
-		total, err = s.emailService.CountSearchEmails(userID, keyword)
+		total, err = s.emailService.CountSearchEmails(userID, keyword, status)
 	} else {
 		emails, err = s.emailService.GetUserEmails(userID, limit, offset)
 		if err != nil {
@@ -573,17 +788,12 @@ func (s *Server) handleAddEmail(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 添加邮箱
-	email, err := s.emailService.AddEmail(userID, &req, ipAddress, userAgent)
+	email, err := s.emailService.AddEmail(userID, &req, client)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "添加邮箱失败",
-			Error:   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, "添加邮箱失败", err)
 		return
 	}
 
@@ -616,22 +826,31 @@ func (s *Server) handleBatchAddEmails(c *gin.Context) {
 		return
 	}
 
-	// 检查数量限制
-	if len(req.Emails) > 30 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "批量添加邮箱数量不能超过30个",
-			Error:   "too many emails",
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	// 数量较多或指定 async=true 时转为后台导入任务，通过 GET /api/jobs/:id 查询进度
+	if len(req.Emails) > maxSyncBatchAdd || wantsAsync(c) {
+		job, err := s.importService.StartBatch(userID, req.Emails, client)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: "创建导入任务失败",
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, models.APIResponse{
+			Success: true,
+			Message: "邮箱数量较多，已转为后台导入任务",
+			Data:    job,
 		})
 		return
 	}
 
-	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-
 	// 批量添加邮箱
-	successEmails, errors, err := s.emailService.BatchAddEmails(userID, &req, ipAddress, userAgent)
+	successEmails, errors, err := s.emailService.BatchAddEmails(userID, &req, client)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -667,8 +886,9 @@ func (s *Server) handleImportEmails(c *gin.Context) {
 		return
 	}
 
-	// 获取上传的文件
-	file, err := c.FormFile("file")
+	// 逐个读取multipart分段，上传文件逐行加密写入任务输入，不整体读入内存
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -678,92 +898,149 @@ func (s *Server) handleImportEmails(c *gin.Context) {
 		return
 	}
 
-	// 检查文件类型
-	if file.Header.Get("Content-Type") != "text/plain" && file.Header.Get("Content-Type") != "text/csv" {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "不支持的文件类型，请上传txt或csv文件",
-			Error:   "unsupported file type",
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "文件上传失败",
+				Error:   "missing file field",
+			})
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		// 检查文件类型
+		contentType := part.Header.Get("Content-Type")
+		if contentType != "text/plain" && contentType != "text/csv" {
+			part.Close()
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "不支持的文件类型，请上传txt或csv文件",
+				Error:   "unsupported file type",
+			})
+			return
+		}
+
+		job, err := s.importService.StartFile(userID, part, auth.GetClientInfo(c))
+		part.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "创建导入任务失败",
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, models.APIResponse{
+			Success: true,
+			Message: "导入任务已启动",
+			Data:    job,
 		})
 		return
 	}
+}
 
-	// 打开文件
-	src, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+// handleGetLatestMail 获取最新邮件
+func (s *Server) handleGetLatestMail(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
 			Success: false,
-			Message: "打开文件失败",
-			Error:   err.Error(),
+			Message: "未认证",
+			Error:   "user not authenticated",
 		})
 		return
 	}
-	defer src.Close()
 
-	// 读取文件内容
-	content := make([]byte, file.Size)
-	if _, err := src.Read(content); err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Message: "读取文件失败",
-			Error:   err.Error(),
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
 		})
 		return
 	}
 
-	// 解析文件内容
-	emails, parseErrors := s.parseEmailFile(string(content))
-	if len(emails) == 0 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "文件中没有有效的邮箱数据",
-			Error:   "no valid email data found",
-		})
+	// 获取邮箱类型参数
+	mailbox := c.DefaultQuery("mailbox", "INBOX")
+	if mailbox != "INBOX" && mailbox != "Junk" {
+		mailbox = "INBOX"
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	// 获取最新邮件
+	mail, err := s.emailService.GetLatestMail(userID, emailID, mailbox, client)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "获取最新邮件失败", err)
 		return
 	}
 
-	// 检查数量限制
-	if len(emails) > 30 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取最新邮件成功",
+		Data:    mail,
+	})
+}
+
+// handleGetAllMails 获取全部邮件
+func (s *Server) handleGetAllMails(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("导入邮箱数量不能超过30个，当前文件包含%d个邮箱", len(emails)),
-			Error:   "too many emails",
+			Message: "未认证",
+			Error:   "user not authenticated",
 		})
 		return
 	}
 
-	// 批量添加邮箱
-	req := &models.BatchAddEmailRequest{Emails: emails}
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-
-	successEmails, errors, err := s.emailService.BatchAddEmails(userID, req, ipAddress, userAgent)
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Message: "批量添加邮箱失败",
-			Error:   err.Error(),
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
 		})
 		return
 	}
 
-	response := map[string]interface{}{
-		"success_emails": successEmails,
-		"errors":         append(errors, parseErrors...),
-		"success_count":  len(successEmails),
-		"error_count":    len(errors) + len(parseErrors),
-		"total_count":    len(emails) + len(parseErrors),
+	// 获取邮箱类型参数
+	mailbox := c.DefaultQuery("mailbox", "INBOX")
+	if mailbox != "INBOX" && mailbox != "Junk" {
+		mailbox = "INBOX"
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	// 获取全部邮件
+	mails, err := s.emailService.GetAllMails(userID, emailID, mailbox, client)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "获取全部邮件失败", err)
+		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "邮箱导入完成",
-		Data:    response,
+		Message: "获取全部邮件成功",
+		Data:    mails,
 	})
 }
 
-// handleGetLatestMail 获取最新邮件
-func (s *Server) handleGetLatestMail(c *gin.Context) {
+// handleGetStoredMessages 获取本地存储的邮件（分页）
+func (s *Server) handleGetStoredMessages(c *gin.Context) {
 	userID, exists := auth.GetCurrentUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
@@ -786,36 +1063,53 @@ func (s *Server) handleGetLatestMail(c *gin.Context) {
 		return
 	}
 
-	// 获取邮箱类型参数
-	mailbox := c.DefaultQuery("mailbox", "INBOX")
-	if mailbox != "INBOX" && mailbox != "Junk" {
-		mailbox = "INBOX"
+	// 获取分页参数
+	limit := 20
+	offset := 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
 	}
 
-	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	// 获取邮箱类型参数，为空时返回全部文件夹
+	mailbox := c.Query("mailbox")
+	if mailbox != "" && mailbox != "INBOX" && mailbox != "Junk" {
+		mailbox = "INBOX"
+	}
 
-	// 获取最新邮件
-	mail, err := s.emailService.GetLatestMail(userID, emailID, mailbox, ipAddress, userAgent)
+	messages, total, err := s.emailService.GetStoredMessages(userID, emailID, mailbox, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Message: "获取最新邮件失败",
+			Message: "获取本地邮件失败",
 			Error:   err.Error(),
 		})
 		return
 	}
 
+	// 返回分页格式的数据
+	response := map[string]interface{}{
+		"list":  messages,
+		"total": total,
+		"page":  (offset / limit) + 1,
+		"size":  limit,
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "获取最新邮件成功",
-		Data:    mail,
+		Message: "获取本地邮件成功",
+		Data:    response,
 	})
 }
 
-// handleGetAllMails 获取全部邮件
-func (s *Server) handleGetAllMails(c *gin.Context) {
+// handleWaitVerifyCode 等待验证码（长轮询）
+func (s *Server) handleWaitVerifyCode(c *gin.Context) {
 	userID, exists := auth.GetCurrentUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
@@ -838,31 +1132,56 @@ func (s *Server) handleGetAllMails(c *gin.Context) {
 		return
 	}
 
+	// 获取超时参数（秒），默认60秒，最长300秒
+	timeout := 60
+	if timeoutStr := c.Query("timeout"); timeoutStr != "" {
+		if t, err := strconv.Atoi(timeoutStr); err == nil && t > 0 {
+			timeout = t
+		}
+	}
+	if timeout > 300 {
+		timeout = 300
+	}
+
 	// 获取邮箱类型参数
 	mailbox := c.DefaultQuery("mailbox", "INBOX")
 	if mailbox != "INBOX" && mailbox != "Junk" {
 		mailbox = "INBOX"
 	}
 
+	opts := services.WaitCodeOptions{
+		Mailbox: mailbox,
+		Timeout: time.Duration(timeout) * time.Second,
+		From:    c.Query("from"),
+	}
+
+	// 编译主题过滤正则
+	if subjectRegex := c.Query("subject_regex"); subjectRegex != "" {
+		re, err := regexp.Compile(subjectRegex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "无效的主题正则表达式",
+				Error:   err.Error(),
+			})
+			return
+		}
+		opts.SubjectRegex = re
+	}
+
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
-	// 获取全部邮件
-	mails, err := s.emailService.GetAllMails(userID, emailID, mailbox, ipAddress, userAgent)
+	mail, err := s.emailService.WaitForVerifyCode(c.Request.Context(), userID, emailID, opts, client)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "获取全部邮件失败",
-			Error:   err.Error(),
-		})
+		respondError(c, http.StatusBadRequest, "等待验证码失败", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "获取全部邮件成功",
-		Data:    mails,
+		Message: "获取验证码成功",
+		Data:    mail,
 	})
 }
 
@@ -891,22 +1210,56 @@ func (s *Server) handleClearInbox(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 清空收件箱
-	if err := s.emailService.ClearInbox(userID, emailID, ipAddress, userAgent); err != nil {
+	if err := s.emailService.ClearInbox(userID, emailID, client); err != nil {
+		respondError(c, http.StatusBadRequest, "清空收件箱失败", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "清空收件箱成功",
+	})
+}
+
+// handleClearJunk 清空垃圾箱
+func (s *Server) handleClearJunk(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Message: "清空收件箱失败",
-			Error:   err.Error(),
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
 		})
 		return
 	}
 
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	// 清空垃圾箱
+	if err := s.emailService.ClearJunk(userID, emailID, client); err != nil {
+		respondError(c, http.StatusBadRequest, "清空垃圾箱失败", err)
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "清空收件箱成功",
+		Message: "清空垃圾箱成功",
 	})
 }
 
@@ -955,6 +1308,16 @@ func (s *Server) handleTagEmail(c *gin.Context) {
 		return
 	}
 
+	// 检查标记是否对当前用户可见
+	if _, err := s.db.Tag.GetUserTag(userID, req.TagID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "标记不存在或无权访问",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	// 为邮箱添加标记
 	if err := s.db.Tag.AddEmailTag(emailID, req.TagID); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
@@ -966,11 +1329,10 @@ func (s *Server) handleTagEmail(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogEmail(userID, "email_tagged", emailID,
 		fmt.Sprintf("为邮箱添加标记，标记ID: %d", req.TagID),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -978,6 +1340,58 @@ func (s *Server) handleTagEmail(c *gin.Context) {
 	})
 }
 
+// handleShareEmail 设置邮箱是否共享给其他用户查看
+func (s *Server) handleShareEmail(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 获取邮箱ID
+	emailIDStr := c.Param("id")
+	emailID, err := strconv.Atoi(emailIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的邮箱ID",
+			Error:   "invalid email id",
+		})
+		return
+	}
+
+	var req models.ShareEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	if err := s.emailService.SetEmailShared(userID, emailID, *req.Shared, client); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "设置邮箱共享失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "设置邮箱共享成功",
+	})
+}
+
 // handleDeleteEmail 删除邮箱
 func (s *Server) handleDeleteEmail(c *gin.Context) {
 	userID, exists := auth.GetCurrentUserID(c)
@@ -1003,11 +1417,10 @@ func (s *Server) handleDeleteEmail(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 删除邮箱
-	if err := s.emailService.DeleteEmail(userID, emailID, ipAddress, userAgent); err != nil {
+	if err := s.emailService.DeleteEmail(userID, emailID, client); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Message: "删除邮箱失败",
@@ -1024,7 +1437,7 @@ func (s *Server) handleDeleteEmail(c *gin.Context) {
 
 // handleGetTags 获取标记列表
 func (s *Server) handleGetTags(c *gin.Context) {
-	_, exists := auth.GetCurrentUserID(c)
+	userID, exists := auth.GetCurrentUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
 			Success: false,
@@ -1034,8 +1447,8 @@ func (s *Server) handleGetTags(c *gin.Context) {
 		return
 	}
 
-	// 获取所有标记
-	tags, err := s.db.Tag.GetAllTags()
+	// 获取自己的标记和共享标记
+	tags, err := s.db.Tag.GetTagsWithEmailCount(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -1075,7 +1488,7 @@ func (s *Server) handleCreateTag(c *gin.Context) {
 	}
 
 	// 检查标记名称是否已存在
-	exists, err := s.db.Tag.TagExists(req.Name)
+	exists, err := s.db.Tag.TagExists(userID, req.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -1095,9 +1508,11 @@ func (s *Server) handleCreateTag(c *gin.Context) {
 
 	// 创建标记
 	tag := &models.Tag{
+		UserID:      userID,
 		Name:        req.Name,
 		Description: req.Description,
 		Color:       req.Color,
+		Shared:      req.Shared,
 	}
 
 	createdTag, err := s.db.Tag.CreateTag(tag)
@@ -1111,11 +1526,10 @@ func (s *Server) handleCreateTag(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogTag(userID, "tag_created", createdTag.ID,
 		fmt.Sprintf("创建标记: %s", req.Name),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1159,7 +1573,7 @@ func (s *Server) handleUpdateTag(c *gin.Context) {
 	}
 
 	// 获取现有标记
-	tag, err := s.db.Tag.GetTagByID(tagID)
+	tag, err := s.db.Tag.GetUserTag(userID, tagID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, models.APIResponse{
 			Success: false,
@@ -1169,9 +1583,19 @@ func (s *Server) handleUpdateTag(c *gin.Context) {
 		return
 	}
 
+	// 共享标记只有所有者可以修改
+	if tag.UserID != userID {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "只能修改自己创建的标记",
+			Error:   "tag is owned by another user",
+		})
+		return
+	}
+
 	// 如果要更新名称，检查新名称是否已存在
 	if req.Name != "" && req.Name != tag.Name {
-		exists, err := s.db.Tag.TagExists(req.Name)
+		exists, err := s.db.Tag.TagExists(userID, req.Name)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
@@ -1198,6 +1622,9 @@ func (s *Server) handleUpdateTag(c *gin.Context) {
 	if req.Color != "" {
 		tag.Color = req.Color
 	}
+	if req.Shared != nil {
+		tag.Shared = *req.Shared
+	}
 
 	// 保存更新
 	if err := s.db.Tag.UpdateTag(tag); err != nil {
@@ -1210,11 +1637,10 @@ func (s *Server) handleUpdateTag(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogTag(userID, "tag_updated", tagID,
 		fmt.Sprintf("更新标记: %s", tag.Name),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1248,7 +1674,7 @@ func (s *Server) handleDeleteTag(c *gin.Context) {
 	}
 
 	// 获取标记信息（用于日志）
-	tag, err := s.db.Tag.GetTagByID(tagID)
+	tag, err := s.db.Tag.GetUserTag(userID, tagID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, models.APIResponse{
 			Success: false,
@@ -1258,7 +1684,17 @@ func (s *Server) handleDeleteTag(c *gin.Context) {
 		return
 	}
 
-	// 检查是否有邮箱使用此标记
+	// 共享标记只有所有者可以删除
+	if tag.UserID != userID {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "只能删除自己创建的标记",
+			Error:   "tag is owned by another user",
+		})
+		return
+	}
+
+	// 检查是否有邮箱使用此标记（包括其他用户使用共享标记的邮箱）
 	emailCount, err := s.db.Tag.GetTagEmailCount(tagID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
@@ -1279,7 +1715,7 @@ func (s *Server) handleDeleteTag(c *gin.Context) {
 	}
 
 	// 删除标记
-	if err := s.db.Tag.DeleteTag(tagID); err != nil {
+	if err := s.db.Tag.DeleteTag(userID, tagID); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: "删除标记失败",
@@ -1289,11 +1725,10 @@ func (s *Server) handleDeleteTag(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogTag(userID, "tag_deleted", tagID,
 		fmt.Sprintf("删除标记: %s", tag.Name),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1323,21 +1758,24 @@ func (s *Server) handleBatchTagEmails(c *gin.Context) {
 		return
 	}
 
-	// 验证所有邮箱都属于当前用户
-	for _, emailID := range req.EmailIDs {
-		_, err := s.emailService.GetEmailByID(userID, emailID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, models.APIResponse{
-				Success: false,
-				Message: fmt.Sprintf("邮箱ID %d 不存在或无权访问", emailID),
-				Error:   err.Error(),
-			})
-			return
+	// 验证所有邮箱都属于当前用户，后台任务中逐个验证
+	async := wantsAsync(c)
+	if !async {
+		for _, emailID := range req.EmailIDs {
+			_, err := s.emailService.GetEmailByID(userID, emailID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.APIResponse{
+					Success: false,
+					Message: fmt.Sprintf("邮箱ID %d 不存在或无权访问", emailID),
+					Error:   err.Error(),
+				})
+				return
+			}
 		}
 	}
 
-	// 验证标记是否存在
-	_, err := s.db.Tag.GetTagByID(req.TagID)
+	// 验证标记是否对当前用户可见
+	_, err := s.db.Tag.GetUserTag(userID, req.TagID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -1347,6 +1785,13 @@ func (s *Server) handleBatchTagEmails(c *gin.Context) {
 		return
 	}
 
+	// 指定 async=true 时转为后台任务
+	if async {
+		job, err := s.emailService.StartBatchTag(userID, &req, false, auth.GetClientInfo(c))
+		respondJobStarted(c, job, err)
+		return
+	}
+
 	// 批量添加标记
 	if err := s.db.Tag.BatchAddEmailTags(req.EmailIDs, req.TagID); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
@@ -1358,11 +1803,10 @@ func (s *Server) handleBatchTagEmails(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogTag(userID, "batch_tag_emails", req.TagID,
 		fmt.Sprintf("批量标记邮箱，邮箱数量: %d", len(req.EmailIDs)),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1396,21 +1840,24 @@ func (s *Server) handleBatchUntagEmails(c *gin.Context) {
 		return
 	}
 
-	// 验证所有邮箱都属于当前用户
-	for _, emailID := range req.EmailIDs {
-		_, err := s.emailService.GetEmailByID(userID, emailID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, models.APIResponse{
-				Success: false,
-				Message: fmt.Sprintf("邮箱ID %d 不存在或无权访问", emailID),
-				Error:   err.Error(),
-			})
-			return
+	// 验证所有邮箱都属于当前用户，后台任务中逐个验证
+	async := wantsAsync(c)
+	if !async {
+		for _, emailID := range req.EmailIDs {
+			_, err := s.emailService.GetEmailByID(userID, emailID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, models.APIResponse{
+					Success: false,
+					Message: fmt.Sprintf("邮箱ID %d 不存在或无权访问", emailID),
+					Error:   err.Error(),
+				})
+				return
+			}
 		}
 	}
 
-	// 验证标记是否存在
-	_, err := s.db.Tag.GetTagByID(req.TagID)
+	// 验证标记是否对当前用户可见
+	_, err := s.db.Tag.GetUserTag(userID, req.TagID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
@@ -1420,6 +1867,13 @@ func (s *Server) handleBatchUntagEmails(c *gin.Context) {
 		return
 	}
 
+	// 指定 async=true 时转为后台任务
+	if async {
+		job, err := s.emailService.StartBatchTag(userID, &req, true, auth.GetClientInfo(c))
+		respondJobStarted(c, job, err)
+		return
+	}
+
 	// 批量移除标记
 	if err := s.db.Tag.BatchRemoveEmailTags(req.EmailIDs, req.TagID); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
@@ -1431,11 +1885,10 @@ func (s *Server) handleBatchUntagEmails(c *gin.Context) {
 	}
 
 	// 记录操作日志
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 	s.db.Log.LogTag(userID, "batch_untag_emails", req.TagID,
 		fmt.Sprintf("批量取消标记邮箱，邮箱数量: %d", len(req.EmailIDs)),
-		ipAddress, userAgent)
+		client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1447,60 +1900,6 @@ func (s *Server) handleBatchUntagEmails(c *gin.Context) {
 	})
 }
 
-// parseEmailFile 解析邮箱文件
-func (s *Server) parseEmailFile(content string) ([]models.AddEmailRequest, []string) {
-	var emails []models.AddEmailRequest
-	var errors []string
-
-	lines := strings.Split(content, "\n")
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		// 支持两种格式：
-		// 1. 邮箱----密码----客户端ID----RefreshToken
-		// 2. CSV格式：邮箱,密码,客户端ID,RefreshToken,备注
-		var parts []string
-		if strings.Contains(line, "----") {
-			parts = strings.Split(line, "----")
-		} else if strings.Contains(line, ",") {
-			parts = strings.Split(line, ",")
-		} else {
-			errors = append(errors, fmt.Sprintf("第%d行格式错误: %s", i+1, line))
-			continue
-		}
-
-		if len(parts) < 4 {
-			errors = append(errors, fmt.Sprintf("第%d行数据不完整: %s", i+1, line))
-			continue
-		}
-
-		email := models.AddEmailRequest{
-			EmailAddress: strings.TrimSpace(parts[0]),
-			Password:     strings.TrimSpace(parts[1]),
-			ClientID:     strings.TrimSpace(parts[2]),
-			RefreshToken: strings.TrimSpace(parts[3]),
-		}
-
-		// 如果有第5个字段，作为备注
-		if len(parts) > 4 {
-			email.Remark = strings.TrimSpace(parts[4])
-		}
-
-		// 基本验证
-		if email.EmailAddress == "" || email.Password == "" || email.ClientID == "" || email.RefreshToken == "" {
-			errors = append(errors, fmt.Sprintf("第%d行必填字段为空: %s", i+1, line))
-			continue
-		}
-
-		emails = append(emails, email)
-	}
-
-	return emails, errors
-}
-
 // handleBatchDeleteEmails 批量删除邮箱
 func (s *Server) handleBatchDeleteEmails(c *gin.Context) {
 	userID, exists := auth.GetCurrentUserID(c)
@@ -1535,11 +1934,10 @@ func (s *Server) handleBatchDeleteEmails(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 批量删除邮箱
-	if err := s.emailService.BatchDeleteEmails(userID, req.EmailIDs, ipAddress, userAgent); err != nil {
+	if err := s.emailService.BatchDeleteEmails(userID, req.EmailIDs, client); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: "批量删除邮箱失败",
@@ -1588,11 +1986,17 @@ func (s *Server) handleBatchClearInbox(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
+
+	// 指定 async=true 时转为后台任务
+	if wantsAsync(c) {
+		job, err := s.emailService.StartBatchClear(userID, req.EmailIDs, false, client)
+		respondJobStarted(c, job, err)
+		return
+	}
 
-	// 批量清空收件箱
-	successCount, errors, err := s.emailService.BatchClearInbox(userID, req.EmailIDs, ipAddress, userAgent)
+	// 并发批量清空收件箱，客户端断开后不再处理剩余邮箱
+	results, err := s.emailService.BatchClearInbox(c.Request.Context(), userID, req.EmailIDs, client)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -1602,10 +2006,12 @@ func (s *Server) handleBatchClearInbox(c *gin.Context) {
 		return
 	}
 
+	successCount, errors := summarizeClearResults(results)
 	response := map[string]interface{}{
 		"success_count": successCount,
 		"error_count":   len(errors),
 		"errors":        errors,
+		"results":       results,
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
@@ -1615,6 +2021,92 @@ func (s *Server) handleBatchClearInbox(c *gin.Context) {
 	})
 }
 
+// summarizeClearResults 统计批量清空的成功数量，并按顺序整理失败信息
+func summarizeClearResults(results []models.JobItem) (int, []string) {
+	successCount := 0
+	errors := []string{}
+	for _, result := range results {
+		switch {
+		case result.Success:
+			successCount++
+		case result.EmailAddress != "":
+			errors = append(errors, fmt.Sprintf("邮箱 %s: %s", result.EmailAddress, result.Error))
+		default:
+			errors = append(errors, fmt.Sprintf("邮箱ID %d: %s", result.EmailID, result.Error))
+		}
+	}
+	return successCount, errors
+}
+
+// handleBatchClearJunk 批量清空垃圾箱
+func (s *Server) handleBatchClearJunk(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req struct {
+		EmailIDs []int `json:"email_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if len(req.EmailIDs) == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "邮箱ID列表不能为空",
+			Error:   "email_ids cannot be empty",
+		})
+		return
+	}
+
+	// 获取客户端信息
+	client := auth.GetClientInfo(c)
+
+	// 指定 async=true 时转为后台任务
+	if wantsAsync(c) {
+		job, err := s.emailService.StartBatchClear(userID, req.EmailIDs, true, client)
+		respondJobStarted(c, job, err)
+		return
+	}
+
+	// 并发批量清空垃圾箱，客户端断开后不再处理剩余邮箱
+	results, err := s.emailService.BatchClearJunk(c.Request.Context(), userID, req.EmailIDs, client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "批量清空垃圾箱失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	successCount, errors := summarizeClearResults(results)
+	response := map[string]interface{}{
+		"success_count": successCount,
+		"error_count":   len(errors),
+		"errors":        errors,
+		"results":       results,
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("批量清空垃圾箱完成，成功: %d, 失败: %d", successCount, len(errors)),
+		Data:    response,
+	})
+}
+
 // handleGetLogs 获取操作日志（分页）
 func (s *Server) handleGetLogs(c *gin.Context) {
 	userID, exists := auth.GetCurrentUserID(c)
@@ -1701,9 +2193,8 @@ func (s *Server) handleClearLogs(c *gin.Context) {
 	}
 
 	// 记录清空日志的操作
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-	s.db.Log.LogAuth(userID, "clear_all_logs", "清空所有操作日志", ipAddress, userAgent)
+	client := auth.GetClientInfo(c)
+	s.db.Log.LogAuth(userID, "clear_all_logs", "清空所有操作日志", client)
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
@@ -1734,11 +2225,10 @@ func (s *Server) handleExportEmails(c *gin.Context) {
 	}
 
 	// 获取客户端信息
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
+	client := auth.GetClientInfo(c)
 
 	// 调用邮件服务导出邮箱
-	response, err := s.emailService.ExportEmails(userID, &req, ipAddress, userAgent)
+	response, err := s.emailService.ExportEmails(userID, &req, client)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleRefreshToken 刷新令牌，返回新令牌后旧令牌立即失效
+func (s *Server) handleRefreshToken(c *gin.Context) {
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	response, err := s.authService.RefreshToken(tokenString, auth.GetClientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "刷新令牌失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "刷新令牌成功",
+		Data:    response,
+	})
+}
+
+// handleListSessions 获取当前用户的活跃登录会话
+func (s *Server) handleListSessions(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	currentID := 0
+	if session, ok := auth.GetCurrentSession(c); ok {
+		currentID = session.ID
+	}
+
+	sessions, err := s.authService.ListSessions(userID, currentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取登录会话失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取登录会话成功",
+		Data:    sessions,
+	})
+}
+
+// handleRevokeSession 吊销指定登录会话
+func (s *Server) handleRevokeSession(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的会话ID",
+			Error:   "invalid session id",
+		})
+		return
+	}
+
+	if err := s.authService.RevokeSession(userID, sessionID, auth.GetClientInfo(c)); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: "吊销登录会话失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "吊销登录会话成功",
+	})
+}
+
+// handleRevokeOtherSessions 吊销当前会话以外的所有登录会话
+func (s *Server) handleRevokeOtherSessions(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	currentID := 0
+	if session, ok := auth.GetCurrentSession(c); ok {
+		currentID = session.ID
+	}
+
+	count, err := s.authService.RevokeOtherSessions(userID, currentID, auth.GetClientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "吊销登录会话失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "吊销其他登录会话成功",
+		Data: map[string]interface{}{
+			"revoked_count": count,
+		},
+	})
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleVerifyTwoFactor 登录第二步：提交挑战令牌和动态码（或恢复码）换取正式令牌
+func (s *Server) handleVerifyTwoFactor(c *gin.Context) {
+	var req models.VerifyTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	response, err := s.authService.VerifyTwoFactor(&req, auth.GetClientInfo(c))
+	if err != nil {
+		respondLoginError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "登录成功",
+		Data:    response,
+	})
+}
+
+// handleGetTwoFactorStatus 获取当前用户的两步验证状态
+func (s *Server) handleGetTwoFactorStatus(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	status, err := s.authService.GetTwoFactorStatus(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取两步验证状态失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取两步验证状态成功",
+		Data:    status,
+	})
+}
+
+// handleSetupTwoFactor 生成TOTP密钥和二维码地址，验证动态码后才会启用
+func (s *Server) handleSetupTwoFactor(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	result, err := s.authService.SetupTwoFactor(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "生成两步验证密钥失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "请使用验证器App扫描二维码，并提交动态码完成启用",
+		Data:    result,
+	})
+}
+
+// handleEnableTwoFactor 验证动态码后启用两步验证，恢复码只在响应中返回一次
+func (s *Server) handleEnableTwoFactor(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result, err := s.authService.EnableTwoFactor(userID, req.Code, auth.GetClientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "启用两步验证失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "启用两步验证成功，请立即保存恢复码，之后将无法再次查看",
+		Data:    result,
+	})
+}
+
+// handleDisableTwoFactor 验证动态码或恢复码后停用两步验证
+func (s *Server) handleDisableTwoFactor(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := s.authService.DisableTwoFactor(userID, req.Code, auth.GetClientInfo(c)); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "停用两步验证失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "停用两步验证成功",
+	})
+}
+
+// handleRegenerateRecoveryCodes 重新生成恢复码，旧恢复码全部失效
+func (s *Server) handleRegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	result, err := s.authService.RegenerateRecoveryCodes(userID, req.Code, auth.GetClientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "重新生成恢复码失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "重新生成恢复码成功，请立即保存，之后将无法再次查看",
+		Data:    result,
+	})
+}
+
+// handleResetTwoFactor 管理员为丢失验证器的用户停用两步验证
+func (s *Server) handleResetTwoFactor(c *gin.Context) {
+	operatorID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的用户ID",
+			Error:   "invalid user id",
+		})
+		return
+	}
+
+	if err := s.authService.ResetTwoFactor(userID, auth.GetClientInfo(c), operatorID); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "重置两步验证失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "重置两步验证成功",
+	})
+}
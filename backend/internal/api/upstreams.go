@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetUpstreams 获取上游端点统计
+func (s *Server) handleGetUpstreams(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取上游端点统计成功",
+		Data: gin.H{
+			"strategy":  s.outlookService.UpstreamStrategy(),
+			"endpoints": s.outlookService.UpstreamStats(),
+		},
+	})
+}
+
+// handleProbeUpstreams 立即探测所有上游端点
+func (s *Server) handleProbeUpstreams(c *gin.Context) {
+	s.outlookService.ProbeUpstreams()
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "上游端点探测完成",
+		Data: gin.H{
+			"strategy":  s.outlookService.UpstreamStrategy(),
+			"endpoints": s.outlookService.UpstreamStats(),
+		},
+	})
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"outlook-helper/backend/internal/auth"
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListUsers 获取用户列表
+func (s *Server) handleListUsers(c *gin.Context) {
+	users, err := s.authService.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "获取用户列表失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "获取用户列表成功",
+		Data:    users,
+	})
+}
+
+// handleCreateUser 创建用户
+func (s *Server) handleCreateUser(c *gin.Context) {
+	operatorID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	user, err := s.authService.CreateUser(&req, auth.GetClientInfo(c), operatorID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "创建用户失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "创建用户成功",
+		Data:    user,
+	})
+}
+
+// handleSetUserDisabled 启用或禁用用户
+func (s *Server) handleSetUserDisabled(c *gin.Context) {
+	operatorID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的用户ID",
+			Error:   "invalid user id",
+		})
+		return
+	}
+
+	var req models.SetUserDisabledRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	user, err := s.authService.SetUserDisabled(userID, *req.Disabled, auth.GetClientInfo(c), operatorID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "更新用户状态失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	message := "启用用户成功"
+	if user.Disabled {
+		message = "禁用用户成功"
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    user,
+	})
+}
+
+// handleSetUserRole 修改用户角色
+func (s *Server) handleSetUserRole(c *gin.Context) {
+	operatorID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的用户ID",
+			Error:   "invalid user id",
+		})
+		return
+	}
+
+	var req models.SetUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	user, err := s.authService.SetUserRole(userID, req.Role, auth.GetClientInfo(c), operatorID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "修改用户角色失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "修改用户角色成功",
+		Data:    user,
+	})
+}
+
+// handleResetPassword 重置用户密码
+func (s *Server) handleResetPassword(c *gin.Context) {
+	operatorID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "无效的用户ID",
+			Error:   "invalid user id",
+		})
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := s.authService.ResetPassword(userID, req.Password, auth.GetClientInfo(c), operatorID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "重置密码失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "重置密码成功",
+	})
+}
+
+// handleChangePassword 修改当前用户密码
+func (s *Server) handleChangePassword(c *gin.Context) {
+	userID, exists := auth.GetCurrentUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "未认证",
+			Error:   "user not authenticated",
+		})
+		return
+	}
+
+	// 保留当前会话，其他会话在修改密码后失效
+	sessionID := 0
+	if session, ok := auth.GetCurrentSession(c); ok {
+		sessionID = session.ID
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "请求参数错误",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := s.authService.ChangePassword(userID, sessionID, req.OldPassword, req.NewPassword, auth.GetClientInfo(c)); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "修改密码失败",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "修改密码成功",
+	})
+}
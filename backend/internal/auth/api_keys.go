@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// APIKeyHeader API密钥请求头
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix API密钥前缀，便于在日志和代码仓库中识别泄露的密钥
+const apiKeyPrefix = "ohk_"
+
+// apiKeyTouchInterval 最后使用时间的更新间隔，避免每个请求都写数据库
+const apiKeyTouchInterval = time.Minute
+
+// ErrInvalidAPIKey API密钥无效、已吊销或已过期
+var ErrInvalidAPIKey = errors.New("API密钥无效、已吊销或已过期")
+
+// CreateAPIKey 为用户创建API密钥，权限范围不能超过用户角色的权限。返回的完整密钥只出现这一次
+func (s *Service) CreateAPIKey(userID int, req *models.CreateAPIKeyRequest, client models.ClientInfo) (*models.CreateAPIKeyResponse, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+
+		if !isKnownPermission(Permission(scope)) {
+			return nil, fmt.Errorf("未知的权限范围: %s", scope)
+		}
+		if !HasPermission(user.Role, Permission(scope)) {
+			return nil, fmt.Errorf("当前角色没有权限: %s", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		UserID:  userID,
+		Name:    req.Name,
+		Prefix:  rawKey[:len(apiKeyPrefix)+6],
+		KeyHash: hashAPIKey(rawKey),
+		Scopes:  scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+
+	created, err := s.apiKeyRepo.CreateAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logRepo.LogAuth(userID, "api_key_created",
+		fmt.Sprintf("创建API密钥: %s（%s，权限 %s）", created.Name, created.Prefix, strings.Join(scopes, ",")),
+		client)
+
+	return &models.CreateAPIKeyResponse{Key: rawKey, APIKey: created}, nil
+}
+
+// ListAPIKeys 获取用户的API密钥列表
+func (s *Service) ListAPIKeys(userID int) ([]models.APIKey, error) {
+	return s.apiKeyRepo.GetAPIKeysByUserID(userID)
+}
+
+// RevokeAPIKey 吊销用户的API密钥，吊销后立即失效
+func (s *Service) RevokeAPIKey(userID, keyID int, client models.ClientInfo) error {
+	if err := s.apiKeyRepo.RevokeAPIKey(userID, keyID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("API密钥不存在或已吊销")
+		}
+		return err
+	}
+
+	s.logRepo.LogAuth(userID, "api_key_revoked", fmt.Sprintf("吊销API密钥: %d", keyID), client)
+	return nil
+}
+
+// ValidateAPIKey 验证API密钥，返回密钥所属用户
+func (s *Service) ValidateAPIKey(rawKey string) (*models.User, *models.APIKey, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.apiKeyRepo.GetAPIKeyByHash(hashAPIKey(rawKey))
+	if err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.userRepo.GetUserByID(key.UserID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if user.Disabled {
+		return nil, nil, ErrUserDisabled
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		s.apiKeyRepo.TouchAPIKey(key.ID, now)
+		key.LastUsedAt = &now
+	}
+
+	user.PasswordHash = ""
+	return user, key, nil
+}
+
+// apiKeyAllows 检查API密钥的权限范围是否包含权限
+func apiKeyAllows(key *models.APIKey, perm Permission) bool {
+	for _, scope := range key.Scopes {
+		if Permission(scope) == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// generateAPIKey 生成随机API密钥
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashAPIKey 计算API密钥的SHA-256哈希。密钥是高熵随机值，不需要慢哈希
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
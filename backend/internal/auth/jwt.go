@@ -9,6 +9,13 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// 令牌用途，写入 sub，防止两步验证的挑战令牌、SSE票据被当作登录令牌使用
+const (
+	authTokenSubject      = "user-auth"
+	challengeTokenSubject = "2fa-challenge"
+	sseTicketSubject      = "sse-ticket"
+)
+
 // JWTClaims JWT声明结构
 type JWTClaims struct {
 	UserID   int    `json:"user_id"`
@@ -30,19 +37,20 @@ func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
 	}
 }
 
-// GenerateToken 生成JWT令牌
-func (manager *JWTManager) GenerateToken(user *models.User) (string, int64, error) {
+// GenerateToken 生成JWT令牌，tokenID 写入 jti，用于关联服务端会话
+func (manager *JWTManager) GenerateToken(user *models.User, tokenID string) (string, int64, error) {
 	expiresAt := time.Now().Add(manager.tokenDuration)
 	
 	claims := &JWTClaims{
 		UserID:   user.ID,
 		Username: user.Username,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "outlook-helper",
-			Subject:   "user-auth",
+			Subject:   authTokenSubject,
 		},
 	}
 
@@ -55,8 +63,75 @@ func (manager *JWTManager) GenerateToken(user *models.User) (string, int64, erro
 	return tokenString, expiresAt.Unix(), nil
 }
 
+// GenerateChallengeToken 生成两步验证的挑战令牌，只能用于提交动态码，不能访问接口
+func (manager *JWTManager) GenerateChallengeToken(user *models.User, duration time.Duration) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(duration)
+
+	claims := &JWTClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "outlook-helper",
+			Subject:   challengeTokenSubject,
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(manager.secretKey))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return tokenString, expiresAt.Unix(), nil
+}
+
+// GenerateSSETicket 生成SSE连接票据，tokenID 为所属会话的 jti，会话失效后票据随之失效。
+// 票据会出现在URL中，只能用于建立事件推送连接
+func (manager *JWTManager) GenerateSSETicket(user *models.User, tokenID string, duration time.Duration) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(duration)
+
+	claims := &JWTClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "outlook-helper",
+			Subject:   sseTicketSubject,
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(manager.secretKey))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return tokenString, expiresAt.Unix(), nil
+}
+
 // ValidateToken 验证JWT令牌
 func (manager *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
+	return manager.parseToken(tokenString, authTokenSubject)
+}
+
+// ValidateChallengeToken 验证两步验证的挑战令牌
+func (manager *JWTManager) ValidateChallengeToken(tokenString string) (*JWTClaims, error) {
+	return manager.parseToken(tokenString, challengeTokenSubject)
+}
+
+// ValidateSSETicket 验证SSE连接票据
+func (manager *JWTManager) ValidateSSETicket(tokenString string) (*JWTClaims, error) {
+	return manager.parseToken(tokenString, sseTicketSubject)
+}
+
+// parseToken 验证令牌签名、有效期和用途
+func (manager *JWTManager) parseToken(tokenString, subject string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&JWTClaims{},
@@ -74,7 +149,7 @@ func (manager *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error)
 	}
 
 	claims, ok := token.Claims.(*JWTClaims)
-	if !ok || !token.Valid {
+	if !ok || !token.Valid || claims.Subject != subject {
 		return nil, errors.New("invalid token")
 	}
 
@@ -86,8 +161,8 @@ func (manager *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error)
 	return claims, nil
 }
 
-// RefreshToken 刷新JWT令牌
-func (manager *JWTManager) RefreshToken(tokenString string) (string, int64, error) {
+// RefreshToken 刷新JWT令牌，新令牌使用新的 jti
+func (manager *JWTManager) RefreshToken(tokenString, newTokenID string) (string, int64, error) {
 	claims, err := manager.ValidateToken(tokenString)
 	if err != nil {
 		return "", 0, err
@@ -105,7 +180,7 @@ func (manager *JWTManager) RefreshToken(tokenString string) (string, int64, erro
 		Username: claims.Username,
 	}
 
-	return manager.GenerateToken(user)
+	return manager.GenerateToken(user, newTokenID)
 }
 
 // ExtractUserID 从令牌中提取用户ID
@@ -9,11 +9,37 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware 认证中间件
+// AuthMiddleware 认证中间件，支持 Authorization: Bearer <JWT> 和 X-API-Key 两种方式
 func AuthMiddleware(authService *Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 脚本使用API密钥认证
+		if rawKey := c.GetHeader(APIKeyHeader); rawKey != "" {
+			user, key, err := authService.ValidateAPIKey(rawKey)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, models.APIResponse{
+					Success: false,
+					Message: "无效的API密钥",
+					Error:   err.Error(),
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("user", user)
+			c.Set("user_id", user.ID)
+			c.Set("username", user.Username)
+			c.Set("api_key", key)
+			// 操作日志记录使用的API密钥
+			c.Set("api_key_id", key.ID)
+			c.Set("api_key_prefix", key.Prefix)
+
+			c.Next()
+			return
+		}
+
 		// 获取Authorization头
 		authHeader := c.GetHeader("Authorization")
+
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
@@ -48,8 +74,8 @@ func AuthMiddleware(authService *Service) gin.HandlerFunc {
 			return
 		}
 
-		// 验证令牌
-		user, err := authService.ValidateToken(tokenString)
+		// 验证令牌和服务端会话
+		user, session, err := authService.ValidateSession(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
@@ -64,6 +90,40 @@ func AuthMiddleware(authService *Service) gin.HandlerFunc {
 		c.Set("user", user)
 		c.Set("user_id", user.ID)
 		c.Set("username", user.Username)
+		c.Set("session", session)
+
+		c.Next()
+	}
+}
+
+// EventStreamAuthMiddleware SSE连接认证中间件。EventSource无法设置请求头，
+// 通过 ?ticket= 传递 IssueSSETicket 签发的短期票据；不接受把登录令牌放在URL中。
+// 没有票据时按 AuthMiddleware 校验请求头
+func EventStreamAuthMiddleware(authService *Service) gin.HandlerFunc {
+	headerAuth := AuthMiddleware(authService)
+
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			headerAuth(c)
+			return
+		}
+
+		user, session, err := authService.ValidateSSETicket(ticket)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "无效的连接票据",
+				Error:   err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("session", session)
 
 		c.Next()
 	}
@@ -103,47 +163,6 @@ func OptionalAuthMiddleware(authService *Service) gin.HandlerFunc {
 	}
 }
 
-// AdminMiddleware 管理员权限中间件
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "需要认证",
-				Error:   "authentication required",
-			})
-			c.Abort()
-			return
-		}
-
-		userObj, ok := user.(*models.User)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: "用户信息错误",
-				Error:   "invalid user object",
-			})
-			c.Abort()
-			return
-		}
-
-		// 检查是否为管理员（这里简单地检查用户名是否为admin）
-		// 在实际项目中，应该有专门的角色权限系统
-		if userObj.Username != "admin" {
-			c.JSON(http.StatusForbidden, models.APIResponse{
-				Success: false,
-				Message: "需要管理员权限",
-				Error:   "admin privileges required",
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // GetCurrentUser 从上下文中获取当前用户
 func GetCurrentUser(c *gin.Context) (*models.User, bool) {
 	user, exists := c.Get("user")
@@ -159,6 +178,43 @@ func GetCurrentUser(c *gin.Context) (*models.User, bool) {
 	return userObj, true
 }
 
+// GetCurrentAPIKey 获取当前请求使用的API密钥，使用JWT认证时返回false
+func GetCurrentAPIKey(c *gin.Context) (*models.APIKey, bool) {
+	key, exists := c.Get("api_key")
+	if !exists {
+		return nil, false
+	}
+
+	keyObj, ok := key.(*models.APIKey)
+	return keyObj, ok
+}
+
+// GetCurrentSession 获取当前请求的登录会话，使用API密钥认证时返回false
+func GetCurrentSession(c *gin.Context) (*models.Session, bool) {
+	session, exists := c.Get("session")
+	if !exists {
+		return nil, false
+	}
+
+	sessionObj, ok := session.(*models.Session)
+	return sessionObj, ok
+}
+
+// GetClientInfo 获取发起请求的客户端信息，使用API密钥认证时包含密钥ID和前缀
+func GetClientInfo(c *gin.Context) models.ClientInfo {
+	client := models.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+	if keyID, ok := c.Get("api_key_id"); ok {
+		if id, ok := keyID.(int); ok {
+			client.APIKeyID = &id
+		}
+		client.APIKeyPrefix = c.GetString("api_key_prefix")
+	}
+	return client
+}
+
 // GetCurrentUserID 从上下文中获取当前用户ID
 func GetCurrentUserID(c *gin.Context) (int, bool) {
 	userID, exists := c.Get("user_id")
@@ -207,7 +263,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -219,15 +275,6 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// RateLimitMiddleware 简单的速率限制中间件
-func RateLimitMiddleware() gin.HandlerFunc {
-	// 这里可以实现基于IP的速率限制
-	// 为了简化，暂时返回空的中间件
-	return func(c *gin.Context) {
-		c.Next()
-	}
-}
-
 // LoggingMiddleware 请求日志中间件
 func LoggingMiddleware() gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetClientInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/emails", nil)
+	c.Request.Header.Set("User-Agent", "script/1.0")
+
+	client := GetClientInfo(c)
+	if client.UserAgent != "script/1.0" || client.APIKeyID != nil || client.APIKeyPrefix != "" {
+		t.Fatalf("session client = %+v, want user agent only", client)
+	}
+
+	c.Set("api_key_id", 7)
+	c.Set("api_key_prefix", "ohk_abc")
+	client = GetClientInfo(c)
+	if client.APIKeyID == nil || *client.APIKeyID != 7 || client.APIKeyPrefix != "ohk_abc" {
+		t.Fatalf("api key client = %+v, want key 7 ohk_abc", client)
+	}
+	// User-Agent 保持客户端原值，不混入密钥信息
+	if client.UserAgent != "script/1.0" {
+		t.Fatalf("user agent = %q, want %q", client.UserAgent, "script/1.0")
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission 操作权限
+type Permission string
+
+const (
+	PermEmailRead        Permission = "email:read"        // 查看邮箱、邮件和日志
+	PermEmailWrite       Permission = "email:write"       // 添加邮箱、管理标记、监控和验证码规则
+	PermEmailDelete      Permission = "email:delete"      // 删除邮箱
+	PermMailClear        Permission = "mail:clear"        // 清空收件箱和垃圾箱
+	PermCredentialExport Permission = "credential:export" // 导出邮箱凭据
+	PermLogManage        Permission = "log:manage"        // 清理操作日志
+	PermUserManage       Permission = "user:manage"       // 管理用户
+	PermSystemManage     Permission = "system:manage"     // 管理上游服务和监控设置
+)
+
+// rolePermissions 各角色拥有的权限
+var rolePermissions = map[string][]Permission{
+	models.UserRoleOwner: {
+		PermEmailRead, PermEmailWrite, PermEmailDelete, PermMailClear, PermCredentialExport,
+		PermLogManage, PermUserManage, PermSystemManage,
+	},
+	models.UserRoleOperator: {
+		PermEmailRead, PermEmailWrite, PermEmailDelete, PermMailClear, PermCredentialExport,
+	},
+	models.UserRoleViewer: {
+		PermEmailRead,
+	},
+}
+
+// isKnownPermission 检查权限是否存在（所有者拥有全部权限）
+func isKnownPermission(perm Permission) bool {
+	return HasPermission(models.UserRoleOwner, perm)
+}
+
+// HasPermission 检查角色是否拥有权限
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission 权限中间件，需在 AuthMiddleware 之后使用，拒绝的请求记录到操作日志。
+// 使用API密钥认证时，权限还必须在密钥的权限范围内
+func RequirePermission(authService *Service, perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := GetCurrentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success: false,
+				Message: "需要认证",
+				Error:   "authentication required",
+			})
+			c.Abort()
+			return
+		}
+
+		key, viaAPIKey := GetCurrentAPIKey(c)
+		if !HasPermission(user.Role, perm) || (viaAPIKey && !apiKeyAllows(key, perm)) {
+			description := fmt.Sprintf("权限不足: %s %s（需要 %s，当前角色 %s）", c.Request.Method, c.Request.URL.Path, perm, user.Role)
+			if viaAPIKey {
+				description = fmt.Sprintf("权限不足: %s %s（需要 %s，API密钥 %s 的权限范围 %s）", c.Request.Method, c.Request.URL.Path, perm, key.Prefix, strings.Join(key.Scopes, ","))
+			}
+			authService.logRepo.LogAuth(user.ID, "permission_denied", description, GetClientInfo(c))
+
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "权限不足",
+				Error:   fmt.Sprintf("permission denied: %s", perm),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SessionOnly 只允许登录会话访问，拒绝API密钥，用于密钥管理和修改密码等敏感操作
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, viaAPIKey := GetCurrentAPIKey(c); viaAPIKey {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "该操作不支持API密钥，请登录后操作",
+				Error:   "api key not allowed",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bucketIdleTTL 令牌桶闲置多久后清理
+const bucketIdleTTL = 10 * time.Minute
+
+// RateLimiter 令牌桶限流器，按键（客户端IP）分别计数
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // 每秒补充的令牌数
+	burst     float64 // 桶容量
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket 单个键的令牌桶
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	lastSeen time.Time
+}
+
+// NewRateLimiter 创建限流器，每分钟允许 perMinute 个请求，允许突发 burst 个。perMinute 不大于0时返回nil（不限流）
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &RateLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow 消耗一个令牌，令牌不足时返回false和需要等待的时间
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	// 按经过的时间补充令牌
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep 定期清理闲置的令牌桶（调用方需持有锁）
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware 按客户端IP和路由组限流，超出限制返回429并设置 Retry-After。limiter为nil时不限流
+func RateLimitMiddleware(limiter *RateLimiter, group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.Allow(group + ":" + c.ClientIP()); !ok {
+			abortTooManyRequests(c, wait, "请求过于频繁，请稍后再试", "rate limit exceeded")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// LoginGuard 登录失败锁定：同一IP在锁定时长内连续失败达到上限后锁定该IP
+type LoginGuard struct {
+	mu          sync.Mutex
+	maxFailures int
+	lockout     time.Duration
+	attempts    map[string]*loginAttempts
+}
+
+// loginAttempts 单个IP的登录失败记录
+type loginAttempts struct {
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// LockoutError 登录已被锁定
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请 %d 秒后再试", retryAfterSeconds(e.RetryAfter))
+}
+
+// NewLoginGuard 创建登录锁定器，maxFailures 不大于0时返回nil（不锁定）
+func NewLoginGuard(maxFailures int, lockout time.Duration) *LoginGuard {
+	if maxFailures <= 0 || lockout <= 0 {
+		return nil
+	}
+	return &LoginGuard{
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		attempts:    make(map[string]*loginAttempts),
+	}
+}
+
+// Check IP被锁定时返回 LockoutError
+func (g *LoginGuard) Check(ip string) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if a, ok := g.attempts[ip]; ok {
+		if wait := time.Until(a.lockedUntil); wait > 0 {
+			return &LockoutError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// Fail 记录一次登录失败，本次失败触发锁定时返回true
+func (g *LoginGuard) Fail(ip string) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	a, ok := g.attempts[ip]
+	if !ok || now.Sub(a.firstFailed) > g.lockout {
+		a = &loginAttempts{firstFailed: now}
+		g.attempts[ip] = a
+	}
+
+	a.failures++
+	if a.failures >= g.maxFailures {
+		a.lockedUntil = now.Add(g.lockout)
+		a.failures = 0
+		a.firstFailed = now
+		return true
+	}
+	return false
+}
+
+// Succeed 登录成功后清除失败记录
+func (g *LoginGuard) Succeed(ip string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.attempts, ip)
+}
+
+// prune 清理已过期的失败记录（调用方需持有锁）
+func (g *LoginGuard) prune(now time.Time) {
+	for ip, a := range g.attempts {
+		if now.After(a.lockedUntil) && now.Sub(a.firstFailed) > g.lockout {
+			delete(g.attempts, ip)
+		}
+	}
+}
+
+// abortTooManyRequests 返回429并设置 Retry-After
+func abortTooManyRequests(c *gin.Context, wait time.Duration, message, errMsg string) {
+	WriteRetryAfter(c, wait)
+	c.JSON(http.StatusTooManyRequests, models.APIResponse{
+		Success: false,
+		Message: message,
+		Error:   errMsg,
+	})
+	c.Abort()
+}
+
+// WriteRetryAfter 设置 Retry-After 响应头
+func WriteRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+}
+
+// retryAfterSeconds 等待时间向上取整到秒，至少1秒
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
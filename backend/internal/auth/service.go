@@ -1,7 +1,12 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
 	"outlook-helper/backend/internal/config"
@@ -9,11 +14,32 @@ import (
 	"outlook-helper/backend/internal/models"
 )
 
+// 认证错误
+var (
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	ErrUserDisabled       = errors.New("账户已禁用")
+	ErrUserNotFound       = errors.New("用户不存在")
+	ErrSessionRevoked     = errors.New("会话已失效，请重新登录")
+)
+
+const (
+	// sessionTouchInterval 会话最后活跃时间的更新间隔
+	sessionTouchInterval = time.Minute
+	// expiredSessionRetention 过期会话的保留时间
+	expiredSessionRetention = 7 * 24 * time.Hour
+	// sseTicketTTL SSE连接票据有效期，只在建立连接时校验，断线重连需重新申请
+	sseTicketTTL = time.Minute
+)
+
 // Service 认证服务
 type Service struct {
 	userRepo   *database.UserRepository
 	logRepo    *database.LogRepository
+	apiKeyRepo *database.APIKeyRepository
+	sessions   *database.SessionRepository
+	totpRepo   *database.TOTPRepository
 	jwtManager *JWTManager
+	loginGuard *LoginGuard
 	config     *config.Config
 }
 
@@ -24,112 +50,368 @@ func NewService(db *database.DB, jwtSecret string, jwtExpire int, cfg *config.Co
 	return &Service{
 		userRepo:   db.User,
 		logRepo:    db.Log,
+		apiKeyRepo: db.APIKey,
+		sessions:   db.Session,
+		totpRepo:   db.TOTP,
 		jwtManager: jwtManager,
+		loginGuard: NewLoginGuard(cfg.LoginMaxFailures, time.Duration(cfg.LoginLockoutMinutes)*time.Minute),
 		config:     cfg,
 	}
 }
 
-// Login 用户登录
-func (s *Service) Login(authToken, ipAddress, userAgent string) (*models.LoginResponse, error) {
-	// 验证授权码是否与环境变量配置匹配
-	if authToken != s.config.AuthToken {
-		// 记录登录失败日志
-		s.logRepo.LogAuth(0, "login_failed", "授权码错误", ipAddress, userAgent)
-		return nil, errors.New("授权码错误")
+// Login 用户登录：使用用户名密码登录；提供授权码时以初始管理员身份登录。
+// 同一IP连续失败过多时锁定，锁定期间返回 LockoutError。
+// 用户启用了两步验证时只返回挑战令牌，需再调用 VerifyTwoFactor 完成登录
+func (s *Service) Login(req *models.LoginRequest, client models.ClientInfo) (*models.LoginResponse, error) {
+	if err := s.loginGuard.Check(client.IPAddress); err != nil {
+		return nil, err
 	}
 
-	// 创建虚拟用户对象（用于生成JWT令牌）
-	user := &models.User{
-		ID:       1,       // 固定ID
-		Username: "admin", // 固定用户名
+	var user *models.User
+	var err error
+	if req.AuthToken != "" {
+		user, err = s.loginWithAuthToken(req.AuthToken, client)
+	} else {
+		user, err = s.loginWithPassword(req.Username, req.Password, client)
+	}
+	if err != nil {
+		s.recordLoginFailure(client)
+		return nil, err
 	}
 
-	// 生成JWT令牌
-	token, expiresAt, err := s.jwtManager.GenerateToken(user)
+	enabled, err := s.twoFactorEnabled(user.ID)
 	if err != nil {
-		return nil, errors.New("生成令牌失败")
+		return nil, err
+	}
+	if enabled {
+		// 失败计数在完成第二步后才清除，避免反复通过第一步来重置动态码的猜测次数
+		challenge, expiresAt, err := s.jwtManager.GenerateChallengeToken(user, twoFactorChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResponse{
+			ExpiresAt:         expiresAt,
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}, nil
+	}
+
+	return s.completeLogin(user, client)
+}
+
+// recordLoginFailure 记录一次登录失败，达到上限时锁定IP并记录日志
+func (s *Service) recordLoginFailure(client models.ClientInfo) {
+	if s.loginGuard.Fail(client.IPAddress) {
+		s.logRepo.LogAuth(s.anonymousLogUserID(), "login_failed",
+			fmt.Sprintf("连续登录失败 %d 次，锁定IP %s %d 分钟", s.config.LoginMaxFailures, client.IPAddress, s.config.LoginLockoutMinutes),
+			client)
+	}
+}
+
+// completeLogin 身份验证通过后创建会话并签发令牌
+func (s *Service) completeLogin(user *models.User, client models.ClientInfo) (*models.LoginResponse, error) {
+	s.loginGuard.Succeed(client.IPAddress)
+
+	// 生成JWT令牌并创建会话
+	token, expiresAt, err := s.startSession(user, client)
+	if err != nil {
+		return nil, err
 	}
 
+	// 更新最后登录时间
+	s.userRepo.UpdateLastLogin(user.ID)
+
 	// 记录登录成功日志
-	s.logRepo.LogAuth(user.ID, "login_success", "用户登录成功", ipAddress, userAgent)
+	s.logRepo.LogAuth(user.ID, "login_success", "用户登录成功: "+user.Username, client)
 
 	// 构造响应
+	user.PasswordHash = ""
 	response := &models.LoginResponse{
 		Token:     token,
 		ExpiresAt: expiresAt,
-		User: models.User{
-			ID:       user.ID,
-			Username: user.Username,
-		},
+		User:      user,
 	}
 
 	return response, nil
 }
 
-// Logout 用户登出
-func (s *Service) Logout(userID int, ipAddress, userAgent string) error {
+// loginWithAuthToken 验证授权码，返回初始管理员
+func (s *Service) loginWithAuthToken(authToken string, client models.ClientInfo) (*models.User, error) {
+	// 验证授权码是否与环境变量配置匹配
+	if subtle.ConstantTimeCompare([]byte(authToken), []byte(s.config.AuthToken)) != 1 {
+		// 记录登录失败日志
+		s.logRepo.LogAuth(s.anonymousLogUserID(), "login_failed", "授权码错误", client)
+		return nil, errors.New("授权码错误")
+	}
+
+	user, err := s.userRepo.GetUserByUsername(database.BootstrapAdminUsername)
+	if err != nil {
+		return nil, errors.New("初始管理员不存在")
+	}
+	return user, nil
+}
+
+// anonymousLogUserID 无法确定用户的登录失败记录到初始管理员的操作日志（日志必须关联用户）
+func (s *Service) anonymousLogUserID() int {
+	admin, err := s.userRepo.GetUserByUsername(database.BootstrapAdminUsername)
+	if err != nil {
+		return 0
+	}
+	return admin.ID
+}
+
+// loginWithPassword 验证用户名和密码
+func (s *Service) loginWithPassword(username, password string, client models.ClientInfo) (*models.User, error) {
+	if username == "" || password == "" {
+		return nil, errors.New("请输入用户名和密码")
+	}
+
+	user, err := s.userRepo.GetUserByUsername(username)
+	if err != nil {
+		s.logRepo.LogAuth(s.anonymousLogUserID(), "login_failed", "用户不存在: "+username, client)
+		return nil, ErrInvalidCredentials
+	}
+
+	if !s.userRepo.ValidatePassword(user, password) {
+		s.logRepo.LogAuth(user.ID, "login_failed", "密码错误", client)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Disabled {
+		s.logRepo.LogAuth(user.ID, "login_failed", "账户已禁用", client)
+		return nil, ErrUserDisabled
+	}
+
+	return user, nil
+}
+
+// Logout 用户登出，吊销当前会话使令牌立即失效
+func (s *Service) Logout(userID, sessionID int, client models.ClientInfo) error {
+	if sessionID > 0 {
+		if err := s.sessions.RevokeSession(userID, sessionID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
 	// 记录登出日志
-	return s.logRepo.LogAuth(userID, "logout", "用户登出", ipAddress, userAgent)
+	return s.logRepo.LogAuth(userID, "logout", "用户登出", client)
 }
 
-// ValidateToken 验证令牌
+// ValidateToken 验证令牌，用户被删除或禁用、会话被吊销后令牌立即失效
 func (s *Service) ValidateToken(tokenString string) (*models.User, error) {
+	user, _, err := s.ValidateSession(tokenString)
+	return user, err
+}
+
+// ValidateSession 验证令牌及其对应的服务端会话，返回用户和会话
+func (s *Service) ValidateSession(tokenString string) (*models.User, *models.Session, error) {
 	// 验证JWT令牌
 	claims, err := s.jwtManager.ValidateToken(tokenString)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// 创建虚拟用户对象（基于JWT声明）
-	user := &models.User{
-		ID:       claims.UserID,
-		Username: claims.Username,
+	session, err := s.activeSession(claims)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return user, nil
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, nil, errors.New("用户不存在")
+	}
+	if user.Disabled {
+		return nil, nil, ErrUserDisabled
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		s.sessions.TouchSession(session.ID, now)
+		session.LastSeenAt = now
+	}
+
+	user.PasswordHash = ""
+	return user, session, nil
+}
+
+// IssueSSETicket 为当前会话签发SSE连接票据，EventSource无法设置请求头，
+// 通过短期票据代替登录令牌放在URL中，避免登录令牌写入访问日志
+func (s *Service) IssueSSETicket(user *models.User, session *models.Session) (string, int64, error) {
+	return s.jwtManager.GenerateSSETicket(user, session.TokenID, sseTicketTTL)
+}
+
+// ValidateSSETicket 验证SSE连接票据及其所属会话，返回用户和会话
+func (s *Service) ValidateSSETicket(ticket string) (*models.User, *models.Session, error) {
+	claims, err := s.jwtManager.ValidateSSETicket(ticket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := s.activeSession(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if user.Disabled {
+		return nil, nil, ErrUserDisabled
+	}
+
+	user.PasswordHash = ""
+	return user, session, nil
 }
 
-// RefreshToken 刷新令牌
-func (s *Service) RefreshToken(tokenString string, ipAddress, userAgent string) (*models.LoginResponse, error) {
+// RefreshToken 刷新令牌：签发新令牌并轮换会话的 jti，旧令牌随即失效
+func (s *Service) RefreshToken(tokenString string, client models.ClientInfo) (*models.LoginResponse, error) {
 	// 验证当前令牌
 	claims, err := s.jwtManager.ValidateToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
+	session, err := s.activeSession(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	// 获取用户信息
 	user, err := s.userRepo.GetUserByID(claims.UserID)
 	if err != nil {
 		return nil, errors.New("用户不存在")
 	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
 
 	// 生成新令牌
-	newToken, expiresAt, err := s.jwtManager.RefreshToken(tokenString)
+	tokenID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+	newToken, expiresAt, err := s.jwtManager.RefreshToken(tokenString, tokenID)
 	if err != nil {
 		return nil, err
 	}
 
+	// 并发刷新时只有一个请求能轮换成功
+	if err := s.sessions.RotateToken(session.ID, claims.ID, tokenID, time.Unix(expiresAt, 0)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+
 	// 记录令牌刷新日志
-	s.logRepo.LogAuth(user.ID, "token_refresh", "令牌刷新", ipAddress, userAgent)
+	s.logRepo.LogAuth(user.ID, "token_refresh", "令牌刷新", client)
 
 	// 构造响应
+	user.PasswordHash = ""
 	response := &models.LoginResponse{
 		Token:     newToken,
 		ExpiresAt: expiresAt,
-		User: models.User{
-			ID:          user.ID,
-			Username:    user.Username,
-			LastLoginAt: user.LastLoginAt,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-		},
+		User:      user,
 	}
 
 	return response, nil
 }
 
-// ChangePassword 修改密码
-func (s *Service) ChangePassword(userID int, oldPassword, newPassword, ipAddress, userAgent string) error {
+// ListSessions 获取用户的活跃会话，currentID 对应的会话标记为当前会话
+func (s *Service) ListSessions(userID, currentID int) ([]models.Session, error) {
+	sessions, err := s.sessions.GetActiveSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// RevokeSession 吊销用户的某个会话
+func (s *Service) RevokeSession(userID, sessionID int, client models.ClientInfo) error {
+	if err := s.sessions.RevokeSession(userID, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("会话不存在或已失效")
+		}
+		return err
+	}
+
+	s.logRepo.LogAuth(userID, "session_revoked", fmt.Sprintf("吊销登录会话: %d", sessionID), client)
+	return nil
+}
+
+// RevokeOtherSessions 吊销用户除当前会话以外的所有会话，返回吊销的数量
+func (s *Service) RevokeOtherSessions(userID, currentID int, client models.ClientInfo) (int, error) {
+	count, err := s.sessions.RevokeUserSessions(userID, currentID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logRepo.LogAuth(userID, "session_revoked", fmt.Sprintf("吊销其他登录会话: %d 个", count), client)
+	return count, nil
+}
+
+// startSession 生成令牌并创建对应的会话
+func (s *Service) startSession(user *models.User, client models.ClientInfo) (string, int64, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", 0, err
+	}
+
+	token, expiresAt, err := s.jwtManager.GenerateToken(user, tokenID)
+	if err != nil {
+		return "", 0, errors.New("生成令牌失败")
+	}
+
+	_, err = s.sessions.CreateSession(&models.Session{
+		UserID:    user.ID,
+		TokenID:   tokenID,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		ExpiresAt: time.Unix(expiresAt, 0),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	// 顺便清理早已过期的会话
+	s.sessions.DeleteExpiredSessions(expiredSessionRetention)
+
+	return token, expiresAt, nil
+}
+
+// activeSession 查找令牌对应的会话，会话不存在、已吊销或已过期时返回错误。
+// 升级前签发的令牌没有 jti，需要重新登录
+func (s *Service) activeSession(claims *JWTClaims) (*models.Session, error) {
+	if claims.ID == "" {
+		return nil, ErrSessionRevoked
+	}
+
+	session, err := s.sessions.GetSessionByTokenID(claims.ID)
+	if err != nil {
+		return nil, ErrSessionRevoked
+	}
+	if session.UserID != claims.UserID || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+	return session, nil
+}
+
+// newTokenID 生成随机令牌ID（jti）
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ChangePassword 修改密码，成功后吊销除当前会话以外的所有会话
+func (s *Service) ChangePassword(userID, currentSessionID int, oldPassword, newPassword string, client models.ClientInfo) error {
 	// 获取用户
 	user, err := s.userRepo.GetUserByID(userID)
 	if err != nil {
@@ -139,22 +421,20 @@ func (s *Service) ChangePassword(userID int, oldPassword, newPassword, ipAddress
 	// 验证旧密码
 	if !s.userRepo.ValidatePassword(user, oldPassword) {
 		// 记录密码修改失败日志
-		s.logRepo.LogAuth(userID, "password_change_failed", "旧密码错误", ipAddress, userAgent)
+		s.logRepo.LogAuth(userID, "password_change_failed", "旧密码错误", client)
 		return errors.New("旧密码错误")
 	}
 
-	// 创建新用户对象用于更新密码
-	newUser, err := s.userRepo.CreateUser(user.Username+"_temp", newPassword)
-	if err != nil {
-		return errors.New("密码加密失败")
+	if err := s.userRepo.UpdatePassword(userID, newPassword); err != nil {
+		return err
 	}
 
-	// 更新用户密码（这里需要在UserRepository中添加UpdatePassword方法）
-	// 暂时使用临时方案
-	user.PasswordHash = newUser.PasswordHash
+	if _, err := s.sessions.RevokeUserSessions(userID, currentSessionID); err != nil {
+		return err
+	}
 
 	// 记录密码修改成功日志
-	s.logRepo.LogAuth(userID, "password_changed", "密码修改成功", ipAddress, userAgent)
+	s.logRepo.LogAuth(userID, "password_changed", "密码修改成功", client)
 
 	return nil
 }
@@ -172,9 +452,9 @@ func (s *Service) GetUserInfo(userID int) (*models.User, error) {
 }
 
 // CreateUser 创建用户（管理员功能）
-func (s *Service) CreateUser(username, password, ipAddress, userAgent string, operatorID int) (*models.User, error) {
+func (s *Service) CreateUser(req *models.CreateUserRequest, client models.ClientInfo, operatorID int) (*models.User, error) {
 	// 检查用户名是否已存在
-	exists, err := s.userRepo.UserExists(username)
+	exists, err := s.userRepo.UserExists(req.Username)
 	if err != nil {
 		return nil, err
 	}
@@ -183,20 +463,104 @@ func (s *Service) CreateUser(username, password, ipAddress, userAgent string, op
 		return nil, errors.New("用户名已存在")
 	}
 
+	role := req.Role
+	if role == "" {
+		role = models.UserRoleOperator
+	}
+
 	// 创建用户
-	user, err := s.userRepo.CreateUser(username, password)
+	user, err := s.userRepo.CreateUser(req.Username, req.Password, role)
 	if err != nil {
 		return nil, err
 	}
 
 	// 记录用户创建日志
-	s.logRepo.LogAuth(operatorID, "user_created", "创建用户: "+username, ipAddress, userAgent)
+	s.logRepo.LogAuth(operatorID, "user_created", fmt.Sprintf("创建用户: %s（角色 %s）", req.Username, role), client)
 
 	// 不返回密码哈希
 	user.PasswordHash = ""
 	return user, nil
 }
 
+// ListUsers 获取所有用户（管理员功能）
+func (s *Service) ListUsers() ([]models.User, error) {
+	users, err := s.userRepo.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		users[i].PasswordHash = ""
+	}
+	return users, nil
+}
+
+// SetUserDisabled 启用或禁用用户（管理员功能），不能禁用自己和初始管理员
+func (s *Service) SetUserDisabled(userID int, disabled bool, client models.ClientInfo, operatorID int) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if disabled && (userID == operatorID || user.Username == database.BootstrapAdminUsername) {
+		return nil, errors.New("不能禁用当前用户或初始管理员")
+	}
+
+	if err := s.userRepo.SetDisabled(userID, disabled); err != nil {
+		return nil, err
+	}
+
+	operation, description := "user_enabled", "启用用户: "
+	if disabled {
+		operation, description = "user_disabled", "禁用用户: "
+	}
+	s.logRepo.LogAuth(operatorID, operation, description+user.Username, client)
+
+	user.Disabled = disabled
+	user.PasswordHash = ""
+	return user, nil
+}
+
+// SetUserRole 修改用户角色（管理员功能），不能修改自己和初始管理员的角色
+func (s *Service) SetUserRole(userID int, role string, client models.ClientInfo, operatorID int) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if userID == operatorID || user.Username == database.BootstrapAdminUsername {
+		return nil, errors.New("不能修改当前用户或初始管理员的角色")
+	}
+
+	if err := s.userRepo.SetRole(userID, role); err != nil {
+		return nil, err
+	}
+
+	s.logRepo.LogAuth(operatorID, "user_role_changed", fmt.Sprintf("修改用户角色: %s %s -> %s", user.Username, user.Role, role), client)
+
+	user.Role = role
+	user.PasswordHash = ""
+	return user, nil
+}
+
+// ResetPassword 重置用户密码（管理员功能），用户的所有会话随即失效
+func (s *Service) ResetPassword(userID int, password string, client models.ClientInfo, operatorID int) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.userRepo.UpdatePassword(userID, password); err != nil {
+		return err
+	}
+	if _, err := s.sessions.RevokeUserSessions(userID, 0); err != nil {
+		return err
+	}
+
+	s.logRepo.LogAuth(operatorID, "password_reset", "重置用户密码: "+user.Username, client)
+	return nil
+}
+
 // GetJWTManager 获取JWT管理器（用于中间件）
 func (s *Service) GetJWTManager() *JWTManager {
 	return s.jwtManager
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// twoFactorChallengeTTL 挑战令牌有效期，需在此时间内提交动态码
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// 两步验证错误
+var (
+	ErrInvalidTwoFactorCode = errors.New("动态码或恢复码错误")
+	ErrInvalidChallenge     = errors.New("两步验证已超时，请重新登录")
+	ErrTwoFactorEnabled     = errors.New("两步验证已启用")
+	ErrTwoFactorNotEnabled  = errors.New("未启用两步验证")
+)
+
+// VerifyTwoFactor 登录第二步：校验挑战令牌和动态码（或恢复码）后签发正式令牌
+func (s *Service) VerifyTwoFactor(req *models.VerifyTwoFactorRequest, client models.ClientInfo) (*models.LoginResponse, error) {
+	if err := s.loginGuard.Check(client.IPAddress); err != nil {
+		return nil, err
+	}
+
+	claims, err := s.jwtManager.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	if err := s.verifySecondFactor(user.ID, req.Code, client); err != nil {
+		s.logRepo.LogAuth(user.ID, "login_failed", "两步验证失败: "+err.Error(), client)
+		s.recordLoginFailure(client)
+		return nil, err
+	}
+
+	return s.completeLogin(user, client)
+}
+
+// GetTwoFactorStatus 获取用户的两步验证状态
+func (s *Service) GetTwoFactorStatus(userID int) (*models.TwoFactorStatus, error) {
+	totp, err := s.totpRepo.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.Enabled) {
+		return &models.TwoFactorStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remaining, err := s.totpRepo.CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorStatus{
+		Enabled:                true,
+		EnabledAt:              totp.EnabledAt,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// SetupTwoFactor 生成新的TOTP密钥，需调用 EnableTwoFactor 验证一次动态码后才会启用
+func (s *Service) SetupTwoFactor(userID int) (*models.TwoFactorSetupResponse, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.totpRepo.SaveSecret(userID, secret); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, err
+	}
+
+	return &models.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(s.config.AppName, user.Username, secret),
+	}, nil
+}
+
+// EnableTwoFactor 验证动态码后启用两步验证，返回一次性恢复码
+func (s *Service) EnableTwoFactor(userID int, code string, client models.ClientInfo) (*models.TwoFactorRecoveryCodesResponse, error) {
+	totp, err := s.totpRepo.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := matchTOTP(totp.Secret, normalizeTOTPCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.totpRepo.Enable(userID, step, hashes); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, err
+	}
+
+	s.logRepo.LogAuth(userID, "two_factor_enabled", "启用两步验证", client)
+	return &models.TwoFactorRecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTwoFactor 验证动态码或恢复码后停用两步验证
+func (s *Service) DisableTwoFactor(userID int, code string, client models.ClientInfo) error {
+	if err := s.verifySecondFactor(userID, code, client); err != nil {
+		return err
+	}
+
+	if err := s.totpRepo.Disable(userID); err != nil {
+		return err
+	}
+
+	s.logRepo.LogAuth(userID, "two_factor_disabled", "停用两步验证", client)
+	return nil
+}
+
+// RegenerateRecoveryCodes 验证动态码或恢复码后重新生成恢复码，旧恢复码全部失效
+func (s *Service) RegenerateRecoveryCodes(userID int, code string, client models.ClientInfo) (*models.TwoFactorRecoveryCodesResponse, error) {
+	if err := s.verifySecondFactor(userID, code, client); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.totpRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	s.logRepo.LogAuth(userID, "two_factor_recovery_regenerated", "重新生成两步验证恢复码", client)
+	return &models.TwoFactorRecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// ResetTwoFactor 管理员为丢失验证器的用户停用两步验证
+func (s *Service) ResetTwoFactor(userID int, client models.ClientInfo, operatorID int) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	enabled, err := s.twoFactorEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := s.totpRepo.Disable(userID); err != nil {
+		return err
+	}
+
+	s.logRepo.LogAuth(operatorID, "two_factor_disabled", "重置用户两步验证: "+user.Username, client)
+	return nil
+}
+
+// twoFactorEnabled 用户是否已启用两步验证
+func (s *Service) twoFactorEnabled(userID int) (bool, error) {
+	totp, err := s.totpRepo.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.Enabled, nil
+}
+
+// verifySecondFactor 校验动态码或恢复码。动态码的时间片只能使用一次，恢复码使用后作废
+func (s *Service) verifySecondFactor(userID int, code string, client models.ClientInfo) error {
+	totp, err := s.totpRepo.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.Enabled) {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	if step, ok := matchTOTP(totp.Secret, normalizeTOTPCode(code), time.Now()); ok {
+		if err := s.totpRepo.UseStep(userID, step); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidTwoFactorCode
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := s.totpRepo.UseRecoveryCode(userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidTwoFactorCode
+		}
+		return err
+	}
+
+	remaining, _ := s.totpRepo.CountRecoveryCodes(userID)
+	s.logRepo.LogAuth(userID, "two_factor_recovery_used", fmt.Sprintf("使用恢复码通过两步验证，剩余 %d 个", remaining), client)
+	return nil
+}
+
+// newRecoveryCodes 生成恢复码及其哈希
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeTOTPCode 去掉动态码中的空格
+func normalizeTOTPCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
@@ -4,24 +4,57 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 // Config 应用配置结构
 type Config struct {
-	AppName                string
-	Port                   string
-	Env                    string
-	DBPath                 string
-	JWTSecret              string
-	JWTExpire              int
-	OutlookAPI             string
-	LogLevel               string
-	LogFile                string
-	SkipEmailValidation    bool   // 是否跳过邮箱验证（调试用）
-	EmailValidationWorkers int    // 邮箱验证并发数
-	AuthToken              string // 授权码（必须配置）
+	AppName                 string
+	Port                    string
+	Env                     string
+	DBPath                  string
+	JWTSecret               string
+	JWTExpire               int
+	OutlookAPI              string
+	OutlookAPIEndpoints     string // 多个上游端点，格式“地址|权重”，逗号分隔
+	OutlookAPIStrategy      string // 端点选择策略：round_robin / least_failures
+	OutlookAPIProbeInterval int    // 端点健康探测间隔（秒，0表示不探测）
+	MailProvider            string // 默认邮件后端：proxy（msOauth2api代理）/ graph（直连Microsoft Graph）
+	MailTokenURL            string // graph后端换取访问令牌的OAuth2令牌端点
+	MailGraphBaseURL        string // graph后端的Graph API地址
+	MailGraphScope          string // graph后端换取访问令牌时申请的权限范围
+	LogLevel                string
+	LogFile                 string
+	SkipEmailValidation     bool     // 是否跳过邮箱验证（调试用）
+	EmailValidationWorkers  int      // 邮箱验证并发数
+	ImportRatePerSecond     int      // 导入邮箱时每秒最多验证的账户数（所有导入任务共享，0表示不限制）
+	ImportChunkSize         int      // 导入邮箱时每次提交到数据库的数量
+	JobWorkers              int      // 同时执行的后台任务数
+	JobRetentionHours       int      // 已结束的后台任务保留时间（小时）
+	AuthToken               string   // 授权码（必须配置）
+	MonitorEnabled          bool     // 是否启用后台邮箱轮询
+	MonitorInterval         int      // 轮询间隔（秒）
+	MonitorWorkers          int      // 轮询并发数
+	HealthCheckInvalidTag   string   // 健康检查自动标记失效账户使用的标签名
+	CredentialKey           string   // 邮箱凭据加密主密钥（base64编码的32字节）
+	CredentialKeyFile       string   // 邮箱凭据加密主密钥文件，未配置 CredentialKey 时使用
+	CredentialNewKey        string   // 轮换密钥时使用的新主密钥（仅 rotate-credential-key 命令使用）
+	CredentialNewKeyFile    string   // 轮换密钥时使用的新主密钥文件
+	RateLimitLoginPerMinute int      // 每个IP每分钟允许的登录请求数（0表示不限制）
+	RateLimitAPIPerMinute   int      // 每个IP每分钟允许的API请求数（0表示不限制）
+	LoginMaxFailures        int      // 同一IP连续登录失败多少次后锁定（0表示不锁定）
+	LoginLockoutMinutes     int      // 登录锁定时长（分钟）
+	TrustedProxies          []string // 可信反向代理地址，只信任这些代理传递的 X-Forwarded-For
+
+	OutlookRetryMaxAttempts   int    // Outlook API最大尝试次数（含首次请求）
+	OutlookRetryBaseDelayMs   int    // 首次重试等待时间（毫秒）
+	OutlookRetryMaxDelayMs    int    // 重试等待时间上限（毫秒）
+	OutlookRetryJitterPercent int    // 重试等待时间随机抖动比例（百分比）
+	OutlookRetryStatuses      string // 可重试的上游状态码（逗号分隔）
+	OutlookBreakerThreshold   int    // 连续失败多少次后熔断（0表示不启用）
+	OutlookBreakerCooldown    int    // 熔断冷却时间（秒）
 }
 
 // Load 加载配置
@@ -32,12 +65,17 @@ func Load() (*Config, error) {
 	// 检查必须的环境变量
 	authToken := os.Getenv("AUTH_TOKEN")
 	outlookAPI := os.Getenv("OUTLOOK_API_BASE_URL")
+	outlookEndpoints := os.Getenv("OUTLOOK_API_ENDPOINTS")
+	mailProvider := getEnv("MAIL_PROVIDER", "proxy")
+
+	// 默认使用graph后端时无需配置代理地址
+	requireOutlookAPI := mailProvider != "graph" && outlookAPI == "" && outlookEndpoints == ""
 
 	var missingVars []string
 	if authToken == "" {
 		missingVars = append(missingVars, "AUTH_TOKEN")
 	}
-	if outlookAPI == "" {
+	if requireOutlookAPI {
 		missingVars = append(missingVars, "OUTLOOK_API_BASE_URL")
 	}
 
@@ -51,7 +89,7 @@ func Load() (*Config, error) {
 		if authToken == "" {
 			fmt.Println("AUTH_TOKEN=your-super-secret-auth-token")
 		}
-		if outlookAPI == "" {
+		if requireOutlookAPI {
 			fmt.Println("OUTLOOK_API_BASE_URL=https://your-outlook-api-domain.vercel.app")
 		}
 		fmt.Println()
@@ -62,18 +100,50 @@ func Load() (*Config, error) {
 	}
 
 	cfg := &Config{
-		AppName:                getEnv("APP_NAME", "Outlook取件助手"),
-		Port:                   getEnv("APP_PORT", "8080"),
-		Env:                    getEnv("APP_ENV", "development"),
-		DBPath:                 getEnv("DB_PATH", "./data/outlook_helper.db"),
-		JWTSecret:              getEnv("JWT_SECRET", "default-secret-change-this"),
-		JWTExpire:              getEnvAsInt("JWT_EXPIRE_HOURS", 6),
-		OutlookAPI:             outlookAPI,
-		LogLevel:               getEnv("LOG_LEVEL", "info"),
-		LogFile:                getEnv("LOG_FILE", "./logs/app.log"),
-		SkipEmailValidation:    getEnvAsBool("SKIP_EMAIL_VALIDATION", false),
-		EmailValidationWorkers: getEnvAsInt("EMAIL_VALIDATION_WORKERS", 5),
-		AuthToken:              authToken,
+		AppName:                 getEnv("APP_NAME", "Outlook取件助手"),
+		Port:                    getEnv("APP_PORT", "8080"),
+		Env:                     getEnv("APP_ENV", "development"),
+		DBPath:                  getEnv("DB_PATH", "./data/outlook_helper.db"),
+		JWTSecret:               getEnv("JWT_SECRET", "default-secret-change-this"),
+		JWTExpire:               getEnvAsInt("JWT_EXPIRE_HOURS", 6),
+		OutlookAPI:              outlookAPI,
+		OutlookAPIEndpoints:     outlookEndpoints,
+		OutlookAPIStrategy:      getEnv("OUTLOOK_API_STRATEGY", "round_robin"),
+		OutlookAPIProbeInterval: getEnvAsInt("OUTLOOK_API_PROBE_INTERVAL_SECONDS", 60),
+		MailProvider:            mailProvider,
+		MailTokenURL:            getEnv("MAIL_TOKEN_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/token"),
+		MailGraphBaseURL:        getEnv("MAIL_GRAPH_BASE_URL", "https://graph.microsoft.com/v1.0"),
+		MailGraphScope:          getEnv("MAIL_GRAPH_SCOPE", "https://graph.microsoft.com/.default offline_access"),
+		LogLevel:                getEnv("LOG_LEVEL", "info"),
+		LogFile:                 getEnv("LOG_FILE", "./logs/app.log"),
+		SkipEmailValidation:     getEnvAsBool("SKIP_EMAIL_VALIDATION", false),
+		EmailValidationWorkers:  getEnvAsInt("EMAIL_VALIDATION_WORKERS", 5),
+		ImportRatePerSecond:     getEnvAsInt("IMPORT_RATE_PER_SECOND", 10),
+		ImportChunkSize:         getEnvAsInt("IMPORT_CHUNK_SIZE", 100),
+		JobWorkers:              getEnvAsInt("JOB_WORKERS", 2),
+		JobRetentionHours:       getEnvAsInt("JOB_RETENTION_HOURS", 168),
+		AuthToken:               authToken,
+		MonitorEnabled:          getEnvAsBool("MONITOR_ENABLED", true),
+		MonitorInterval:         getEnvAsInt("MONITOR_INTERVAL_SECONDS", 180),
+		MonitorWorkers:          getEnvAsInt("MONITOR_WORKERS", 3),
+		HealthCheckInvalidTag:   getEnv("HEALTH_CHECK_INVALID_TAG", "失效"),
+		CredentialKey:           os.Getenv("CREDENTIAL_KEY"),
+		CredentialKeyFile:       os.Getenv("CREDENTIAL_KEY_FILE"),
+		CredentialNewKey:        os.Getenv("CREDENTIAL_NEW_KEY"),
+		CredentialNewKeyFile:    os.Getenv("CREDENTIAL_NEW_KEY_FILE"),
+		RateLimitLoginPerMinute: getEnvAsInt("RATE_LIMIT_LOGIN_PER_MINUTE", 10),
+		RateLimitAPIPerMinute:   getEnvAsInt("RATE_LIMIT_API_PER_MINUTE", 600),
+		LoginMaxFailures:        getEnvAsInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockoutMinutes:     getEnvAsInt("LOGIN_LOCKOUT_MINUTES", 15),
+		TrustedProxies:          getEnvAsList("TRUSTED_PROXIES"),
+
+		OutlookRetryMaxAttempts:   getEnvAsInt("OUTLOOK_RETRY_MAX_ATTEMPTS", 3),
+		OutlookRetryBaseDelayMs:   getEnvAsInt("OUTLOOK_RETRY_BASE_DELAY_MS", 500),
+		OutlookRetryMaxDelayMs:    getEnvAsInt("OUTLOOK_RETRY_MAX_DELAY_MS", 5000),
+		OutlookRetryJitterPercent: getEnvAsInt("OUTLOOK_RETRY_JITTER_PERCENT", 20),
+		OutlookRetryStatuses:      getEnv("OUTLOOK_RETRY_STATUSES", "429,500,502,503,504"),
+		OutlookBreakerThreshold:   getEnvAsInt("OUTLOOK_BREAKER_THRESHOLD", 5),
+		OutlookBreakerCooldown:    getEnvAsInt("OUTLOOK_BREAKER_COOLDOWN_SECONDS", 30),
 	}
 
 	return cfg, nil
@@ -106,3 +176,14 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsList 获取逗号分隔的环境变量列表，忽略空项
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -3,18 +3,40 @@ package constants
 // 操作类型常量
 const (
 	// 认证相关
-	OpLoginSuccess = "login_success"
-	OpLoginFailed  = "login_failed"
-	OpLogout       = "logout"
-	OpClearAllLogs = "clear_all_logs"
+	OpLoginSuccess   = "login_success"
+	OpLoginFailed    = "login_failed"
+	OpLogout         = "logout"
+	OpTokenRefresh   = "token_refresh"
+	OpSessionRevoked = "session_revoked"
+	OpClearAllLogs   = "clear_all_logs"
+
+	// 用户管理相关
+	OpUserCreated          = "user_created"
+	OpUserDisabled         = "user_disabled"
+	OpUserEnabled          = "user_enabled"
+	OpPasswordReset        = "password_reset"
+	OpPasswordChanged      = "password_changed"
+	OpPasswordChangeFailed = "password_change_failed"
+	OpUserRoleChanged      = "user_role_changed"
+	OpPermissionDenied     = "permission_denied"
+	OpAPIKeyCreated        = "api_key_created"
+	OpAPIKeyRevoked        = "api_key_revoked"
+	OpTwoFactorEnabled     = "two_factor_enabled"
+	OpTwoFactorDisabled    = "two_factor_disabled"
+	OpRecoveryCodeUsed     = "two_factor_recovery_used"
+	OpRecoveryCodesRenewed = "two_factor_recovery_regenerated"
 
 	// 邮箱相关
 	OpEmailAdded            = "email_added"
 	OpEmailDeleted          = "email_deleted"
 	OpEmailUpdated          = "email_updated"
+	OpEmailShared           = "email_shared"
 	OpEmailValidationFailed = "email_validation_failed"
 	OpBatchAddEmails        = "batch_add_emails"
 	OpBatchDeleteEmails     = "batch_delete_emails"
+	OpHealthCheck           = "health_check"
+	OpRefreshTokenRotated   = "refresh_token_rotated"
+	OpJobCancelled          = "job_cancelled"
 
 	// 邮件操作相关
 	OpGetLatestMail       = "get_latest_mail"
@@ -25,6 +47,21 @@ const (
 	OpClearInboxFailed    = "clear_inbox_failed"
 	OpClearJunk           = "clear_junk"
 	OpClearJunkFailed     = "clear_junk_failed"
+	OpBatchClearInbox     = "batch_clear_inbox"
+	OpBatchClearJunk      = "batch_clear_junk"
+	OpWaitVerifyCode      = "wait_verify_code"
+	OpWaitVerifyCodeFail  = "wait_verify_code_failed"
+
+	// 邮箱监控相关
+	OpMonitorEnabled   = "monitor_enabled"
+	OpMonitorDisabled  = "monitor_disabled"
+	OpNewMailDetected  = "new_mail_detected"
+	OpMonitorCheckFail = "monitor_check_failed"
+
+	// 验证码规则相关
+	OpCodeRuleCreated = "code_rule_created"
+	OpCodeRuleUpdated = "code_rule_updated"
+	OpCodeRuleDeleted = "code_rule_deleted"
 
 	// 标签相关
 	OpTagCreated       = "tag_created"
@@ -39,18 +76,40 @@ const (
 // 操作类型中文映射
 var OperationTypeNames = map[string]string{
 	// 认证相关
-	OpLoginSuccess: "登录成功",
-	OpLoginFailed:  "登录失败",
-	OpLogout:       "退出登录",
-	OpClearAllLogs: "清空操作日志",
+	OpLoginSuccess:   "登录成功",
+	OpLoginFailed:    "登录失败",
+	OpLogout:         "退出登录",
+	OpTokenRefresh:   "刷新令牌",
+	OpSessionRevoked: "吊销登录会话",
+	OpClearAllLogs:   "清空操作日志",
+
+	// 用户管理相关
+	OpUserCreated:          "创建用户",
+	OpUserDisabled:         "禁用用户",
+	OpUserEnabled:          "启用用户",
+	OpPasswordReset:        "重置密码",
+	OpPasswordChanged:      "修改密码",
+	OpPasswordChangeFailed: "修改密码失败",
+	OpUserRoleChanged:      "修改用户角色",
+	OpPermissionDenied:     "权限不足",
+	OpAPIKeyCreated:        "创建API密钥",
+	OpAPIKeyRevoked:        "吊销API密钥",
+	OpTwoFactorEnabled:     "启用两步验证",
+	OpTwoFactorDisabled:    "停用两步验证",
+	OpRecoveryCodeUsed:     "使用恢复码",
+	OpRecoveryCodesRenewed: "重新生成恢复码",
 
 	// 邮箱相关
 	OpEmailAdded:            "添加邮箱",
 	OpEmailDeleted:          "删除邮箱",
 	OpEmailUpdated:          "更新邮箱",
+	OpEmailShared:           "设置邮箱共享",
 	OpEmailValidationFailed: "邮箱验证失败",
 	OpBatchAddEmails:        "批量添加邮箱",
 	OpBatchDeleteEmails:     "批量删除邮箱",
+	OpHealthCheck:           "账户健康检查",
+	OpRefreshTokenRotated:   "刷新令牌轮换",
+	OpJobCancelled:          "取消后台任务",
 
 	// 邮件操作相关
 	OpGetLatestMail:       "获取最新邮件",
@@ -61,6 +120,21 @@ var OperationTypeNames = map[string]string{
 	OpClearInboxFailed:    "清空收件箱失败",
 	OpClearJunk:           "清空垃圾箱",
 	OpClearJunkFailed:     "清空垃圾箱失败",
+	OpBatchClearInbox:     "批量清空收件箱",
+	OpBatchClearJunk:      "批量清空垃圾箱",
+	OpWaitVerifyCode:      "等待验证码",
+	OpWaitVerifyCodeFail:  "等待验证码失败",
+
+	// 邮箱监控相关
+	OpMonitorEnabled:   "开启邮箱监控",
+	OpMonitorDisabled:  "关闭邮箱监控",
+	OpNewMailDetected:  "检测到新邮件",
+	OpMonitorCheckFail: "邮箱监控检查失败",
+
+	// 验证码规则相关
+	OpCodeRuleCreated: "创建验证码规则",
+	OpCodeRuleUpdated: "更新验证码规则",
+	OpCodeRuleDeleted: "删除验证码规则",
 
 	// 标签相关
 	OpTagCreated:       "创建标签",
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// APIKeyRepository API密钥数据库操作
+type APIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewAPIKeyRepository 创建API密钥仓库
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// CreateAPIKey 保存API密钥
+func (r *APIKeyRepository) CreateAPIKey(key *models.APIKey) (*models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+
+	result, err := r.db.Exec(query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		strings.Join(key.Scopes, ","),
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getAPIKey(`id = ?`, id)
+}
+
+// GetAPIKeyByHash 根据密钥哈希获取API密钥（包括已吊销的密钥）
+func (r *APIKeyRepository) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	return r.getAPIKey(`key_hash = ?`, keyHash)
+}
+
+// GetAPIKeysByUserID 获取用户的API密钥列表
+func (r *APIKeyRepository) GetAPIKeysByUserID(userID int) ([]models.APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, last_used_at, expires_at, revoked_at, created_at
+		FROM api_keys
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey 吊销用户的API密钥，密钥不存在或已吊销时返回 sql.ErrNoRows
+func (r *APIKeyRepository) RevokeAPIKey(userID, id int) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// TouchAPIKey 更新最后使用时间
+func (r *APIKeyRepository) TouchAPIKey(id int, usedAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, usedAt, id)
+	return err
+}
+
+// getAPIKey 按条件查询单个API密钥
+func (r *APIKeyRepository) getAPIKey(condition string, args ...interface{}) (*models.APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, last_used_at, expires_at, revoked_at, created_at
+		FROM api_keys WHERE ` + condition
+
+	return scanAPIKey(r.db.QueryRow(query, args...))
+}
+
+// scanAPIKey 扫描API密钥行
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return key, nil
+}
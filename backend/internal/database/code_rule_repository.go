@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// CodeRuleRepository 验证码提取规则数据库操作
+type CodeRuleRepository struct {
+	db *sql.DB
+}
+
+// NewCodeRuleRepository 创建验证码提取规则仓库
+func NewCodeRuleRepository(db *sql.DB) *CodeRuleRepository {
+	return &CodeRuleRepository{db: db}
+}
+
+// CreateRule 创建规则
+func (r *CodeRuleRepository) CreateRule(rule *models.CodeRule) (*models.CodeRule, error) {
+	query := `
+		INSERT INTO code_rules (user_id, sender_domain, rule_type, pattern, priority, enabled, description, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+
+	result, err := r.db.Exec(query,
+		rule.UserID,
+		rule.SenderDomain,
+		rule.RuleType,
+		rule.Pattern,
+		rule.Priority,
+		rule.Enabled,
+		rule.Description,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetRuleByID(int(id))
+}
+
+// GetRuleByID 根据ID获取规则
+func (r *CodeRuleRepository) GetRuleByID(id int) (*models.CodeRule, error) {
+	query := `
+		SELECT id, user_id, sender_domain, rule_type, pattern, priority, enabled, description, created_at, updated_at
+		FROM code_rules WHERE id = ?
+	`
+
+	rule := &models.CodeRule{}
+	err := r.db.QueryRow(query, id).Scan(
+		&rule.ID,
+		&rule.UserID,
+		&rule.SenderDomain,
+		&rule.RuleType,
+		&rule.Pattern,
+		&rule.Priority,
+		&rule.Enabled,
+		&rule.Description,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// GetRulesByUserID 获取用户的规则列表（按优先级排序）
+func (r *CodeRuleRepository) GetRulesByUserID(userID int) ([]models.CodeRule, error) {
+	query := `
+		SELECT id, user_id, sender_domain, rule_type, pattern, priority, enabled, description, created_at, updated_at
+		FROM code_rules
+		WHERE user_id = ?
+		ORDER BY priority ASC, id ASC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.CodeRule
+	for rows.Next() {
+		var rule models.CodeRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.UserID,
+			&rule.SenderDomain,
+			&rule.RuleType,
+			&rule.Pattern,
+			&rule.Priority,
+			&rule.Enabled,
+			&rule.Description,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// UpdateRule 更新规则
+func (r *CodeRuleRepository) UpdateRule(rule *models.CodeRule) error {
+	query := `
+		UPDATE code_rules
+		SET sender_domain = ?, rule_type = ?, pattern = ?, priority = ?, enabled = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query,
+		rule.SenderDomain,
+		rule.RuleType,
+		rule.Pattern,
+		rule.Priority,
+		rule.Enabled,
+		rule.Description,
+		rule.ID,
+	)
+
+	return err
+}
+
+// DeleteRule 删除规则
+func (r *CodeRuleRepository) DeleteRule(id int) error {
+	query := `DELETE FROM code_rules WHERE id = ?`
+	_, err := r.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/secure"
+)
+
+// LoadKeyring 使用主密钥解密所有数据密钥，首次启用加密时生成数据密钥。
+// 未配置主密钥时返回nil（不加密），但数据库中已有加密凭据时返回错误
+func LoadKeyring(db *sql.DB, masterKey []byte) (*secure.Keyring, error) {
+	if masterKey == nil {
+		encrypted, err := hasEncryptedCredentials(db)
+		if err != nil {
+			return nil, err
+		}
+		if encrypted {
+			return nil, secure.ErrKeyringMissing
+		}
+		return nil, nil
+	}
+
+	keyring, err := loadDataKeys(db, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		return keyring, nil
+	}
+
+	// 首次启用加密，生成数据密钥
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keyring, err = createDataKey(tx, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// EncryptCredentials 加密尚未加密的邮箱密码和刷新令牌（一次性迁移旧数据），返回处理的邮箱数量
+func EncryptCredentials(db *sql.DB, keyring *secure.Keyring) (int, error) {
+	if keyring == nil {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := reencryptCredentials(tx, nil, keyring, true)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// RotateCredentialKey 生成新的数据密钥并重新加密所有凭据，旧数据密钥随后删除。
+// newMasterKey 不为空时新数据密钥使用新主密钥加密，完成后需将配置更新为新主密钥
+func RotateCredentialKey(db *sql.DB, masterKey, newMasterKey []byte) (int, error) {
+	oldKeyring, err := loadDataKeys(db, masterKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if newMasterKey == nil {
+		newMasterKey = masterKey
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE data_keys SET active = 0`); err != nil {
+		return 0, err
+	}
+	newKeyring, err := createDataKey(tx, newMasterKey)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := reencryptCredentials(tx, oldKeyring, newKeyring, false)
+	if err != nil {
+		return 0, err
+	}
+
+	// 未完成的后台任务参数和输入中可能包含待导入的凭据
+	if err := reencryptJobPayloads(tx, oldKeyring, newKeyring); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM data_keys WHERE id <> ?`, newKeyring.ActiveID()); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// loadDataKeys 加载并解密所有数据密钥，没有数据密钥时返回nil
+func loadDataKeys(db *sql.DB, masterKey []byte) (*secure.Keyring, error) {
+	rows, err := db.Query(`SELECT id, wrapped_key, active FROM data_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keyring *secure.Keyring
+	for rows.Next() {
+		var id int
+		var wrapped string
+		var active bool
+		if err := rows.Scan(&id, &wrapped, &active); err != nil {
+			return nil, err
+		}
+
+		key, err := secure.UnwrapKey(masterKey, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("数据密钥 %d: %w", id, err)
+		}
+		if keyring == nil {
+			keyring = secure.NewKeyring()
+		}
+		keyring.Add(id, key, active)
+	}
+
+	return keyring, rows.Err()
+}
+
+// createDataKey 生成并保存新的活动数据密钥
+func createDataKey(tx *sql.Tx, masterKey []byte) (*secure.Keyring, error) {
+	key, err := secure.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := secure.WrapKey(masterKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO data_keys (wrapped_key, active, created_at) VALUES (?, 1, CURRENT_TIMESTAMP)`, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := secure.NewKeyring()
+	keyring.Add(int(id), key, true)
+	return keyring, nil
+}
+
+// reencryptCredentials 使用 from 解密、to 加密邮箱凭据。onlyPlaintext 为true时跳过已加密的邮箱
+func reencryptCredentials(tx *sql.Tx, from, to *secure.Keyring, onlyPlaintext bool) (int, error) {
+	rows, err := tx.Query(`SELECT id, password, refresh_token FROM emails`)
+	if err != nil {
+		return 0, err
+	}
+
+	var emails []models.Email
+	for rows.Next() {
+		var email models.Email
+		if err := rows.Scan(&email.ID, &email.Password, &email.RefreshToken); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if onlyPlaintext && secure.IsEncrypted(email.Password) && secure.IsEncrypted(email.RefreshToken) {
+			continue
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`UPDATE emails SET password = ?, refresh_token = ? WHERE id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, email := range emails {
+		if err := decryptEmailCredentials(from, &email); err != nil {
+			return 0, fmt.Errorf("邮箱 %d: %w", email.ID, err)
+		}
+		password, refreshToken, err := encryptEmailCredentials(to, &email)
+		if err != nil {
+			return 0, fmt.Errorf("邮箱 %d: %w", email.ID, err)
+		}
+		if _, err := stmt.Exec(password, refreshToken, email.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(emails), nil
+}
+
+// hasEncryptedCredentials 数据库中是否存在已加密的凭据
+func hasEncryptedCredentials(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM emails WHERE password LIKE 'enc:%' OR refresh_token LIKE 'enc:%'`).Scan(&count)
+	return count > 0, err
+}
+
+// encryptEmailCredentials 加密邮箱的密码和刷新令牌，keyring为nil时返回明文
+func encryptEmailCredentials(keyring *secure.Keyring, email *models.Email) (string, string, error) {
+	password, err := keyring.Encrypt(email.Password)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := keyring.Encrypt(email.RefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	return password, refreshToken, nil
+}
+
+// decryptEmailCredentials 解密邮箱的密码和刷新令牌，未加密的旧数据保持不变
+func decryptEmailCredentials(keyring *secure.Keyring, email *models.Email) error {
+	password, err := keyring.Decrypt(email.Password)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := keyring.Decrypt(email.RefreshToken)
+	if err != nil {
+		return err
+	}
+	email.Password = password
+	email.RefreshToken = refreshToken
+	return nil
+}
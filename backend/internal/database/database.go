@@ -1,10 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"log"
 	"os"
 	"path/filepath"
 
+	"outlook-helper/backend/internal/secure"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -13,20 +17,34 @@ type DB struct {
 	conn *sql.DB
 
 	// Repository instances
-	User  *UserRepository
-	Email *EmailRepository
-	Tag   *TagRepository
-	Log   *LogRepository
+	User     *UserRepository
+	Email    *EmailRepository
+	Tag      *TagRepository
+	Log      *LogRepository
+	Monitor  *MonitorRepository
+	CodeRule *CodeRuleRepository
+	Message  *MessageRepository
+	APIKey   *APIKeyRepository
+	Session  *SessionRepository
+	TOTP     *TOTPRepository
+	Job      *JobRepository
 }
 
-// NewDB 创建数据库管理器
-func NewDB(conn *sql.DB) *DB {
+// NewDB 创建数据库管理器，keyring为nil时邮箱凭据不加密
+func NewDB(conn *sql.DB, keyring *secure.Keyring) *DB {
 	return &DB{
-		conn:  conn,
-		User:  NewUserRepository(conn),
-		Email: NewEmailRepository(conn),
-		Tag:   NewTagRepository(conn),
-		Log:   NewLogRepository(conn),
+		conn:     conn,
+		User:     NewUserRepository(conn),
+		Email:    NewEmailRepository(conn, keyring),
+		Tag:      NewTagRepository(conn, keyring),
+		Log:      NewLogRepository(conn),
+		Monitor:  NewMonitorRepository(conn),
+		CodeRule: NewCodeRuleRepository(conn),
+		Message:  NewMessageRepository(conn),
+		APIKey:   NewAPIKeyRepository(conn),
+		Session:  NewSessionRepository(conn),
+		TOTP:     NewTOTPRepository(conn),
+		Job:      NewJobRepository(conn, keyring),
 	}
 }
 
@@ -74,16 +92,54 @@ func Migrate(db *sql.DB) error {
 		return err
 	}
 
+	// 用户管理字段（is_admin 仅用于迁移到角色字段）
+	if err := addColumnIfNotExists(db, "users", "is_admin", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(db, "users", "disabled", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// 用户角色，旧数据中的管理员迁移为所有者，其他用户迁移为操作员
+	if err := migrateUserRoles(db); err != nil {
+		return err
+	}
+
 	// 创建邮箱表
 	if err := createEmailsTable(db); err != nil {
 		return err
 	}
 
+	// 邮箱账户状态字段
+	if err := migrateEmailStatusColumns(db); err != nil {
+		return err
+	}
+
+	// 邮箱使用的邮件后端，为空时使用部署默认值
+	if err := addColumnIfNotExists(db, "emails", "provider", "VARCHAR(20) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 刷新令牌最后一次轮换的时间
+	if err := addColumnIfNotExists(db, "emails", "token_refreshed_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// 邮箱是否共享给其他用户查看，默认只有所有者可见
+	if err := addColumnIfNotExists(db, "emails", "shared", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
 	// 创建标记表
 	if err := createTagsTable(db); err != nil {
 		return err
 	}
 
+	// 旧版本的标记是全局的，迁移为初始管理员所有的共享标记
+	if err := migrateTagOwnership(db); err != nil {
+		return err
+	}
+
 	// 创建邮箱标记关联表
 	if err := createEmailTagsTable(db); err != nil {
 		return err
@@ -94,6 +150,59 @@ func Migrate(db *sql.DB) error {
 		return err
 	}
 
+	// 使用API密钥的操作记录密钥ID和前缀
+	if err := addColumnIfNotExists(db, "operation_logs", "api_key_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(db, "operation_logs", "api_key_prefix", "VARCHAR(20) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// 创建邮箱监控表
+	if err := createMailMonitorsTable(db); err != nil {
+		return err
+	}
+
+	// 创建验证码提取规则表
+	if err := createCodeRulesTable(db); err != nil {
+		return err
+	}
+
+	// 创建本地邮件存储表
+	if err := createMessagesTable(db); err != nil {
+		return err
+	}
+
+	// 创建凭据加密数据密钥表
+	if err := createDataKeysTable(db); err != nil {
+		return err
+	}
+
+	// 创建API密钥表
+	if err := createAPIKeysTable(db); err != nil {
+		return err
+	}
+
+	// 创建登录会话表
+	if err := createSessionsTable(db); err != nil {
+		return err
+	}
+
+	// 创建两步验证密钥表和恢复码表
+	if err := createTOTPTables(db); err != nil {
+		return err
+	}
+
+	// 创建后台任务表、任务明细表和任务输入表
+	if err := createJobsTables(db); err != nil {
+		return err
+	}
+
+	// 创建邮件全文索引，SQLite未启用FTS5时跳过
+	if err := createMessagesSearchIndex(db); err != nil {
+		log.Printf("Warning: full-text search disabled (build with -tags sqlite_fts5 to enable): %v", err)
+	}
+
 	return nil
 }
 
@@ -133,22 +242,153 @@ func createEmailsTable(db *sql.DB) error {
 	return err
 }
 
+// migrateEmailStatusColumns 为邮箱表添加账户状态字段（兼容旧数据库）
+func migrateEmailStatusColumns(db *sql.DB) error {
+	columns := []struct {
+		name       string
+		definition string
+	}{
+		{"status", "VARCHAR(20) NOT NULL DEFAULT 'unknown'"},
+		{"last_checked_at", "DATETIME"},
+		{"last_error", "TEXT NOT NULL DEFAULT ''"},
+		{"consecutive_failures", "INTEGER NOT NULL DEFAULT 0"},
+	}
+
+	for _, column := range columns {
+		if err := addColumnIfNotExists(db, "emails", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_user_status ON emails(user_id, status)`)
+	return err
+}
+
+// migrateUserRoles 为用户表添加角色字段，未设置角色的用户按 is_admin 迁移
+func migrateUserRoles(db *sql.DB) error {
+	if err := addColumnIfNotExists(db, "users", "role", "VARCHAR(20) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		UPDATE users
+		SET role = CASE WHEN is_admin THEN 'owner' ELSE 'operator' END
+		WHERE role = ''
+	`)
+	return err
+}
+
+// addColumnIfNotExists 字段不存在时添加字段
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) error {
+	exists, err := columnExists(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// columnExists 检查字段是否存在
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 // createTagsTable 创建标记表
 func createTagsTable(db *sql.DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS tags (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 0,
 		name VARCHAR(50) NOT NULL,
 		description TEXT,
 		color VARCHAR(7) DEFAULT '#007bff',
+		shared BOOLEAN NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(name)
+		UNIQUE(user_id, name)
 	)`
 	_, err := db.Exec(query)
 	return err
 }
 
+// migrateTagOwnership 为旧版本的标记表添加所有者和共享字段。
+// SQLite 无法删除 UNIQUE(name) 约束，需要重建表；重建期间关闭外键，避免删除旧表时级联删除邮箱标记关联
+func migrateTagOwnership(db *sql.DB) error {
+	migrated, err := columnExists(db, "tags", "user_id")
+	if err != nil || migrated {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE tags_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			name VARCHAR(50) NOT NULL,
+			description TEXT,
+			color VARCHAR(7) DEFAULT '#007bff',
+			shared BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, name)
+		)`,
+		`INSERT INTO tags_new (id, user_id, name, description, color, shared, created_at, updated_at)
+		SELECT id,
+		       COALESCE((SELECT id FROM users WHERE username = '` + BootstrapAdminUsername + `'), (SELECT MIN(id) FROM users), 0),
+		       name, description, color, 1, created_at, updated_at
+		FROM tags`,
+		`DROP TABLE tags`,
+		`ALTER TABLE tags_new RENAME TO tags`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // createEmailTagsTable 创建邮箱标记关联表
 func createEmailTagsTable(db *sql.DB) error {
 	query := `
@@ -182,3 +422,247 @@ func createOperationLogsTable(db *sql.DB) error {
 	_, err := db.Exec(query)
 	return err
 }
+
+// createMailMonitorsTable 创建邮箱监控表
+func createMailMonitorsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS mail_monitors (
+		email_id INTEGER PRIMARY KEY,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		last_mail_id TEXT NOT NULL DEFAULT '',
+		baseline_taken BOOLEAN NOT NULL DEFAULT 0,
+		last_checked_at DATETIME,
+		last_new_mail_at DATETIME,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createCodeRulesTable 创建验证码提取规则表
+func createCodeRulesTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS code_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		sender_domain VARCHAR(255) NOT NULL DEFAULT '',
+		rule_type VARCHAR(10) NOT NULL DEFAULT 'code',
+		pattern TEXT NOT NULL,
+		priority INTEGER NOT NULL DEFAULT 0,
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createMessagesTable 创建本地邮件存储表
+func createMessagesTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email_id INTEGER NOT NULL,
+		message_id VARCHAR(255) NOT NULL,
+		mailbox VARCHAR(20) NOT NULL DEFAULT 'INBOX',
+		subject TEXT NOT NULL DEFAULT '',
+		sender TEXT NOT NULL DEFAULT '',
+		recipient TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		is_read BOOLEAN NOT NULL DEFAULT 0,
+		verify_code VARCHAR(64) NOT NULL DEFAULT '',
+		verify_link TEXT NOT NULL DEFAULT '',
+		received_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE,
+		UNIQUE(email_id, message_id)
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_email_received ON messages(email_id, received_at DESC)`)
+	return err
+}
+
+// createDataKeysTable 创建数据密钥表，数据密钥使用主密钥加密后保存
+func createDataKeysTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS data_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		wrapped_key TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createAPIKeysTable 创建API密钥表
+func createAPIKeysTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(20) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		scopes TEXT NOT NULL DEFAULT '',
+		last_used_at DATETIME,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id)`)
+	return err
+}
+
+// createSessionsTable 创建登录会话表
+func createSessionsTable(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		token_id VARCHAR(64) NOT NULL UNIQUE,
+		ip_address VARCHAR(45),
+		user_agent TEXT,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, expires_at)`)
+	return err
+}
+
+// createTOTPTables 创建两步验证密钥表和恢复码表
+func createTOTPTables(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INTEGER PRIMARY KEY,
+		secret VARCHAR(64) NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT 0,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		enabled_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash VARCHAR(64) NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id, code_hash)`)
+	return err
+}
+
+// createJobsTables 创建后台任务表、任务明细表和任务输入表
+func createJobsTables(db *sql.DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id VARCHAR(32) PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		type VARCHAR(50) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		payload TEXT NOT NULL DEFAULT '',
+		total INTEGER NOT NULL DEFAULT 0,
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		ip_address VARCHAR(45),
+		user_agent TEXT,
+		api_key_id INTEGER,
+		api_key_prefix VARCHAR(20) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		started_at DATETIME,
+		finished_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, created_at)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_jobs_user ON jobs(user_id, type, status)`); err != nil {
+		return err
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS job_items (
+		job_id VARCHAR(32) NOT NULL,
+		item_index INTEGER NOT NULL,
+		email_id INTEGER NOT NULL DEFAULT 0,
+		email_address VARCHAR(255) NOT NULL DEFAULT '',
+		success BOOLEAN NOT NULL DEFAULT 0,
+		status VARCHAR(20) NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (job_id, item_index),
+		FOREIGN KEY (job_id) REFERENCES jobs(id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// 任务的逐项输入（如上传的导入文件），加密保存，任务结束时删除。
+	// 输入在任务创建前写入，因此不引用 jobs 表
+	query = `
+	CREATE TABLE IF NOT EXISTS job_inputs (
+		job_id VARCHAR(32) NOT NULL,
+		item_index INTEGER NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (job_id, item_index)
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// createMessagesSearchIndex 创建本地邮件全文索引（FTS5 trigram，支持中文子串匹配）
+func createMessagesSearchIndex(db *sql.DB) error {
+	query := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		subject,
+		sender,
+		body,
+		tokenize = 'trigram'
+	)`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+
+	// 删除邮件时同步删除索引
+	trigger := `
+	CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		DELETE FROM messages_fts WHERE rowid = old.id;
+	END`
+	_, err := db.Exec(trigger)
+	return err
+}
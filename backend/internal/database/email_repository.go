@@ -5,32 +5,44 @@ import (
 	"strings"
 
 	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/secure"
 )
 
-// EmailRepository 邮箱数据库操作
+// EmailRepository 邮箱数据库操作，密码和刷新令牌在读写时自动加解密
 type EmailRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	keyring *secure.Keyring
 }
 
-// NewEmailRepository 创建邮箱仓库
-func NewEmailRepository(db *sql.DB) *EmailRepository {
-	return &EmailRepository{db: db}
+// NewEmailRepository 创建邮箱仓库，keyring为nil时凭据以明文保存
+func NewEmailRepository(db *sql.DB, keyring *secure.Keyring) *EmailRepository {
+	return &EmailRepository{db: db, keyring: keyring}
 }
 
 // CreateEmail 创建邮箱
 func (r *EmailRepository) CreateEmail(email *models.Email) (*models.Email, error) {
 	query := `
-		INSERT INTO emails (user_id, email_address, password, client_id, refresh_token, remark, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO emails (user_id, email_address, password, client_id, refresh_token, remark, status, last_checked_at,
+		                    provider, token_refreshed_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
+	password, refreshToken, err := encryptEmailCredentials(r.keyring, email)
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := r.db.Exec(query,
 		email.UserID,
 		email.EmailAddress,
-		email.Password,
+		password,
 		email.ClientID,
-		email.RefreshToken,
+		refreshToken,
 		email.Remark,
+		emailStatusOrDefault(email.Status),
+		email.LastCheckedAt,
+		email.Provider,
+		email.TokenRefreshedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -48,7 +60,8 @@ func (r *EmailRepository) CreateEmail(email *models.Email) (*models.Email, error
 func (r *EmailRepository) GetEmailByID(id int) (*models.Email, error) {
 	query := `
 		SELECT id, user_id, email_address, password, client_id, refresh_token, remark, 
-		       last_operation_at, created_at, updated_at
+		       last_operation_at, status, last_checked_at, last_error, consecutive_failures, provider, token_refreshed_at, shared,
+		       created_at, updated_at
 		FROM emails WHERE id = ?
 	`
 
@@ -62,6 +75,13 @@ func (r *EmailRepository) GetEmailByID(id int) (*models.Email, error) {
 		&email.RefreshToken,
 		&email.Remark,
 		&email.LastOperationAt,
+		&email.Status,
+		&email.LastCheckedAt,
+		&email.LastError,
+		&email.ConsecutiveFailures,
+		&email.Provider,
+		&email.TokenRefreshedAt,
+		&email.Shared,
 		&email.CreatedAt,
 		&email.UpdatedAt,
 	)
@@ -69,6 +89,9 @@ func (r *EmailRepository) GetEmailByID(id int) (*models.Email, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := decryptEmailCredentials(r.keyring, email); err != nil {
+		return nil, err
+	}
 
 	// 加载标记
 	tags, err := r.GetEmailTags(email.ID)
@@ -79,13 +102,18 @@ func (r *EmailRepository) GetEmailByID(id int) (*models.Email, error) {
 	return email, nil
 }
 
-// GetEmailsByUserID 根据用户ID获取邮箱列表
-func (r *EmailRepository) GetEmailsByUserID(userID int, limit, offset int) ([]models.Email, error) {
+// readableEmailCondition 用户可查看的邮箱：自己的邮箱，以及所有者设置为共享的邮箱。
+// 共享只开放查看邮件，修改、删除、清空和导出凭据仍只能操作自己的邮箱
+const readableEmailCondition = `(user_id = ? OR shared = 1)`
+
+// GetReadableEmails 获取用户可查看的邮箱列表（自己的邮箱和团队共享的邮箱）
+func (r *EmailRepository) GetReadableEmails(userID int, limit, offset int) ([]models.Email, error) {
 	query := `
 		SELECT id, user_id, email_address, password, client_id, refresh_token, remark, 
-		       last_operation_at, created_at, updated_at
+		       last_operation_at, status, last_checked_at, last_error, consecutive_failures, provider, token_refreshed_at, shared,
+		       created_at, updated_at
 		FROM emails 
-		WHERE user_id = ? 
+		WHERE ` + readableEmailCondition + `
 		ORDER BY created_at DESC
 		LIMIT ? OFFSET ?
 	`
@@ -108,17 +136,27 @@ func (r *EmailRepository) GetEmailsByUserID(userID int, limit, offset int) ([]mo
 			&email.RefreshToken,
 			&email.Remark,
 			&email.LastOperationAt,
+			&email.Status,
+			&email.LastCheckedAt,
+			&email.LastError,
+			&email.ConsecutiveFailures,
+			&email.Provider,
+			&email.TokenRefreshedAt,
+			&email.Shared,
 			&email.CreatedAt,
 			&email.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
 
 		// 加载标记
 		tags, err := r.GetEmailTags(email.ID)
 		if err == nil {
-			email.Tags = tags
+			email.Tags = VisibleEmailTags(tags, &email, userID)
 		}
 
 		emails = append(emails, email)
@@ -127,19 +165,20 @@ func (r *EmailRepository) GetEmailsByUserID(userID int, limit, offset int) ([]mo
 	return emails, nil
 }
 
-// SearchEmails 搜索邮箱
-func (r *EmailRepository) SearchEmails(userID int, keyword string, limit, offset int) ([]models.Email, error) {
+// SearchEmails 在用户可查看的邮箱中搜索，status为空时不按账户状态过滤
+func (r *EmailRepository) SearchEmails(userID int, keyword, status string, limit, offset int) ([]models.Email, error) {
 	query := `
 		SELECT id, user_id, email_address, password, client_id, refresh_token, remark, 
-		       last_operation_at, created_at, updated_at
+		       last_operation_at, status, last_checked_at, last_error, consecutive_failures, provider, token_refreshed_at, shared,
+		       created_at, updated_at
 		FROM emails 
-		WHERE user_id = ? AND (email_address LIKE ? OR remark LIKE ?)
+		WHERE ` + readableEmailCondition + ` AND (email_address LIKE ? OR remark LIKE ?) AND (? = '' OR status = ?)
 		ORDER BY created_at DESC
 		LIMIT ? OFFSET ?
 	`
 
 	searchPattern := "%" + keyword + "%"
-	rows, err := r.db.Query(query, userID, searchPattern, searchPattern, limit, offset)
+	rows, err := r.db.Query(query, userID, searchPattern, searchPattern, status, status, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -157,17 +196,27 @@ func (r *EmailRepository) SearchEmails(userID int, keyword string, limit, offset
 			&email.RefreshToken,
 			&email.Remark,
 			&email.LastOperationAt,
+			&email.Status,
+			&email.LastCheckedAt,
+			&email.LastError,
+			&email.ConsecutiveFailures,
+			&email.Provider,
+			&email.TokenRefreshedAt,
+			&email.Shared,
 			&email.CreatedAt,
 			&email.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
 
 		// 加载标记
 		tags, err := r.GetEmailTags(email.ID)
 		if err == nil {
-			email.Tags = tags
+			email.Tags = VisibleEmailTags(tags, &email, userID)
 		}
 
 		emails = append(emails, email)
@@ -176,24 +225,100 @@ func (r *EmailRepository) SearchEmails(userID int, keyword string, limit, offset
 	return emails, nil
 }
 
-// UpdateEmail 更新邮箱
+// UpdateEmail 更新邮箱，刷新令牌变化时同时记录令牌轮换时间
 func (r *EmailRepository) UpdateEmail(email *models.Email) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 加密后的密文每次都不同，需解密后比较刷新令牌是否变化
+	var storedToken string
+	if err := tx.QueryRow(`SELECT refresh_token FROM emails WHERE id = ?`, email.ID).Scan(&storedToken); err != nil {
+		return err
+	}
+	currentToken, err := r.keyring.Decrypt(storedToken)
+	if err != nil {
+		return err
+	}
+
+	password, refreshToken, err := encryptEmailCredentials(r.keyring, email)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE emails 
-		SET email_address = ?, password = ?, client_id = ?, refresh_token = ?, remark = ?, updated_at = CURRENT_TIMESTAMP
+		SET email_address = ?, password = ?, client_id = ?, refresh_token = ?, remark = ?, provider = ?,
+		    token_refreshed_at = CASE WHEN ? THEN CURRENT_TIMESTAMP ELSE token_refreshed_at END,
+		    updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
 
-	_, err := r.db.Exec(query,
+	_, err = tx.Exec(query,
 		email.EmailAddress,
-		email.Password,
+		password,
 		email.ClientID,
-		email.RefreshToken,
+		refreshToken,
 		email.Remark,
+		email.Provider,
+		currentToken != email.RefreshToken,
 		email.ID,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
+}
+
+// RotateRefreshToken 保存轮换后的刷新令牌，只有数据库中的令牌仍为 oldRefreshToken 时才更新。
+// 读取、比较和更新在同一事务中完成，令牌已被修改时返回 false
+func (r *EmailRepository) RotateRefreshToken(emailID int, oldRefreshToken, newRefreshToken string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// 加密后的密文每次都不同，需解密后比较
+	var storedToken string
+	if err := tx.QueryRow(`SELECT refresh_token FROM emails WHERE id = ?`, emailID).Scan(&storedToken); err != nil {
+		return false, err
+	}
+	currentToken, err := r.keyring.Decrypt(storedToken)
+	if err != nil {
+		return false, err
+	}
+	if currentToken != oldRefreshToken {
+		return false, nil
+	}
+
+	refreshToken, err := r.keyring.Encrypt(newRefreshToken)
+	if err != nil {
+		return false, err
+	}
+
+	// 条件中带上读取到的密文，其他连接在读取后修改了令牌时不会覆盖
+	query := `
+		UPDATE emails
+		SET refresh_token = ?, token_refreshed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND refresh_token = ?
+	`
+	result, err := tx.Exec(query, refreshToken, emailID, storedToken)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	return true, tx.Commit()
 }
 
 // UpdateLastOperation 更新最后操作时间
@@ -208,8 +333,40 @@ func (r *EmailRepository) UpdateLastOperation(emailID int) error {
 	return err
 }
 
+// MarkEmailHealthy 记录上游调用成功，重置账户状态
+func (r *EmailRepository) MarkEmailHealthy(emailID int) error {
+	query := `
+		UPDATE emails
+		SET status = ?, last_checked_at = CURRENT_TIMESTAMP, last_error = '', consecutive_failures = 0
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, models.EmailStatusActive, emailID)
+	return err
+}
+
+// MarkEmailFailed 记录上游调用失败，status为空时保持原状态（如网络等临时错误）
+func (r *EmailRepository) MarkEmailFailed(emailID int, status, lastError string) error {
+	query := `
+		UPDATE emails
+		SET status = CASE WHEN ? = '' THEN status ELSE ? END,
+		    last_checked_at = CURRENT_TIMESTAMP,
+		    last_error = ?,
+		    consecutive_failures = consecutive_failures + 1
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, status, status, lastError, emailID)
+	return err
+}
+
 // DeleteEmail 删除邮箱
 func (r *EmailRepository) DeleteEmail(id int) error {
+	// 先删除本地存储的邮件
+	if _, err := r.db.Exec(`DELETE FROM messages WHERE email_id = ?`, id); err != nil {
+		return err
+	}
+
 	query := `DELETE FROM emails WHERE id = ?`
 	_, err := r.db.Exec(query, id)
 	return err
@@ -242,6 +399,20 @@ func (r *EmailRepository) BatchDeleteEmails(ids []int) error {
 		}
 	}
 
+	// 删除本地存储的邮件
+	messageStmt, err := tx.Prepare(`DELETE FROM messages WHERE email_id = ?`)
+	if err != nil {
+		return err
+	}
+	defer messageStmt.Close()
+
+	for _, id := range ids {
+		_, err := messageStmt.Exec(id)
+		if err != nil {
+			return err
+		}
+	}
+
 	// 再删除邮箱
 	emailQuery := `DELETE FROM emails WHERE id = ?`
 	emailStmt, err := tx.Prepare(emailQuery)
@@ -273,8 +444,9 @@ func (r *EmailRepository) BatchCreateEmails(emails []*models.Email) ([]models.Em
 	defer tx.Rollback()
 
 	query := `
-		INSERT INTO emails (user_id, email_address, password, client_id, refresh_token, remark, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO emails (user_id, email_address, password, client_id, refresh_token, remark, status, last_checked_at,
+		                    provider, token_refreshed_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
 	stmt, err := tx.Prepare(query)
@@ -285,13 +457,22 @@ func (r *EmailRepository) BatchCreateEmails(emails []*models.Email) ([]models.Em
 
 	var createdEmails []models.Email
 	for _, email := range emails {
+		password, refreshToken, err := encryptEmailCredentials(r.keyring, email)
+		if err != nil {
+			return nil, err
+		}
+
 		result, err := stmt.Exec(
 			email.UserID,
 			email.EmailAddress,
-			email.Password,
+			password,
 			email.ClientID,
-			email.RefreshToken,
+			refreshToken,
 			email.Remark,
+			emailStatusOrDefault(email.Status),
+			email.LastCheckedAt,
+			email.Provider,
+			email.TokenRefreshedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -304,12 +485,15 @@ func (r *EmailRepository) BatchCreateEmails(emails []*models.Email) ([]models.Em
 
 		// 创建返回的邮箱对象
 		createdEmail := models.Email{
-			ID:           int(id),
-			UserID:       email.UserID,
-			EmailAddress: email.EmailAddress,
-			Remark:       email.Remark,
-			CreatedAt:    email.CreatedAt,
-			UpdatedAt:    email.UpdatedAt,
+			ID:               int(id),
+			UserID:           email.UserID,
+			EmailAddress:     email.EmailAddress,
+			Remark:           email.Remark,
+			Status:           emailStatusOrDefault(email.Status),
+			Provider:         email.Provider,
+			TokenRefreshedAt: email.TokenRefreshedAt,
+			CreatedAt:        email.CreatedAt,
+			UpdatedAt:        email.UpdatedAt,
 		}
 		createdEmails = append(createdEmails, createdEmail)
 	}
@@ -321,10 +505,32 @@ func (r *EmailRepository) BatchCreateEmails(emails []*models.Email) ([]models.Em
 	return createdEmails, nil
 }
 
+// SetEmailShared 设置邮箱是否共享给其他用户查看
+func (r *EmailRepository) SetEmailShared(emailID int, shared bool) error {
+	query := `UPDATE emails SET shared = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := r.db.Exec(query, shared, emailID)
+	return err
+}
+
+// VisibleEmailTags 查看其他用户共享的邮箱时只返回共享标记，不暴露所有者的私有标记
+func VisibleEmailTags(tags []models.Tag, email *models.Email, userID int) []models.Tag {
+	if email.UserID == userID {
+		return tags
+	}
+
+	visible := make([]models.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Shared {
+			visible = append(visible, tag)
+		}
+	}
+	return visible
+}
+
 // GetEmailTags 获取邮箱的标记
 func (r *EmailRepository) GetEmailTags(emailID int) ([]models.Tag, error) {
 	query := `
-		SELECT t.id, t.name, t.description, t.color, t.created_at, t.updated_at
+		SELECT t.id, t.user_id, t.name, t.description, t.color, t.shared, t.created_at, t.updated_at
 		FROM tags t
 		INNER JOIN email_tags et ON t.id = et.tag_id
 		WHERE et.email_id = ?
@@ -342,9 +548,11 @@ func (r *EmailRepository) GetEmailTags(emailID int) ([]models.Tag, error) {
 		var tag models.Tag
 		err := rows.Scan(
 			&tag.ID,
+			&tag.UserID,
 			&tag.Name,
 			&tag.Description,
 			&tag.Color,
+			&tag.Shared,
 			&tag.CreatedAt,
 			&tag.UpdatedAt,
 		)
@@ -357,9 +565,9 @@ func (r *EmailRepository) GetEmailTags(emailID int) ([]models.Tag, error) {
 	return tags, nil
 }
 
-// CountEmailsByUserID 统计用户邮箱数量
-func (r *EmailRepository) CountEmailsByUserID(userID int) (int, error) {
-	query := `SELECT COUNT(*) FROM emails WHERE user_id = ?`
+// CountReadableEmails 统计用户可查看的邮箱数量
+func (r *EmailRepository) CountReadableEmails(userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM emails WHERE ` + readableEmailCondition
 
 	var count int
 	err := r.db.QueryRow(query, userID).Scan(&count)
@@ -367,16 +575,16 @@ func (r *EmailRepository) CountEmailsByUserID(userID int) (int, error) {
 }
 
 // CountSearchEmails 统计搜索结果数量
-func (r *EmailRepository) CountSearchEmails(userID int, keyword string) (int, error) {
+func (r *EmailRepository) CountSearchEmails(userID int, keyword, status string) (int, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM emails
-		WHERE user_id = ? AND (email_address LIKE ? OR remark LIKE ?)
+		WHERE ` + readableEmailCondition + ` AND (email_address LIKE ? OR remark LIKE ?) AND (? = '' OR status = ?)
 	`
 
 	searchPattern := "%" + keyword + "%"
 	var count int
-	err := r.db.QueryRow(query, userID, searchPattern, searchPattern).Scan(&count)
+	err := r.db.QueryRow(query, userID, searchPattern, searchPattern, status, status).Scan(&count)
 	return count, err
 }
 
@@ -411,7 +619,8 @@ func (r *EmailRepository) GetEmailsByIDs(userID int, emailIDs []int) ([]models.E
 
 	query := `
 		SELECT id, user_id, email_address, password, client_id, refresh_token, remark,
-		       last_operation_at, created_at, updated_at
+		       last_operation_at, status, last_checked_at, last_error, consecutive_failures, provider, token_refreshed_at, shared,
+		       created_at, updated_at
 		FROM emails
 		WHERE user_id = ? AND id IN (` + strings.Join(placeholders, ",") + `)
 		ORDER BY id
@@ -435,12 +644,22 @@ func (r *EmailRepository) GetEmailsByIDs(userID int, emailIDs []int) ([]models.E
 			&email.RefreshToken,
 			&email.Remark,
 			&email.LastOperationAt,
+			&email.Status,
+			&email.LastCheckedAt,
+			&email.LastError,
+			&email.ConsecutiveFailures,
+			&email.Provider,
+			&email.TokenRefreshedAt,
+			&email.Shared,
 			&email.CreatedAt,
 			&email.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
 		emails = append(emails, email)
 	}
 
@@ -451,7 +670,8 @@ func (r *EmailRepository) GetEmailsByIDs(userID int, emailIDs []int) ([]models.E
 func (r *EmailRepository) GetAllEmailsByUserID(userID int) ([]models.Email, error) {
 	query := `
 		SELECT id, user_id, email_address, password, client_id, refresh_token, remark,
-		       last_operation_at, created_at, updated_at
+		       last_operation_at, status, last_checked_at, last_error, consecutive_failures, provider, token_refreshed_at, shared,
+		       created_at, updated_at
 		FROM emails
 		WHERE user_id = ?
 		ORDER BY created_at DESC
@@ -475,14 +695,84 @@ func (r *EmailRepository) GetAllEmailsByUserID(userID int) ([]models.Email, erro
 			&email.RefreshToken,
 			&email.Remark,
 			&email.LastOperationAt,
+			&email.Status,
+			&email.LastCheckedAt,
+			&email.LastError,
+			&email.ConsecutiveFailures,
+			&email.Provider,
+			&email.TokenRefreshedAt,
+			&email.Shared,
 			&email.CreatedAt,
 			&email.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
 		emails = append(emails, email)
 	}
 
 	return emails, rows.Err()
 }
+
+// GetAllEmailsByTag 获取用户带有指定标记的所有邮箱（不分页）
+func (r *EmailRepository) GetAllEmailsByTag(userID, tagID int) ([]models.Email, error) {
+	query := `
+		SELECT e.id, e.user_id, e.email_address, e.password, e.client_id, e.refresh_token, e.remark,
+		       e.last_operation_at, e.status, e.last_checked_at, e.last_error, e.consecutive_failures, e.provider, e.token_refreshed_at, e.shared,
+		       e.created_at, e.updated_at
+		FROM emails e
+		INNER JOIN email_tags et ON e.id = et.email_id
+		WHERE e.user_id = ? AND et.tag_id = ?
+		ORDER BY e.created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID, tagID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []models.Email
+	for rows.Next() {
+		var email models.Email
+		err := rows.Scan(
+			&email.ID,
+			&email.UserID,
+			&email.EmailAddress,
+			&email.Password,
+			&email.ClientID,
+			&email.RefreshToken,
+			&email.Remark,
+			&email.LastOperationAt,
+			&email.Status,
+			&email.LastCheckedAt,
+			&email.LastError,
+			&email.ConsecutiveFailures,
+			&email.Provider,
+			&email.TokenRefreshedAt,
+			&email.Shared,
+			&email.CreatedAt,
+			&email.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, rows.Err()
+}
+
+// emailStatusOrDefault 未设置账户状态时使用默认状态
+func emailStatusOrDefault(status string) string {
+	if status == "" {
+		return models.EmailStatusUnknown
+	}
+	return status
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/secure"
+)
+
+// newTestDB 创建临时数据库并运行迁移，邮箱凭据使用测试密钥加密
+func newTestDB(t *testing.T) *DB {
+	conn, err := Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Initialize error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := Migrate(conn); err != nil {
+		t.Fatalf("Migrate error = %v", err)
+	}
+
+	keyring := secure.NewKeyring()
+	keyring.Add(1, make([]byte, 32), true)
+	return NewDB(conn, keyring)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.conn.Exec(`INSERT INTO users (id, username, password_hash) VALUES (1, 'owner', 'x')`); err != nil {
+		t.Fatalf("insert user error = %v", err)
+	}
+	email, err := db.Email.CreateEmail(&models.Email{
+		UserID:       1,
+		EmailAddress: "user@example.com",
+		Password:     "password",
+		ClientID:     "client",
+		RefreshToken: "token-0",
+	})
+	if err != nil {
+		t.Fatalf("CreateEmail error = %v", err)
+	}
+
+	rotated, err := db.Email.RotateRefreshToken(email.ID, "token-0", "token-1")
+	if err != nil || !rotated {
+		t.Fatalf("RotateRefreshToken = (%v, %v), want (true, nil)", rotated, err)
+	}
+
+	// 另一个请求基于旧令牌轮换时不能覆盖已保存的新令牌
+	rotated, err = db.Email.RotateRefreshToken(email.ID, "token-0", "token-stale")
+	if err != nil || rotated {
+		t.Fatalf("stale RotateRefreshToken = (%v, %v), want (false, nil)", rotated, err)
+	}
+
+	stored, err := db.Email.GetEmailByID(email.ID)
+	if err != nil {
+		t.Fatalf("GetEmailByID error = %v", err)
+	}
+	if stored.RefreshToken != "token-1" {
+		t.Fatalf("refresh token = %q, want %q", stored.RefreshToken, "token-1")
+	}
+	if stored.TokenRefreshedAt == nil {
+		t.Fatal("token_refreshed_at not set")
+	}
+}
@@ -0,0 +1,409 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/secure"
+)
+
+// JobRepository 后台任务数据库操作
+type JobRepository struct {
+	db      *sql.DB
+	keyring *secure.Keyring
+}
+
+// NewJobRepository 创建后台任务仓库，任务参数可能包含邮箱凭据，keyring不为nil时加密保存
+func NewJobRepository(db *sql.DB, keyring *secure.Keyring) *JobRepository {
+	return &JobRepository{db: db, keyring: keyring}
+}
+
+// jobColumns 查询任务时选择的字段，完成数量从任务明细表统计
+const jobColumns = `
+	j.id, j.user_id, j.type, j.status, j.payload, j.total, j.result, j.error,
+	j.ip_address, j.user_agent, j.api_key_id, j.api_key_prefix, j.created_at, j.started_at, j.finished_at,
+	(SELECT COUNT(*) FROM job_items WHERE job_id = j.id),
+	(SELECT COUNT(*) FROM job_items WHERE job_id = j.id AND success = 1)
+`
+
+// CreateJob 创建等待执行的任务
+func (r *JobRepository) CreateJob(job *models.Job) error {
+	payload, err := r.keyring.Encrypt(job.Payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO jobs (id, user_id, type, status, payload, total, ip_address, user_agent, api_key_id, api_key_prefix, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	job.Status = models.JobStatusPending
+	job.CreatedAt = time.Now().UTC()
+	_, err = r.db.Exec(query,
+		job.ID,
+		job.UserID,
+		job.Type,
+		job.Status,
+		payload,
+		job.Total,
+		job.IPAddress,
+		job.UserAgent,
+		job.APIKeyID,
+		job.APIKeyPrefix,
+		job.CreatedAt,
+	)
+	return err
+}
+
+// GetJob 获取任务（不含明细），任务不存在时返回 sql.ErrNoRows
+func (r *JobRepository) GetJob(id string) (*models.Job, error) {
+	return r.scanJob(r.db.QueryRow(`SELECT `+jobColumns+` FROM jobs j WHERE j.id = ?`, id))
+}
+
+// ClaimNextJob 取出最早创建的等待中任务并标记为执行中，没有等待中的任务时返回 sql.ErrNoRows
+func (r *JobRepository) ClaimNextJob() (*models.Job, error) {
+	query := `
+		UPDATE jobs SET status = ?, started_at = COALESCE(started_at, ?)
+		WHERE id = (SELECT id FROM jobs WHERE status = ? ORDER BY created_at, rowid LIMIT 1)
+		RETURNING id
+	`
+
+	var id string
+	err := r.db.QueryRow(query, models.JobStatusRunning, time.Now().UTC(), models.JobStatusPending).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetJob(id)
+}
+
+// FinishJob 记录任务结束状态并清空不再需要的任务参数和输入，任务已结束时返回 sql.ErrNoRows
+func (r *JobRepository) FinishJob(id, status, errMsg, result string) error {
+	query := `
+		UPDATE jobs SET status = ?, error = ?, result = ?, payload = '', finished_at = ?
+		WHERE id = ? AND status IN (?, ?)
+	`
+
+	res, err := r.db.Exec(query, status, errMsg, result, time.Now().UTC(),
+		id, models.JobStatusPending, models.JobStatusRunning)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(res); err != nil {
+		return err
+	}
+	return r.DeleteJobInputs(id)
+}
+
+// CancelPendingJob 取消尚未开始执行的任务并清空任务参数和输入，任务不是等待状态时返回 sql.ErrNoRows
+func (r *JobRepository) CancelPendingJob(id string) error {
+	query := `UPDATE jobs SET status = ?, payload = '', finished_at = ? WHERE id = ? AND status = ?`
+
+	res, err := r.db.Exec(query, models.JobStatusCancelled, time.Now().UTC(), id, models.JobStatusPending)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(res); err != nil {
+		return err
+	}
+	return r.DeleteJobInputs(id)
+}
+
+// SaveJobInputs 在一个事务中加密保存一批任务输入
+func (r *JobRepository) SaveJobInputs(jobID string, inputs []models.JobInput) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO job_inputs (job_id, item_index, data) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, input := range inputs {
+		data, err := r.keyring.Encrypt(input.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(jobID, input.Index, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetJobInputs 按序号获取序号大于 after 的最多 limit 条任务输入并解密
+func (r *JobRepository) GetJobInputs(jobID string, after, limit int) ([]models.JobInput, error) {
+	query := `
+		SELECT item_index, data FROM job_inputs
+		WHERE job_id = ? AND item_index > ?
+		ORDER BY item_index
+		LIMIT ?
+	`
+
+	rows, err := r.db.Query(query, jobID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inputs []models.JobInput
+	for rows.Next() {
+		var input models.JobInput
+		if err := rows.Scan(&input.Index, &input.Data); err != nil {
+			return nil, err
+		}
+		if input.Data, err = r.keyring.Decrypt(input.Data); err != nil {
+			return nil, fmt.Errorf("任务 %s 输入解密失败: %w", jobID, err)
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, rows.Err()
+}
+
+// DeleteJobInputs 删除任务的全部输入
+func (r *JobRepository) DeleteJobInputs(jobID string) error {
+	_, err := r.db.Exec(`DELETE FROM job_inputs WHERE job_id = ?`, jobID)
+	return err
+}
+
+// DeleteOrphanJobInputs 删除不属于未结束任务的输入（创建任务前服务退出时遗留），返回删除的行数
+func (r *JobRepository) DeleteOrphanJobInputs() (int64, error) {
+	query := `
+		DELETE FROM job_inputs
+		WHERE job_id NOT IN (SELECT id FROM jobs WHERE status IN (?, ?))
+	`
+
+	res, err := r.db.Exec(query, models.JobStatusPending, models.JobStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RequeueJob 把执行中的任务放回等待队列，服务停止时调用，已完成的明细会保留
+func (r *JobRepository) RequeueJob(id string) error {
+	_, err := r.db.Exec(`UPDATE jobs SET status = ? WHERE id = ? AND status = ?`,
+		models.JobStatusPending, id, models.JobStatusRunning)
+	return err
+}
+
+// RequeueRunningJobs 把上次退出时仍在执行的任务放回等待队列，返回等待执行的任务总数
+func (r *JobRepository) RequeueRunningJobs() (int, error) {
+	if _, err := r.db.Exec(`UPDATE jobs SET status = ? WHERE status = ?`,
+		models.JobStatusPending, models.JobStatusRunning); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status = ?`, models.JobStatusPending).Scan(&count)
+	return count, err
+}
+
+// HasActiveJob 用户是否有指定类型的未结束任务
+func (r *JobRepository) HasActiveJob(userID int, jobType string) (bool, error) {
+	query := `SELECT COUNT(*) FROM jobs WHERE user_id = ? AND type = ? AND status IN (?, ?)`
+
+	var count int
+	err := r.db.QueryRow(query, userID, jobType, models.JobStatusPending, models.JobStatusRunning).Scan(&count)
+	return count > 0, err
+}
+
+// SetTotal 更新任务的对象总数
+func (r *JobRepository) SetTotal(id string, total int) error {
+	_, err := r.db.Exec(`UPDATE jobs SET total = ? WHERE id = ?`, total, id)
+	return err
+}
+
+// SaveJobItem 保存单个对象的处理结果，同一序号重复保存时覆盖
+func (r *JobRepository) SaveJobItem(jobID string, item *models.JobItem) error {
+	query := `
+		INSERT OR REPLACE INTO job_items (job_id, item_index, email_id, email_address, success, status, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := r.db.Exec(query,
+		jobID,
+		item.Index,
+		item.EmailID,
+		item.EmailAddress,
+		item.Success,
+		item.Status,
+		item.Error,
+		item.DurationMs,
+	)
+	return err
+}
+
+// GetJobItems 获取任务的全部明细，按序号排序
+func (r *JobRepository) GetJobItems(jobID string) ([]models.JobItem, error) {
+	query := `
+		SELECT item_index, email_id, email_address, success, status, error, duration_ms
+		FROM job_items WHERE job_id = ?
+		ORDER BY item_index
+	`
+
+	rows, err := r.db.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.JobItem{}
+	for rows.Next() {
+		var item models.JobItem
+		if err := rows.Scan(
+			&item.Index,
+			&item.EmailID,
+			&item.EmailAddress,
+			&item.Success,
+			&item.Status,
+			&item.Error,
+			&item.DurationMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// DeleteFinishedJobs 删除结束时间早于 before 的任务及其明细，返回删除的任务数
+func (r *JobRepository) DeleteFinishedJobs(before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status IN (?, ?, ?) AND finished_at < ?`
+
+	res, err := r.db.Exec(query, models.JobStatusCompleted, models.JobStatusFailed, models.JobStatusCancelled, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// scanJob 扫描一行任务数据并解密任务参数
+func (r *JobRepository) scanJob(row *sql.Row) (*models.Job, error) {
+	job := &models.Job{}
+	var result string
+	var ipAddress, userAgent sql.NullString
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Type,
+		&job.Status,
+		&job.Payload,
+		&job.Total,
+		&result,
+		&job.Error,
+		&ipAddress,
+		&userAgent,
+		&job.APIKeyID,
+		&job.APIKeyPrefix,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.Done,
+		&job.Success,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Failed = job.Done - job.Success
+	job.IPAddress = ipAddress.String
+	job.UserAgent = userAgent.String
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+
+	payload, err := r.keyring.Decrypt(job.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("任务 %s 参数解密失败: %w", job.ID, err)
+	}
+	job.Payload = payload
+	return job, nil
+}
+
+// reencryptJobPayloads 使用 from 解密、to 加密未结束任务的参数和任务输入（任务结束时已清空）
+func reencryptJobPayloads(tx *sql.Tx, from, to *secure.Keyring) error {
+	rows, err := tx.Query(`SELECT id, payload FROM jobs WHERE status IN (?, ?)`,
+		models.JobStatusPending, models.JobStatusRunning)
+	if err != nil {
+		return err
+	}
+
+	payloads := make(map[string]string)
+	for rows.Next() {
+		var id, payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		payloads[id] = payload
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, payload := range payloads {
+		plaintext, err := from.Decrypt(payload)
+		if err != nil {
+			return fmt.Errorf("任务 %s: %w", id, err)
+		}
+		encrypted, err := to.Encrypt(plaintext)
+		if err != nil {
+			return fmt.Errorf("任务 %s: %w", id, err)
+		}
+		if _, err := tx.Exec(`UPDATE jobs SET payload = ? WHERE id = ?`, encrypted, id); err != nil {
+			return err
+		}
+	}
+	return reencryptJobInputs(tx, from, to)
+}
+
+// reencryptJobInputs 使用 from 解密、to 加密全部任务输入
+func reencryptJobInputs(tx *sql.Tx, from, to *secure.Keyring) error {
+	rows, err := tx.Query(`SELECT rowid, job_id, data FROM job_inputs`)
+	if err != nil {
+		return err
+	}
+
+	type jobInput struct {
+		rowID int64
+		jobID string
+		data  string
+	}
+	var inputs []jobInput
+	for rows.Next() {
+		var input jobInput
+		if err := rows.Scan(&input.rowID, &input.jobID, &input.data); err != nil {
+			rows.Close()
+			return err
+		}
+		inputs = append(inputs, input)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, input := range inputs {
+		plaintext, err := from.Decrypt(input.data)
+		if err != nil {
+			return fmt.Errorf("任务 %s: %w", input.jobID, err)
+		}
+		encrypted, err := to.Encrypt(plaintext)
+		if err != nil {
+			return fmt.Errorf("任务 %s: %w", input.jobID, err)
+		}
+		if _, err := tx.Exec(`UPDATE job_inputs SET data = ? WHERE rowid = ?`, encrypted, input.rowID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -20,8 +20,9 @@ func NewLogRepository(db *sql.DB) *LogRepository {
 // CreateLog 创建操作日志
 func (r *LogRepository) CreateLog(log *models.OperationLog) error {
 	query := `
-		INSERT INTO operation_logs (user_id, operation_type, target_type, target_id, description, ip_address, user_agent, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO operation_logs (user_id, operation_type, target_type, target_id, description, ip_address, user_agent,
+		                            api_key_id, api_key_prefix, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
 
 	_, err := r.db.Exec(query,
@@ -32,6 +33,8 @@ func (r *LogRepository) CreateLog(log *models.OperationLog) error {
 		log.Description,
 		log.IPAddress,
 		log.UserAgent,
+		log.APIKeyID,
+		log.APIKeyPrefix,
 	)
 
 	return err
@@ -40,7 +43,8 @@ func (r *LogRepository) CreateLog(log *models.OperationLog) error {
 // GetLogsByUserID 根据用户ID获取操作日志
 func (r *LogRepository) GetLogsByUserID(userID int, limit, offset int) ([]models.OperationLog, error) {
 	query := `
-		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent, created_at
+		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent,
+		       api_key_id, api_key_prefix, created_at
 		FROM operation_logs 
 		WHERE user_id = ? 
 		ORDER BY created_at DESC
@@ -65,6 +69,8 @@ func (r *LogRepository) GetLogsByUserID(userID int, limit, offset int) ([]models
 			&log.Description,
 			&log.IPAddress,
 			&log.UserAgent,
+			&log.APIKeyID,
+			&log.APIKeyPrefix,
 			&log.CreatedAt,
 		)
 		if err != nil {
@@ -79,7 +85,8 @@ func (r *LogRepository) GetLogsByUserID(userID int, limit, offset int) ([]models
 // GetRecentLogs 获取最近的操作日志（分页）
 func (r *LogRepository) GetRecentLogs(userID int, limit, offset int) ([]models.OperationLog, error) {
 	query := `
-		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent, created_at
+		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent,
+		       api_key_id, api_key_prefix, created_at
 		FROM operation_logs
 		WHERE user_id = ?
 		ORDER BY created_at DESC
@@ -104,6 +111,8 @@ func (r *LogRepository) GetRecentLogs(userID int, limit, offset int) ([]models.O
 			&log.Description,
 			&log.IPAddress,
 			&log.UserAgent,
+			&log.APIKeyID,
+			&log.APIKeyPrefix,
 			&log.CreatedAt,
 		)
 		if err != nil {
@@ -135,7 +144,8 @@ func (r *LogRepository) ClearAllLogs(userID int) error {
 // GetLogsByType 根据操作类型获取日志
 func (r *LogRepository) GetLogsByType(userID int, operationType string, limit, offset int) ([]models.OperationLog, error) {
 	query := `
-		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent, created_at
+		SELECT id, user_id, operation_type, target_type, target_id, description, ip_address, user_agent,
+		       api_key_id, api_key_prefix, created_at
 		FROM operation_logs 
 		WHERE user_id = ? AND operation_type = ?
 		ORDER BY created_at DESC
@@ -160,6 +170,8 @@ func (r *LogRepository) GetLogsByType(userID int, operationType string, limit, o
 			&log.Description,
 			&log.IPAddress,
 			&log.UserAgent,
+			&log.APIKeyID,
+			&log.APIKeyPrefix,
 			&log.CreatedAt,
 		)
 		if err != nil {
@@ -224,43 +236,65 @@ func (r *LogRepository) CountLogsByUserID(userID int) (int, error) {
 }
 
 // LogEmail 记录邮箱相关操作
-func (r *LogRepository) LogEmail(userID int, operation string, emailID int, description, ipAddress, userAgent string) error {
+func (r *LogRepository) LogEmail(userID int, operation string, emailID int, description string, client models.ClientInfo) error {
 	log := &models.OperationLog{
 		UserID:        userID,
 		OperationType: operation,
 		TargetType:    "email",
 		TargetID:      &emailID,
 		Description:   description,
-		IPAddress:     ipAddress,
-		UserAgent:     userAgent,
+		IPAddress:     client.IPAddress,
+		UserAgent:     client.UserAgent,
+		APIKeyID:      client.APIKeyID,
+		APIKeyPrefix:  client.APIKeyPrefix,
 	}
 	return r.CreateLog(log)
 }
 
 // LogTag 记录标记相关操作
-func (r *LogRepository) LogTag(userID int, operation string, tagID int, description, ipAddress, userAgent string) error {
+func (r *LogRepository) LogTag(userID int, operation string, tagID int, description string, client models.ClientInfo) error {
 	log := &models.OperationLog{
 		UserID:        userID,
 		OperationType: operation,
 		TargetType:    "tag",
 		TargetID:      &tagID,
 		Description:   description,
-		IPAddress:     ipAddress,
-		UserAgent:     userAgent,
+		IPAddress:     client.IPAddress,
+		UserAgent:     client.UserAgent,
+		APIKeyID:      client.APIKeyID,
+		APIKeyPrefix:  client.APIKeyPrefix,
 	}
 	return r.CreateLog(log)
 }
 
 // LogAuth 记录认证相关操作
-func (r *LogRepository) LogAuth(userID int, operation, description, ipAddress, userAgent string) error {
+func (r *LogRepository) LogAuth(userID int, operation, description string, client models.ClientInfo) error {
 	log := &models.OperationLog{
 		UserID:        userID,
 		OperationType: operation,
 		TargetType:    "auth",
 		TargetID:      nil,
 		Description:   description,
-		IPAddress:     ipAddress,
-		UserAgent:     userAgent,
+		IPAddress:     client.IPAddress,
+		UserAgent:     client.UserAgent,
+		APIKeyID:      client.APIKeyID,
+		APIKeyPrefix:  client.APIKeyPrefix,
+	}
+	return r.CreateLog(log)
+}
+
+// LogCodeRule 记录验证码规则相关操作
+func (r *LogRepository) LogCodeRule(userID int, operation string, ruleID int, description string, client models.ClientInfo) error {
+	log := &models.OperationLog{
+		UserID:        userID,
+		OperationType: operation,
+		TargetType:    "code_rule",
+		TargetID:      &ruleID,
+		Description:   description,
+		IPAddress:     client.IPAddress,
+		UserAgent:     client.UserAgent,
+		APIKeyID:      client.APIKeyID,
+		APIKeyPrefix:  client.APIKeyPrefix,
 	}
 	return r.CreateLog(log)
 }
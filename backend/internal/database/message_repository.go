@@ -0,0 +1,295 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// ErrSearchUnavailable 当前SQLite未启用FTS5，无法使用全文搜索
+var ErrSearchUnavailable = errors.New("全文搜索不可用，请使用 -tags sqlite_fts5 重新编译")
+
+// trigram分词器可索引的最短关键字长度，更短的关键字退化为LIKE匹配
+const minIndexedTermLength = 3
+
+// MessageRepository 本地邮件存储数据库操作
+type MessageRepository struct {
+	db            *sql.DB
+	searchEnabled bool
+}
+
+// NewMessageRepository 创建本地邮件仓库
+func NewMessageRepository(db *sql.DB) *MessageRepository {
+	// 检查全文索引是否已创建
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'`).Scan(&count)
+
+	return &MessageRepository{
+		db:            db,
+		searchEnabled: err == nil && count > 0,
+	}
+}
+
+// SearchEnabled 是否支持全文搜索
+func (r *MessageRepository) SearchEnabled() bool {
+	return r.searchEnabled
+}
+
+// SaveMessages 批量保存邮件，按邮箱ID和上游邮件ID去重，返回新增数量
+func (r *MessageRepository) SaveMessages(messages []models.StoredMessage) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 已存在的邮件只更新已读状态，验证码和链接仅在原值为空时补充
+	query := `
+		INSERT INTO messages (email_id, message_id, mailbox, subject, sender, recipient, body, is_read,
+		                      verify_code, verify_link, received_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(email_id, message_id) DO UPDATE SET
+			is_read = excluded.is_read,
+			verify_code = CASE WHEN messages.verify_code = '' THEN excluded.verify_code ELSE messages.verify_code END,
+			verify_link = CASE WHEN messages.verify_link = '' THEN excluded.verify_link ELSE messages.verify_link END
+	`
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	existsStmt, err := tx.Prepare(`SELECT COUNT(*) FROM messages WHERE email_id = ? AND message_id = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer existsStmt.Close()
+
+	inserted := 0
+	for _, message := range messages {
+		var count int
+		if err := existsStmt.QueryRow(message.EmailID, message.MessageID).Scan(&count); err != nil {
+			return 0, err
+		}
+
+		result, err := stmt.Exec(
+			message.EmailID,
+			message.MessageID,
+			message.Mailbox,
+			message.Subject,
+			message.From,
+			message.To,
+			message.Body,
+			message.IsRead,
+			message.VerifyCode,
+			message.VerifyLink,
+			message.ReceivedAt.UTC(),
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		if count == 0 {
+			inserted++
+
+			// 新邮件写入全文索引
+			if r.searchEnabled {
+				id, err := result.LastInsertId()
+				if err != nil {
+					return 0, err
+				}
+				body := message.BodyText
+				if body == "" {
+					body = message.Body
+				}
+				if _, err := tx.Exec(`INSERT INTO messages_fts (rowid, subject, sender, body) VALUES (?, ?, ?, ?)`,
+					id, message.Subject, message.From, body); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return inserted, nil
+}
+
+// GetMessagesByEmailID 获取邮箱的本地邮件（分页），mailbox为空时返回全部文件夹
+func (r *MessageRepository) GetMessagesByEmailID(emailID int, mailbox string, limit, offset int) ([]models.StoredMessage, error) {
+	query := `
+		SELECT id, email_id, message_id, mailbox, subject, sender, recipient, body, is_read,
+		       verify_code, verify_link, received_at, created_at
+		FROM messages
+		WHERE email_id = ? AND (? = '' OR mailbox = ?)
+		ORDER BY received_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.Query(query, emailID, mailbox, mailbox, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.StoredMessage
+	for rows.Next() {
+		var message models.StoredMessage
+		err := rows.Scan(
+			&message.ID,
+			&message.EmailID,
+			&message.MessageID,
+			&message.Mailbox,
+			&message.Subject,
+			&message.From,
+			&message.To,
+			&message.Body,
+			&message.IsRead,
+			&message.VerifyCode,
+			&message.VerifyLink,
+			&message.ReceivedAt,
+			&message.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// CountMessagesByEmailID 统计邮箱的本地邮件数量
+func (r *MessageRepository) CountMessagesByEmailID(emailID int, mailbox string) (int, error) {
+	query := `SELECT COUNT(*) FROM messages WHERE email_id = ? AND (? = '' OR mailbox = ?)`
+
+	var count int
+	err := r.db.QueryRow(query, emailID, mailbox, mailbox).Scan(&count)
+	return count, err
+}
+
+// DeleteMessagesByEmailID 删除邮箱的所有本地邮件
+func (r *MessageRepository) DeleteMessagesByEmailID(emailID int) error {
+	query := `DELETE FROM messages WHERE email_id = ?`
+	_, err := r.db.Exec(query, emailID)
+	return err
+}
+
+// SearchMessages 全文搜索用户所有邮箱的本地邮件，tagID为0时不按标签过滤，since为零值时不限时间
+func (r *MessageRepository) SearchMessages(userID int, keyword string, tagID int, since time.Time, limit, offset int) ([]models.MessageSearchResult, int, error) {
+	if !r.searchEnabled {
+		return nil, 0, ErrSearchUnavailable
+	}
+
+	where, args := buildSearchConditions(userID, keyword, tagID, since)
+
+	countQuery := `
+		SELECT COUNT(*)
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN emails e ON e.id = m.email_id
+		WHERE ` + where
+
+	var total int
+	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT m.id, m.email_id, e.email_address, m.message_id, m.mailbox, m.subject, m.sender,
+		       m.verify_code, m.received_at,
+		       highlight(messages_fts, 0, '<mark>', '</mark>'),
+		       highlight(messages_fts, 1, '<mark>', '</mark>'),
+		       snippet(messages_fts, 2, '<mark>', '</mark>', '...', 32)
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN emails e ON e.id = m.email_id
+		WHERE ` + where + `
+		ORDER BY m.received_at DESC, m.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var results []models.MessageSearchResult
+	for rows.Next() {
+		var result models.MessageSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.EmailID,
+			&result.EmailAddress,
+			&result.MessageID,
+			&result.Mailbox,
+			&result.Subject,
+			&result.From,
+			&result.VerifyCode,
+			&result.ReceivedAt,
+			&result.SubjectHighlight,
+			&result.SenderHighlight,
+			&result.BodySnippet,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, result)
+	}
+
+	return results, total, rows.Err()
+}
+
+// buildSearchConditions 构建全文搜索条件，每个关键字之间为“且”关系
+func buildSearchConditions(userID int, keyword string, tagID int, since time.Time) (string, []interface{}) {
+	conditions := []string{"(e.user_id = ? OR e.shared = 1)"}
+	args := []interface{}{userID}
+
+	var phrases []string
+	for _, term := range strings.Fields(keyword) {
+		if utf8.RuneCountInString(term) >= minIndexedTermLength {
+			// 作为短语匹配，避免FTS5语法字符导致查询错误
+			phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			continue
+		}
+
+		pattern := "%" + escapeLike(term) + "%"
+		conditions = append(conditions,
+			`(messages_fts.subject LIKE ? ESCAPE '\' OR messages_fts.sender LIKE ? ESCAPE '\' OR messages_fts.body LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if len(phrases) > 0 {
+		conditions = append(conditions, "messages_fts MATCH ?")
+		args = append(args, strings.Join(phrases, " "))
+	}
+
+	if tagID > 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM email_tags et WHERE et.email_id = e.id AND et.tag_id = ?)")
+		args = append(args, tagID)
+	}
+	if !since.IsZero() {
+		conditions = append(conditions, "m.received_at >= ?")
+		args = append(args, since.UTC())
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// escapeLike 转义LIKE通配符
+func escapeLike(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "%", `\%`)
+	return strings.ReplaceAll(value, "_", `\_`)
+}
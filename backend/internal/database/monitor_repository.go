@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// MonitorRepository 邮箱监控数据库操作
+type MonitorRepository struct {
+	db *sql.DB
+}
+
+// NewMonitorRepository 创建邮箱监控仓库
+func NewMonitorRepository(db *sql.DB) *MonitorRepository {
+	return &MonitorRepository{db: db}
+}
+
+// SetMonitorEnabled 开启或关闭邮箱监控（不存在时自动创建）
+func (r *MonitorRepository) SetMonitorEnabled(emailID int, enabled bool) error {
+	query := `
+		INSERT INTO mail_monitors (email_id, enabled, created_at, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(email_id) DO UPDATE SET enabled = excluded.enabled, updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := r.db.Exec(query, emailID, enabled)
+	return err
+}
+
+// GetMonitorByEmailID 根据邮箱ID获取监控记录
+func (r *MonitorRepository) GetMonitorByEmailID(emailID int) (*models.MailMonitor, error) {
+	query := `
+		SELECT m.email_id, e.user_id, e.email_address, m.enabled, m.last_mail_id, m.baseline_taken,
+		       m.last_checked_at, m.last_new_mail_at, m.last_error, m.created_at, m.updated_at
+		FROM mail_monitors m
+		INNER JOIN emails e ON m.email_id = e.id
+		WHERE m.email_id = ?
+	`
+
+	monitor := &models.MailMonitor{}
+	err := r.db.QueryRow(query, emailID).Scan(
+		&monitor.EmailID,
+		&monitor.UserID,
+		&monitor.EmailAddress,
+		&monitor.Enabled,
+		&monitor.LastMailID,
+		&monitor.BaselineTaken,
+		&monitor.LastCheckedAt,
+		&monitor.LastNewMailAt,
+		&monitor.LastError,
+		&monitor.CreatedAt,
+		&monitor.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return monitor, nil
+}
+
+// GetMonitorsByUserID 获取用户的邮箱监控列表
+func (r *MonitorRepository) GetMonitorsByUserID(userID int) ([]models.MailMonitor, error) {
+	query := `
+		SELECT m.email_id, e.user_id, e.email_address, m.enabled, m.last_mail_id, m.baseline_taken,
+		       m.last_checked_at, m.last_new_mail_at, m.last_error, m.created_at, m.updated_at
+		FROM mail_monitors m
+		INNER JOIN emails e ON m.email_id = e.id
+		WHERE e.user_id = ?
+		ORDER BY m.created_at DESC
+	`
+
+	return r.queryMonitors(query, userID)
+}
+
+// GetEnabledMonitors 获取所有已开启的邮箱监控
+func (r *MonitorRepository) GetEnabledMonitors() ([]models.MailMonitor, error) {
+	query := `
+		SELECT m.email_id, e.user_id, e.email_address, m.enabled, m.last_mail_id, m.baseline_taken,
+		       m.last_checked_at, m.last_new_mail_at, m.last_error, m.created_at, m.updated_at
+		FROM mail_monitors m
+		INNER JOIN emails e ON m.email_id = e.id
+		WHERE m.enabled = 1
+		ORDER BY m.last_checked_at ASC
+	`
+
+	return r.queryMonitors(query)
+}
+
+// UpdateCheckResult 记录一次轮询检查结果，检查成功（lastError 为空）时标记已记录基线
+func (r *MonitorRepository) UpdateCheckResult(emailID int, lastMailID string, lastError string, newMail bool) error {
+	query := `
+		UPDATE mail_monitors
+		SET last_mail_id = ?, last_error = ?, last_checked_at = CURRENT_TIMESTAMP,
+		    baseline_taken = CASE WHEN ? = '' THEN 1 ELSE baseline_taken END,
+		    last_new_mail_at = CASE WHEN ? THEN CURRENT_TIMESTAMP ELSE last_new_mail_at END,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE email_id = ?
+	`
+
+	_, err := r.db.Exec(query, lastMailID, lastError, lastError, newMail, emailID)
+	return err
+}
+
+// SetLastMailID 更新监控记录的最新邮件ID，不影响检查时间和新邮件时间
+func (r *MonitorRepository) SetLastMailID(emailID int, lastMailID string) error {
+	query := `UPDATE mail_monitors SET last_mail_id = ?, updated_at = CURRENT_TIMESTAMP WHERE email_id = ?`
+	_, err := r.db.Exec(query, lastMailID, emailID)
+	return err
+}
+
+// DeleteMonitor 删除邮箱监控
+func (r *MonitorRepository) DeleteMonitor(emailID int) error {
+	query := `DELETE FROM mail_monitors WHERE email_id = ?`
+	_, err := r.db.Exec(query, emailID)
+	return err
+}
+
+// queryMonitors 执行查询并扫描监控记录
+func (r *MonitorRepository) queryMonitors(query string, args ...interface{}) ([]models.MailMonitor, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var monitors []models.MailMonitor
+	for rows.Next() {
+		var monitor models.MailMonitor
+		err := rows.Scan(
+			&monitor.EmailID,
+			&monitor.UserID,
+			&monitor.EmailAddress,
+			&monitor.Enabled,
+			&monitor.LastMailID,
+			&monitor.BaselineTaken,
+			&monitor.LastCheckedAt,
+			&monitor.LastNewMailAt,
+			&monitor.LastError,
+			&monitor.CreatedAt,
+			&monitor.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, monitor)
+	}
+
+	return monitors, rows.Err()
+}
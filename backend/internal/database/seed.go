@@ -1,15 +1,23 @@
 package database
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"log"
 
 	"outlook-helper/backend/internal/models"
 )
 
+// BootstrapAdminUsername 初始管理员用户名，使用 AUTH_TOKEN 登录时以该用户身份登录
+const BootstrapAdminUsername = "admin"
+
+// 旧版本创建初始管理员时使用的固定密码
+const legacyAdminPassword = "admin123"
+
 // SeedData 初始化种子数据
 func SeedData(db *sql.DB) error {
-	dbManager := NewDB(db)
+	dbManager := NewDB(db, nil)
 	
 	// 检查是否已有用户
 	users, err := dbManager.User.GetAllUsers()
@@ -21,8 +29,8 @@ func SeedData(db *sql.DB) error {
 	if len(users) == 0 {
 		log.Println("Creating default admin user...")
 		
-		// 创建默认管理员用户
-		adminUser, err := dbManager.User.CreateUser("admin", "admin123")
+		// 创建默认管理员用户，使用随机密码，需通过 AUTH_TOKEN 登录后重置
+		adminUser, err := dbManager.User.CreateUser(BootstrapAdminUsername, randomPassword(), models.UserRoleOwner)
 		if err != nil {
 			return err
 		}
@@ -30,18 +38,54 @@ func SeedData(db *sql.DB) error {
 		log.Printf("Default admin user created with ID: %d", adminUser.ID)
 		
 		// 创建一些默认标记
-		if err := createDefaultTags(dbManager); err != nil {
+		if err := createDefaultTags(dbManager, adminUser.ID); err != nil {
 			return err
 		}
 		
 		log.Println("Default tags created successfully")
 	}
 	
+	return secureBootstrapAdmin(dbManager)
+}
+
+// secureBootstrapAdmin 确保初始管理员为所有者，并替换旧版本创建的默认密码
+func secureBootstrapAdmin(db *DB) error {
+	admin, err := db.User.GetUserByUsername(BootstrapAdminUsername)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if admin.Role != models.UserRoleOwner {
+		if err := db.User.SetRole(admin.ID, models.UserRoleOwner); err != nil {
+			return err
+		}
+	}
+
+	// 旧版本使用固定密码 admin123 创建管理员，启用密码登录后必须替换
+	if db.User.ValidatePassword(admin, legacyAdminPassword) {
+		if err := db.User.UpdatePassword(admin.ID, randomPassword()); err != nil {
+			return err
+		}
+		log.Println("Default admin password replaced, log in with AUTH_TOKEN and reset it")
+	}
+
 	return nil
 }
 
-// createDefaultTags 创建默认标记
-func createDefaultTags(db *DB) error {
+// randomPassword 生成随机密码
+func randomPassword() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// createDefaultTags 创建默认标记，默认标记由初始管理员所有并共享给所有用户
+func createDefaultTags(db *DB, ownerID int) error {
 	defaultTags := []models.Tag{
 		{
 			Name:        "工作邮箱",
@@ -72,12 +116,14 @@ func createDefaultTags(db *DB) error {
 	
 	for _, tag := range defaultTags {
 		// 检查标记是否已存在
-		exists, err := db.Tag.TagExists(tag.Name)
+		exists, err := db.Tag.TagExists(ownerID, tag.Name)
 		if err != nil {
 			return err
 		}
 		
 		if !exists {
+			tag.UserID = ownerID
+			tag.Shared = true
 			_, err := db.Tag.CreateTag(&tag)
 			if err != nil {
 				return err
@@ -90,7 +136,7 @@ func createDefaultTags(db *DB) error {
 
 // CleanupOldData 清理旧数据
 func CleanupOldData(db *sql.DB) error {
-	dbManager := NewDB(db)
+	dbManager := NewDB(db, nil)
 	
 	// 清理30天前的操作日志
 	err := dbManager.Log.DeleteOldLogs(30)
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// SessionRepository 登录会话数据库操作
+type SessionRepository struct {
+	db *sql.DB
+}
+
+// NewSessionRepository 创建会话仓库
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// CreateSession 创建会话
+func (r *SessionRepository) CreateSession(session *models.Session) (*models.Session, error) {
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO sessions (user_id, token_id, ip_address, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.Exec(query,
+		session.UserID,
+		session.TokenID,
+		session.IPAddress,
+		session.UserAgent,
+		now,
+		now,
+		session.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getSession(`id = ?`, id)
+}
+
+// GetSessionByTokenID 根据令牌 jti 获取会话（包括已吊销和已过期的会话）
+func (r *SessionRepository) GetSessionByTokenID(tokenID string) (*models.Session, error) {
+	return r.getSession(`token_id = ?`, tokenID)
+}
+
+// GetActiveSessions 获取用户未吊销且未过期的会话，最近活跃的在前
+func (r *SessionRepository) GetActiveSessions(userID int) ([]models.Session, error) {
+	query := `
+		SELECT id, user_id, token_id, ip_address, user_agent, created_at, last_seen_at, expires_at, revoked_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RotateToken 刷新令牌后更新会话的 jti 和过期时间，旧令牌随即失效。
+// 会话已吊销或 jti 已被轮换时返回 sql.ErrNoRows
+func (r *SessionRepository) RotateToken(id int, oldTokenID, newTokenID string, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET token_id = ?, expires_at = ?, last_seen_at = ?
+		WHERE id = ? AND token_id = ? AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, newTokenID, expiresAt.UTC(), time.Now().UTC(), id, oldTokenID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// TouchSession 更新会话最后活跃时间
+func (r *SessionRepository) TouchSession(id int, seenAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, seenAt.UTC(), id)
+	return err
+}
+
+// RevokeSession 吊销用户的会话，会话不存在或已吊销时返回 sql.ErrNoRows
+func (r *SessionRepository) RevokeSession(userID, id int) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now().UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// RevokeUserSessions 吊销用户除 exceptID 以外的所有会话，返回吊销的数量
+func (r *SessionRepository) RevokeUserSessions(userID, exceptID int) (int, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE user_id = ? AND id <> ? AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now().UTC(), userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// DeleteExpiredSessions 删除过期超过保留时间的会话
+func (r *SessionRepository) DeleteExpiredSessions(retention time.Duration) error {
+	_, err := r.db.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().UTC().Add(-retention))
+	return err
+}
+
+// getSession 按条件查询单个会话
+func (r *SessionRepository) getSession(condition string, args ...interface{}) (*models.Session, error) {
+	query := `
+		SELECT id, user_id, token_id, ip_address, user_agent, created_at, last_seen_at, expires_at, revoked_at
+		FROM sessions WHERE ` + condition
+
+	return scanSession(r.db.QueryRow(query, args...))
+}
+
+// scanSession 扫描会话行
+func scanSession(row interface{ Scan(...interface{}) error }) (*models.Session, error) {
+	session := &models.Session{}
+	var ipAddress, userAgent sql.NullString
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.TokenID,
+		&ipAddress,
+		&userAgent,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.IPAddress = ipAddress.String
+	session.UserAgent = userAgent.String
+	return session, nil
+}
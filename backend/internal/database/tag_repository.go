@@ -4,26 +4,28 @@ import (
 	"database/sql"
 
 	"outlook-helper/backend/internal/models"
+	"outlook-helper/backend/internal/secure"
 )
 
 // TagRepository 标记数据库操作
 type TagRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	keyring *secure.Keyring // 解密按标记查询的邮箱凭据
 }
 
 // NewTagRepository 创建标记仓库
-func NewTagRepository(db *sql.DB) *TagRepository {
-	return &TagRepository{db: db}
+func NewTagRepository(db *sql.DB, keyring *secure.Keyring) *TagRepository {
+	return &TagRepository{db: db, keyring: keyring}
 }
 
-// CreateTag 创建标记
+// CreateTag 创建标记，tag.UserID 为标记所有者
 func (r *TagRepository) CreateTag(tag *models.Tag) (*models.Tag, error) {
 	query := `
-		INSERT INTO tags (name, description, color, created_at, updated_at)
-		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO tags (user_id, name, description, color, shared, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	result, err := r.db.Exec(query, tag.Name, tag.Description, tag.Color)
+	result, err := r.db.Exec(query, tag.UserID, tag.Name, tag.Description, tag.Color, tag.Shared)
 	if err != nil {
 		return nil, err
 	}
@@ -33,22 +35,24 @@ func (r *TagRepository) CreateTag(tag *models.Tag) (*models.Tag, error) {
 		return nil, err
 	}
 
-	return r.GetTagByID(int(id))
+	return r.GetUserTag(tag.UserID, int(id))
 }
 
-// GetTagByID 根据ID获取标记
+// GetTagByID 根据ID获取标记（不校验所有者，邮箱数量为所有用户的合计）
 func (r *TagRepository) GetTagByID(id int) (*models.Tag, error) {
 	query := `
-		SELECT id, name, description, color, created_at, updated_at
+		SELECT id, user_id, name, description, color, shared, created_at, updated_at
 		FROM tags WHERE id = ?
 	`
 
 	tag := &models.Tag{}
 	err := r.db.QueryRow(query, id).Scan(
 		&tag.ID,
+		&tag.UserID,
 		&tag.Name,
 		&tag.Description,
 		&tag.Color,
+		&tag.Shared,
 		&tag.CreatedAt,
 		&tag.UpdatedAt,
 	)
@@ -66,19 +70,21 @@ func (r *TagRepository) GetTagByID(id int) (*models.Tag, error) {
 	return tag, nil
 }
 
-// GetTagByName 根据名称获取标记
-func (r *TagRepository) GetTagByName(name string) (*models.Tag, error) {
+// GetUserTag 获取用户可见的标记（自己的标记或共享标记），邮箱数量只统计该用户的邮箱
+func (r *TagRepository) GetUserTag(userID, id int) (*models.Tag, error) {
 	query := `
-		SELECT id, name, description, color, created_at, updated_at
-		FROM tags WHERE name = ?
+		SELECT id, user_id, name, description, color, shared, created_at, updated_at
+		FROM tags WHERE id = ? AND (user_id = ? OR shared = 1)
 	`
 
 	tag := &models.Tag{}
-	err := r.db.QueryRow(query, name).Scan(
+	err := r.db.QueryRow(query, id, userID).Scan(
 		&tag.ID,
+		&tag.UserID,
 		&tag.Name,
 		&tag.Description,
 		&tag.Color,
+		&tag.Shared,
 		&tag.CreatedAt,
 		&tag.UpdatedAt,
 	)
@@ -87,8 +93,8 @@ func (r *TagRepository) GetTagByName(name string) (*models.Tag, error) {
 		return nil, err
 	}
 
-	// 获取关联的邮箱数量
-	count, err := r.GetTagEmailCount(tag.ID)
+	// 获取该用户关联的邮箱数量
+	count, err := r.GetUserTagEmailCount(userID, tag.ID)
 	if err == nil {
 		tag.EmailCount = count
 	}
@@ -96,77 +102,83 @@ func (r *TagRepository) GetTagByName(name string) (*models.Tag, error) {
 	return tag, nil
 }
 
-// GetAllTags 获取所有标记
-func (r *TagRepository) GetAllTags() ([]models.Tag, error) {
+// GetTagByName 根据名称获取用户自己的标记
+func (r *TagRepository) GetTagByName(userID int, name string) (*models.Tag, error) {
 	query := `
-		SELECT id, name, description, color, created_at, updated_at
-		FROM tags ORDER BY created_at DESC
+		SELECT id, user_id, name, description, color, shared, created_at, updated_at
+		FROM tags WHERE user_id = ? AND name = ?
 	`
 
-	rows, err := r.db.Query(query)
+	tag := &models.Tag{}
+	err := r.db.QueryRow(query, userID, name).Scan(
+		&tag.ID,
+		&tag.UserID,
+		&tag.Name,
+		&tag.Description,
+		&tag.Color,
+		&tag.Shared,
+		&tag.CreatedAt,
+		&tag.UpdatedAt,
+	)
+
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var tags []models.Tag
-	for rows.Next() {
-		var tag models.Tag
-		err := rows.Scan(
-			&tag.ID,
-			&tag.Name,
-			&tag.Description,
-			&tag.Color,
-			&tag.CreatedAt,
-			&tag.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		// 获取关联的邮箱数量
-		count, err := r.GetTagEmailCount(tag.ID)
-		if err == nil {
-			tag.EmailCount = count
-		}
 
-		tags = append(tags, tag)
+	// 获取该用户关联的邮箱数量
+	count, err := r.GetUserTagEmailCount(userID, tag.ID)
+	if err == nil {
+		tag.EmailCount = count
 	}
 
-	return tags, nil
+	return tag, nil
 }
 
-// UpdateTag 更新标记
+// UpdateTag 更新标记，只能更新 tag.UserID 拥有的标记，标记不存在或不属于该用户时返回 sql.ErrNoRows
 func (r *TagRepository) UpdateTag(tag *models.Tag) error {
 	query := `
 		UPDATE tags 
-		SET name = ?, description = ?, color = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
+		SET name = ?, description = ?, color = ?, shared = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?
 	`
 
-	_, err := r.db.Exec(query, tag.Name, tag.Description, tag.Color, tag.ID)
-	return err
+	result, err := r.db.Exec(query, tag.Name, tag.Description, tag.Color, tag.Shared, tag.ID, tag.UserID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
 }
 
-// DeleteTag 删除标记
-func (r *TagRepository) DeleteTag(id int) error {
-	// 先删除关联关系
-	if err := r.RemoveAllEmailTags(id); err != nil {
+// DeleteTag 删除用户拥有的标记，标记不存在或不属于该用户时返回 sql.ErrNoRows
+func (r *TagRepository) DeleteTag(userID, id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// 再删除标记
-	query := `DELETE FROM tags WHERE id = ?`
-	_, err := r.db.Exec(query, id)
-	return err
+	result, err := tx.Exec(`DELETE FROM tags WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	// 删除关联关系
+	if _, err := tx.Exec(`DELETE FROM email_tags WHERE tag_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// TagExists 检查标记是否存在
-func (r *TagRepository) TagExists(name string) (bool, error) {
-	query := `SELECT COUNT(*) FROM tags WHERE name = ?`
+// TagExists 检查用户是否已有同名标记
+func (r *TagRepository) TagExists(userID int, name string) (bool, error) {
+	query := `SELECT COUNT(*) FROM tags WHERE user_id = ? AND name = ?`
 
 	var count int
-	err := r.db.QueryRow(query, name).Scan(&count)
+	err := r.db.QueryRow(query, userID, name).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -208,6 +220,19 @@ func (r *TagRepository) GetTagEmailCount(tagID int) (int, error) {
 	return count, err
 }
 
+// GetUserTagEmailCount 获取标记关联的某个用户的邮箱数量
+func (r *TagRepository) GetUserTagEmailCount(userID, tagID int) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM email_tags et
+		INNER JOIN emails e ON et.email_id = e.id
+		WHERE et.tag_id = ? AND e.user_id = ?
+	`
+
+	var count int
+	err := r.db.QueryRow(query, tagID, userID).Scan(&count)
+	return count, err
+}
+
 // GetEmailsByTag 根据标记获取邮箱列表
 func (r *TagRepository) GetEmailsByTag(tagID int, limit, offset int) ([]models.Email, error) {
 	query := `
@@ -244,6 +269,9 @@ func (r *TagRepository) GetEmailsByTag(tagID int, limit, offset int) ([]models.E
 		if err != nil {
 			return nil, err
 		}
+		if err := decryptEmailCredentials(r.keyring, &email); err != nil {
+			return nil, err
+		}
 		emails = append(emails, email)
 	}
 
@@ -313,19 +341,20 @@ func (r *TagRepository) BatchRemoveEmailTags(emailIDs []int, tagID int) error {
 	return tx.Commit()
 }
 
-// GetTagsWithEmailCount 获取用户的标签列表（包含邮箱数量）
+// GetTagsWithEmailCount 获取用户可见的标签列表（自己的标签和共享标签），邮箱数量只统计该用户的邮箱
 func (r *TagRepository) GetTagsWithEmailCount(userID int) ([]models.Tag, error) {
 	query := `
-		SELECT t.id, t.name, t.description, t.color, t.created_at, t.updated_at,
-		       COALESCE(COUNT(et.email_id), 0) as email_count
+		SELECT t.id, t.user_id, t.name, t.description, t.color, t.shared, t.created_at, t.updated_at,
+		       COUNT(e.id) as email_count
 		FROM tags t
 		LEFT JOIN email_tags et ON t.id = et.tag_id
 		LEFT JOIN emails e ON et.email_id = e.id AND e.user_id = ?
-		GROUP BY t.id, t.name, t.description, t.color, t.created_at, t.updated_at
+		WHERE t.user_id = ? OR t.shared = 1
+		GROUP BY t.id
 		ORDER BY t.created_at DESC
 	`
 
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.Query(query, userID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -336,9 +365,11 @@ func (r *TagRepository) GetTagsWithEmailCount(userID int) ([]models.Tag, error)
 		var tag models.Tag
 		err := rows.Scan(
 			&tag.ID,
+			&tag.UserID,
 			&tag.Name,
 			&tag.Description,
 			&tag.Color,
+			&tag.Shared,
 			&tag.CreatedAt,
 			&tag.UpdatedAt,
 			&tag.EmailCount,
@@ -351,3 +382,15 @@ func (r *TagRepository) GetTagsWithEmailCount(userID int) ([]models.Tag, error)
 
 	return tags, nil
 }
+
+// requireAffected 没有更新任何行时返回 sql.ErrNoRows
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// TOTPRepository 两步验证数据库操作
+type TOTPRepository struct {
+	db *sql.DB
+}
+
+// NewTOTPRepository 创建两步验证仓库
+func NewTOTPRepository(db *sql.DB) *TOTPRepository {
+	return &TOTPRepository{db: db}
+}
+
+// GetTOTP 获取用户的两步验证配置，未生成密钥时返回 sql.ErrNoRows
+func (r *TOTPRepository) GetTOTP(userID int) (*models.UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, enabled, last_step, created_at, enabled_at
+		FROM user_totp WHERE user_id = ?
+	`
+
+	totp := &models.UserTOTP{}
+	err := r.db.QueryRow(query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastStep,
+		&totp.CreatedAt,
+		&totp.EnabledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// SaveSecret 保存待启用的密钥，覆盖之前未启用的密钥。已启用时返回 sql.ErrNoRows
+func (r *TOTPRepository) SaveSecret(userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, enabled, last_step, created_at)
+		VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, created_at = excluded.created_at
+		WHERE user_totp.enabled = 0
+	`
+
+	result, err := r.db.Exec(query, userID, secret, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// Enable 启用两步验证并写入恢复码哈希。step 为验证时使用的时间片
+func (r *TOTPRepository) Enable(userID int, step int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_totp SET enabled = 1, last_step = ?, enabled_at = ?
+		WHERE user_id = ? AND enabled = 0
+	`, step, time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Disable 停用两步验证，删除密钥和恢复码
+func (r *TOTPRepository) Disable(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep 记录已使用的时间片。时间片不晚于上次使用的时间片时（动态码被重放）返回 sql.ErrNoRows
+func (r *TOTPRepository) UseStep(userID int, step int64) error {
+	result, err := r.db.Exec(`UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// ReplaceRecoveryCodes 重新生成恢复码，旧的恢复码全部失效
+func (r *TOTPRepository) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode 使用恢复码，每个恢复码只能使用一次。恢复码不存在或已使用时返回 sql.ErrNoRows
+func (r *TOTPRepository) UseRecoveryCode(userID int, codeHash string) error {
+	query := `
+		UPDATE recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now().UTC(), userID, codeHash)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+// CountRecoveryCodes 统计用户未使用的恢复码数量
+func (r *TOTPRepository) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// replaceRecoveryCodes 在事务中删除旧恢复码并写入新恢复码
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, hash := range codeHashes {
+		if _, err := stmt.Exec(userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
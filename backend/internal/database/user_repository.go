@@ -19,7 +19,7 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 }
 
 // CreateUser 创建用户
-func (r *UserRepository) CreateUser(username, password string) (*models.User, error) {
+func (r *UserRepository) CreateUser(username, password, role string) (*models.User, error) {
 	// 加密密码
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -27,11 +27,11 @@ func (r *UserRepository) CreateUser(username, password string) (*models.User, er
 	}
 
 	query := `
-		INSERT INTO users (username, password_hash, created_at, updated_at)
-		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO users (username, password_hash, role, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	result, err := r.db.Exec(query, username, string(hashedPassword))
+	result, err := r.db.Exec(query, username, string(hashedPassword), role)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +47,7 @@ func (r *UserRepository) CreateUser(username, password string) (*models.User, er
 // GetUserByID 根据ID获取用户
 func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
 	query := `
-		SELECT id, username, password_hash, last_login_at, created_at, updated_at
+		SELECT id, username, password_hash, role, disabled, last_login_at, created_at, updated_at
 		FROM users WHERE id = ?
 	`
 
@@ -56,6 +56,8 @@ func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
 		&user.ID,
 		&user.Username,
 		&user.PasswordHash,
+		&user.Role,
+		&user.Disabled,
 		&user.LastLoginAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
@@ -71,7 +73,7 @@ func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
 // GetUserByUsername 根据用户名获取用户
 func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
 	query := `
-		SELECT id, username, password_hash, last_login_at, created_at, updated_at
+		SELECT id, username, password_hash, role, disabled, last_login_at, created_at, updated_at
 		FROM users WHERE username = ?
 	`
 
@@ -80,6 +82,8 @@ func (r *UserRepository) GetUserByUsername(username string) (*models.User, error
 		&user.ID,
 		&user.Username,
 		&user.PasswordHash,
+		&user.Role,
+		&user.Disabled,
 		&user.LastLoginAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
@@ -110,6 +114,47 @@ func (r *UserRepository) UpdateLastLogin(userID int) error {
 	return err
 }
 
+// UpdatePassword 更新用户密码
+func (r *UserRepository) UpdatePassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE users
+		SET password_hash = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err = r.db.Exec(query, string(hashedPassword), userID)
+	return err
+}
+
+// SetDisabled 启用或禁用用户
+func (r *UserRepository) SetDisabled(userID int, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, disabled, userID)
+	return err
+}
+
+// SetRole 设置用户角色
+func (r *UserRepository) SetRole(userID int, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, role, userID)
+	return err
+}
+
 // UserExists 检查用户是否存在
 func (r *UserRepository) UserExists(username string) (bool, error) {
 	query := `SELECT COUNT(*) FROM users WHERE username = ?`
@@ -126,7 +171,7 @@ func (r *UserRepository) UserExists(username string) (bool, error) {
 // GetAllUsers 获取所有用户（管理功能）
 func (r *UserRepository) GetAllUsers() ([]models.User, error) {
 	query := `
-		SELECT id, username, password_hash, last_login_at, created_at, updated_at
+		SELECT id, username, password_hash, role, disabled, last_login_at, created_at, updated_at
 		FROM users ORDER BY created_at DESC
 	`
 
@@ -143,6 +188,8 @@ func (r *UserRepository) GetAllUsers() ([]models.User, error) {
 			&user.ID,
 			&user.Username,
 			&user.PasswordHash,
+			&user.Role,
+			&user.Disabled,
 			&user.LastLoginAt,
 			&user.CreatedAt,
 			&user.UpdatedAt,
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,32 +10,82 @@ type User struct {
 	ID           int        `json:"id" db:"id"`
 	Username     string     `json:"username" db:"username"`
 	PasswordHash string     `json:"-" db:"password_hash"`
+	Role         string     `json:"role" db:"role"`
+	Disabled     bool       `json:"disabled" db:"disabled"`
 	LastLoginAt  *time.Time `json:"last_login_at" db:"last_login_at"`
 	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
 }
 
+// 用户角色
+const (
+	UserRoleOwner    = "owner"    // 所有者：全部权限，包括用户和系统管理
+	UserRoleOperator = "operator" // 操作员：管理邮箱，但不能管理用户和系统设置
+	UserRoleViewer   = "viewer"   // 只读：只能查看邮箱和邮件
+)
+
+// IsValidUserRole 检查用户角色是否合法
+func IsValidUserRole(role string) bool {
+	switch role {
+	case UserRoleOwner, UserRoleOperator, UserRoleViewer:
+		return true
+	}
+	return false
+}
+
 // Email 邮箱模型
 type Email struct {
-	ID              int        `json:"id" db:"id"`
-	UserID          int        `json:"user_id" db:"user_id"`
-	EmailAddress    string     `json:"email_address" db:"email_address"`
-	Password        string     `json:"-" db:"password"`
-	ClientID        string     `json:"-" db:"client_id"`
-	RefreshToken    string     `json:"-" db:"refresh_token"`
-	Remark          string     `json:"remark" db:"remark"`
-	LastOperationAt *time.Time `json:"last_operation_at" db:"last_operation_at"`
-	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
-	Tags            []Tag      `json:"tags,omitempty"`
+	ID                  int        `json:"id" db:"id"`
+	UserID              int        `json:"user_id" db:"user_id"`
+	EmailAddress        string     `json:"email_address" db:"email_address"`
+	Password            string     `json:"-" db:"password"`
+	ClientID            string     `json:"-" db:"client_id"`
+	RefreshToken        string     `json:"-" db:"refresh_token"`
+	Remark              string     `json:"remark" db:"remark"`
+	LastOperationAt     *time.Time `json:"last_operation_at" db:"last_operation_at"`
+	Status              string     `json:"status" db:"status"`
+	LastCheckedAt       *time.Time `json:"last_checked_at" db:"last_checked_at"`
+	LastError           string     `json:"last_error" db:"last_error"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	Provider            string     `json:"provider" db:"provider"` // 邮件后端，为空时使用部署默认值
+	TokenRefreshedAt    *time.Time `json:"token_refreshed_at" db:"token_refreshed_at"`
+	Shared              bool       `json:"shared" db:"shared"` // 所有者设置共享后其他用户可以查看邮件
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+	Tags                []Tag      `json:"tags,omitempty"`
+}
+
+// 邮箱账户状态
+const (
+	EmailStatusActive       = "active"        // 正常
+	EmailStatusInvalidToken = "invalid_token" // 令牌失效
+	EmailStatusLocked       = "locked"        // 账户被锁定或封禁
+	EmailStatusUnknown      = "unknown"       // 未检测
+)
+
+// IsValidEmailStatus 检查账户状态是否合法
+func IsValidEmailStatus(status string) bool {
+	switch status {
+	case EmailStatusActive, EmailStatusInvalidToken, EmailStatusLocked, EmailStatusUnknown:
+		return true
+	}
+	return false
 }
 
+// 邮件后端
+const (
+	MailProviderProxy = "proxy" // 通过msOauth2api代理服务
+	MailProviderGraph = "graph" // 直连Microsoft Graph
+)
+
 // Tag 标记模型
 type Tag struct {
 	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"` // 标记所有者
 	Name        string    `json:"name" db:"name"`
 	Description string    `json:"description" db:"description"`
 	Color       string    `json:"color" db:"color"`
+	Shared      bool      `json:"shared" db:"shared"` // 共享标记对所有用户可见，只有所有者可以修改
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 	EmailCount  int       `json:"email_count,omitempty"`
@@ -51,9 +102,34 @@ type OperationLog struct {
 	Description   string    `json:"description" db:"description"`
 	IPAddress     string    `json:"ip_address" db:"ip_address"`
 	UserAgent     string    `json:"user_agent" db:"user_agent"`
+	APIKeyID      *int      `json:"api_key_id" db:"api_key_id"`         // 使用API密钥操作时的密钥ID
+	APIKeyPrefix  string    `json:"api_key_prefix" db:"api_key_prefix"` // 使用API密钥操作时的密钥前缀
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 }
 
+// ClientInfo 发起操作的客户端，记录到操作日志
+type ClientInfo struct {
+	IPAddress    string
+	UserAgent    string
+	APIKeyID     *int   // 使用API密钥认证时的密钥ID
+	APIKeyPrefix string // 使用API密钥认证时的密钥前缀
+}
+
+// MailMonitor 邮箱轮询监控模型
+type MailMonitor struct {
+	EmailID       int        `json:"email_id" db:"email_id"`
+	UserID        int        `json:"user_id"`
+	EmailAddress  string     `json:"email_address"`
+	Enabled       bool       `json:"enabled" db:"enabled"`
+	LastMailID    string     `json:"last_mail_id" db:"last_mail_id"`
+	BaselineTaken bool       `json:"baseline_taken" db:"baseline_taken"` // 是否已成功检查过一次，之后收到的邮件都是新邮件
+	LastCheckedAt *time.Time `json:"last_checked_at" db:"last_checked_at"`
+	LastNewMailAt *time.Time `json:"last_new_mail_at" db:"last_new_mail_at"`
+	LastError     string     `json:"last_error" db:"last_error"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+}
+
 // OutlookMail Outlook邮件模型
 type OutlookMail struct {
 	ID         string    `json:"id"`
@@ -64,6 +140,159 @@ type OutlookMail struct {
 	IsRead     bool      `json:"is_read"`
 	ReceivedAt time.Time `json:"received_at"`
 	VerifyCode string    `json:"verify_code,omitempty"`
+	VerifyLink string    `json:"verify_link,omitempty"`
+}
+
+// StoredMessage 本地存储的邮件模型
+type StoredMessage struct {
+	ID         int       `json:"id" db:"id"`
+	EmailID    int       `json:"email_id" db:"email_id"`
+	MessageID  string    `json:"message_id" db:"message_id"` // 上游邮件ID
+	Mailbox    string    `json:"mailbox" db:"mailbox"`
+	Subject    string    `json:"subject" db:"subject"`
+	From       string    `json:"from" db:"sender"`
+	To         string    `json:"to" db:"recipient"`
+	Body       string    `json:"body" db:"body"`
+	IsRead     bool      `json:"is_read" db:"is_read"`
+	VerifyCode string    `json:"verify_code,omitempty" db:"verify_code"`
+	VerifyLink string    `json:"verify_link,omitempty" db:"verify_link"`
+	ReceivedAt time.Time `json:"received_at" db:"received_at"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	BodyText   string    `json:"-" db:"-"` // 去除HTML后的正文，仅用于全文索引
+}
+
+// HealthCheckRequest 账户健康检查请求，未指定邮箱和标签时检查全部邮箱
+type HealthCheckRequest struct {
+	EmailIDs   []int `json:"email_ids"`
+	TagID      int   `json:"tag_id"`
+	TagInvalid bool  `json:"tag_invalid"` // 是否为失效账户自动添加失效标签
+}
+
+// MessageSearchResult 本地邮件全文搜索结果
+type MessageSearchResult struct {
+	ID               int       `json:"id"`
+	EmailID          int       `json:"email_id"`
+	EmailAddress     string    `json:"email_address"`
+	MessageID        string    `json:"message_id"`
+	Mailbox          string    `json:"mailbox"`
+	Subject          string    `json:"subject"`
+	From             string    `json:"from"`
+	VerifyCode       string    `json:"verify_code,omitempty"`
+	ReceivedAt       time.Time `json:"received_at"`
+	SubjectHighlight string    `json:"subject_highlight"`
+	SenderHighlight  string    `json:"sender_highlight"`
+	BodySnippet      string    `json:"body_snippet"`
+}
+
+// CodeRule 验证码提取规则模型
+type CodeRule struct {
+	ID           int       `json:"id" db:"id"`
+	UserID       int       `json:"user_id" db:"user_id"`
+	SenderDomain string    `json:"sender_domain" db:"sender_domain"` // 为空表示适用于所有发件人
+	RuleType     string    `json:"rule_type" db:"rule_type"`         // code 或 link
+	Pattern      string    `json:"pattern" db:"pattern"`
+	Priority     int       `json:"priority" db:"priority"` // 数值越小越优先
+	Enabled      bool      `json:"enabled" db:"enabled"`
+	Description  string    `json:"description" db:"description"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// APIKey 个人API密钥，供脚本通过 X-API-Key 请求头认证，只保存密钥哈希
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // 密钥开头几位，用于识别密钥
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"` // 允许的权限，取值同角色权限
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"` // 为空表示永不过期
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Session 登录会话，每次登录创建一个，令牌的 jti 对应会话的 TokenID
+type Session struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	TokenID    string     `json:"-" db:"token_id"` // 当前有效令牌的 jti，刷新令牌时更新
+	IPAddress  string     `json:"ip_address" db:"ip_address"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	Current    bool       `json:"current"` // 是否为当前请求使用的会话
+}
+
+// UserTOTP 用户的TOTP两步验证配置，生成密钥后需验证一次动态码才会启用
+type UserTOTP struct {
+	UserID    int        `json:"user_id" db:"user_id"`
+	Secret    string     `json:"-" db:"secret"` // base32编码的TOTP密钥
+	Enabled   bool       `json:"enabled" db:"enabled"`
+	LastStep  int64      `json:"-" db:"last_step"` // 最近一次使用的时间片，防止动态码重放
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	EnabledAt *time.Time `json:"enabled_at" db:"enabled_at"`
+}
+
+// 后台任务状态
+const (
+	JobStatusPending   = "pending"   // 等待执行
+	JobStatusRunning   = "running"   // 执行中
+	JobStatusCompleted = "completed" // 已完成
+	JobStatusFailed    = "failed"    // 执行失败
+	JobStatusCancelled = "cancelled" // 已取消
+)
+
+// Job 后台任务，保存在数据库中，服务重启后未完成的任务会继续执行
+type Job struct {
+	ID           string          `json:"id" db:"id"`
+	UserID       int             `json:"-" db:"user_id"`
+	Type         string          `json:"type" db:"type"`
+	Status       string          `json:"status" db:"status"`
+	Payload      string          `json:"-" db:"payload"` // 任务参数（JSON），可能包含凭据，加密保存
+	Total        int             `json:"total" db:"total"`
+	Done         int             `json:"done"`
+	Success      int             `json:"success"`
+	Failed       int             `json:"failed"`
+	Result       json.RawMessage `json:"result,omitempty" db:"result"` // 任务类型相关的汇总结果
+	Error        string          `json:"error,omitempty" db:"error"`
+	IPAddress    string          `json:"-" db:"ip_address"`
+	UserAgent    string          `json:"-" db:"user_agent"`
+	APIKeyID     *int            `json:"-" db:"api_key_id"`
+	APIKeyPrefix string          `json:"-" db:"api_key_prefix"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	StartedAt    *time.Time      `json:"started_at,omitempty" db:"started_at"`
+	FinishedAt   *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+	Items        []JobItem       `json:"items,omitempty"`
+}
+
+// Client 提交任务的客户端，任务执行时的操作日志沿用
+func (j *Job) Client() ClientInfo {
+	return ClientInfo{IPAddress: j.IPAddress, UserAgent: j.UserAgent, APIKeyID: j.APIKeyID, APIKeyPrefix: j.APIKeyPrefix}
+}
+
+// Finished 任务是否已结束
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusCompleted || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
+
+// JobItem 任务中单个对象的处理结果。Index 从1开始，导入文件时为行号
+type JobItem struct {
+	Index        int    `json:"index" db:"item_index"`
+	EmailID      int    `json:"email_id,omitempty" db:"email_id"`
+	EmailAddress string `json:"email_address,omitempty" db:"email_address"`
+	Success      bool   `json:"success" db:"success"`
+	Status       string `json:"status" db:"status"` // 任务类型相关的结果状态，如 added、cleared、invalid_token
+	Error        string `json:"error,omitempty" db:"error"`
+	DurationMs   int64  `json:"duration_ms" db:"duration_ms"`
+}
+
+// JobInput 任务的单项输入，加密保存在数据库中，任务结束时删除。Index 与 JobItem.Index 对应
+type JobInput struct {
+	Index int
+	Data  string
 }
 
 // DashboardStats 仪表盘统计数据
@@ -75,16 +304,91 @@ type DashboardStats struct {
 	OperationsByType map[string]int `json:"operations_by_type"`
 }
 
-// LoginRequest 登录请求
+// LoginRequest 登录请求，使用用户名密码登录，或使用 AUTH_TOKEN 以初始管理员身份登录
 type LoginRequest struct {
-	AuthToken string `json:"auth_token" binding:"required"`
+	AuthToken string `json:"auth_token"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// CreateUserRequest 创建用户请求
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+	Password string `json:"password" binding:"required,min=8"`
+	Role     string `json:"role" binding:"omitempty,oneof=owner operator viewer"`
 }
 
-// LoginResponse 登录响应
+// CreateAPIKeyRequest 创建API密钥请求
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1"` // 为0表示永不过期
+}
+
+// CreateAPIKeyResponse 创建API密钥响应，完整密钥只在创建时返回一次
+type CreateAPIKeyResponse struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"api_key"`
+}
+
+// SetUserRoleRequest 修改用户角色请求
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner operator viewer"`
+}
+
+// SetUserDisabledRequest 启用/禁用用户请求
+type SetUserDisabledRequest struct {
+	Disabled *bool `json:"disabled" binding:"required"`
+}
+
+// ResetPasswordRequest 重置用户密码请求
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// ChangePasswordRequest 修改自己的密码请求
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// LoginResponse 登录响应。启用了两步验证时只返回 ChallengeToken，
+// 需要通过 /api/auth/2fa/verify 提交动态码换取正式令牌
 type LoginResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expires_at"`
-	User      User   `json:"user"`
+	Token             string `json:"token,omitempty"`
+	ExpiresAt         int64  `json:"expires_at"`
+	User              *User  `json:"user,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// VerifyTwoFactorRequest 登录第二步：提交动态码或恢复码
+type VerifyTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// TwoFactorCodeRequest 启用、停用两步验证或重新生成恢复码时提交的动态码（停用和重新生成也可以使用恢复码）
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorSetupResponse 生成两步验证密钥的响应，ProvisioningURI 可生成二维码供验证器App扫描
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorRecoveryCodesResponse 恢复码只在生成时返回一次
+type TwoFactorRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorStatus 两步验证状态
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
 }
 
 // AddEmailRequest 添加邮箱请求
@@ -94,6 +398,7 @@ type AddEmailRequest struct {
 	ClientID     string `json:"client_id" binding:"required"`
 	RefreshToken string `json:"refresh_token" binding:"required"`
 	Remark       string `json:"remark"`
+	Provider     string `json:"provider" binding:"omitempty,oneof=proxy graph"`
 }
 
 // BatchAddEmailRequest 批量添加邮箱请求
@@ -106,6 +411,7 @@ type CreateTagRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	Color       string `json:"color" binding:"required"`
+	Shared      bool   `json:"shared"`
 }
 
 // UpdateTagRequest 更新标记请求
@@ -113,6 +419,12 @@ type UpdateTagRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Color       string `json:"color"`
+	Shared      *bool  `json:"shared"`
+}
+
+// ShareEmailRequest 设置邮箱共享请求
+type ShareEmailRequest struct {
+	Shared *bool `json:"shared" binding:"required"`
 }
 
 // TagEmailRequest 标记邮箱请求
@@ -121,6 +433,34 @@ type TagEmailRequest struct {
 	TagID    int   `json:"tag_id" binding:"required"`
 }
 
+// SetMonitorRequest 设置邮箱监控请求
+type SetMonitorRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// MonitorSettingsRequest 更新轮询设置请求
+type MonitorSettingsRequest struct {
+	IntervalSeconds int `json:"interval_seconds" binding:"omitempty,min=10"`
+	Workers         int `json:"workers" binding:"omitempty,min=1,max=50"`
+}
+
+// CodeRuleRequest 创建/更新验证码提取规则请求
+type CodeRuleRequest struct {
+	SenderDomain string `json:"sender_domain"`
+	RuleType     string `json:"rule_type" binding:"required,oneof=code link"`
+	Pattern      string `json:"pattern" binding:"required"`
+	Priority     int    `json:"priority"`
+	Enabled      *bool  `json:"enabled"`
+	Description  string `json:"description"`
+}
+
+// TestCodeRuleRequest 测试验证码提取请求
+type TestCodeRuleRequest struct {
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Body    string `json:"body" binding:"required"`
+}
+
 // FieldOption 字段选项
 type FieldOption struct {
 	Key   string `json:"key" binding:"required"`
@@ -149,4 +489,5 @@ type APIResponse struct {
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"` // 错误代码，便于客户端区分错误类型
 }
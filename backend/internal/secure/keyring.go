@@ -0,0 +1,212 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeySize 主密钥和数据密钥长度（AES-256）
+const KeySize = 32
+
+// 加密值前缀，格式为 enc:v1:<数据密钥ID>:<base64(nonce+密文)>
+const encryptedPrefix = "enc:v1:"
+
+var (
+	// ErrKeyringMissing 数据已加密但未配置密钥
+	ErrKeyringMissing = errors.New("凭据已加密，但未配置 CREDENTIAL_KEY 或 CREDENTIAL_KEY_FILE")
+	// ErrUnknownDataKey 加密值使用的数据密钥不存在
+	ErrUnknownDataKey = errors.New("找不到加密凭据对应的数据密钥")
+	// ErrInvalidMasterKey 主密钥格式错误
+	ErrInvalidMasterKey = errors.New("主密钥必须是base64编码的32字节密钥，可使用 openssl rand -base64 32 生成")
+)
+
+// Keyring 凭据信封加密：数据密钥加密凭据，主密钥加密数据密钥。
+// nil 表示未启用加密，Encrypt 原样返回明文
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     map[int][]byte
+	activeID int
+}
+
+// NewKeyring 创建空的密钥环
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[int][]byte)}
+}
+
+// Add 添加数据密钥，active为true时用于加密新数据
+func (k *Keyring) Add(id int, key []byte, active bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	if active {
+		k.activeID = id
+	}
+}
+
+// ActiveID 当前用于加密的数据密钥ID
+func (k *Keyring) ActiveID() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeID
+}
+
+// Encrypt 使用当前数据密钥加密，空字符串不加密
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+
+	k.mu.RLock()
+	id := k.activeID
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return "", ErrUnknownDataKey
+	}
+
+	sealed, err := seal(key, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + strconv.Itoa(id) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密凭据，未加密的旧数据原样返回
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrKeyringMissing
+	}
+
+	rest := strings.TrimPrefix(value, encryptedPrefix)
+	idx := strings.Index(rest, ":")
+	if idx < 0 {
+		return "", fmt.Errorf("加密凭据格式错误")
+	}
+	id, err := strconv.Atoi(rest[:idx])
+	if err != nil {
+		return "", fmt.Errorf("加密凭据格式错误: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(rest[idx+1:])
+	if err != nil {
+		return "", fmt.Errorf("加密凭据格式错误: %v", err)
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return "", ErrUnknownDataKey
+	}
+
+	plaintext, err := open(key, data)
+	if err != nil {
+		return "", fmt.Errorf("解密凭据失败: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted 判断值是否已加密
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// GenerateKey 生成随机密钥
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey 使用主密钥加密数据密钥
+func WrapKey(masterKey, dataKey []byte) (string, error) {
+	sealed, err := seal(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnwrapKey 使用主密钥解密数据密钥
+func UnwrapKey(masterKey []byte, wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	key, err := open(masterKey, data)
+	if err != nil {
+		return nil, errors.New("主密钥错误，无法解密数据密钥")
+	}
+	return key, nil
+}
+
+// LoadMasterKey 从配置或密钥文件加载主密钥，都未配置时返回nil
+func LoadMasterKey(value, file string) ([]byte, error) {
+	if value == "" && file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+		}
+		value = string(content)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidMasterKey
+	}
+	return key, nil
+}
+
+// seal AES-GCM加密，返回 nonce+密文
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open AES-GCM解密
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度错误")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// newGCM 创建AES-GCM
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
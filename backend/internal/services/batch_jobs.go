@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// batchTagChunkSize 批量标记任务每次处理的邮箱数量
+const batchTagChunkSize = 500
+
+// batchEmailsPayload 批量操作任务参数
+type batchEmailsPayload struct {
+	EmailIDs []int `json:"email_ids"`
+	TagID    int   `json:"tag_id,omitempty"`
+}
+
+// registerBatchJobs 注册批量清空和批量标记任务
+func (s *EmailService) registerBatchJobs() {
+	s.jobs.Register(JobBatchClearInbox, func(ctx context.Context, run *JobRun) error {
+		return s.runBatchClear(ctx, run, false)
+	}, nil)
+	s.jobs.Register(JobBatchClearJunk, func(ctx context.Context, run *JobRun) error {
+		return s.runBatchClear(ctx, run, true)
+	}, nil)
+	s.jobs.Register(JobBatchTag, func(ctx context.Context, run *JobRun) error {
+		return s.runBatchTag(ctx, run, false)
+	}, nil)
+	s.jobs.Register(JobBatchUntag, func(ctx context.Context, run *JobRun) error {
+		return s.runBatchTag(ctx, run, true)
+	}, nil)
+}
+
+// StartBatchClear 创建批量清空收件箱的后台任务，junk 为true时清空垃圾箱
+func (s *EmailService) StartBatchClear(userID int, emailIDs []int, junk bool, client models.ClientInfo) (*models.Job, error) {
+	if len(emailIDs) == 0 {
+		return nil, errors.New("邮箱ID列表不能为空")
+	}
+
+	jobType := JobBatchClearInbox
+	if junk {
+		jobType = JobBatchClearJunk
+	}
+	emailIDs = uniqueEmailIDs(emailIDs)
+	return s.jobs.Submit(userID, jobType, batchEmailsPayload{EmailIDs: emailIDs}, len(emailIDs), client)
+}
+
+// StartBatchTag 创建批量添加标记的后台任务，remove 为true时移除标记
+func (s *EmailService) StartBatchTag(userID int, req *models.TagEmailRequest, remove bool, client models.ClientInfo) (*models.Job, error) {
+	if len(req.EmailIDs) == 0 {
+		return nil, errors.New("邮箱ID列表不能为空")
+	}
+
+	jobType := JobBatchTag
+	if remove {
+		jobType = JobBatchUntag
+	}
+	payload := batchEmailsPayload{EmailIDs: req.EmailIDs, TagID: req.TagID}
+	return s.jobs.Submit(userID, jobType, payload, len(req.EmailIDs), client)
+}
+
+// runBatchClear 并发清空邮箱，继续执行时跳过已处理的邮箱
+func (s *EmailService) runBatchClear(ctx context.Context, run *JobRun, junk bool) error {
+	var payload batchEmailsPayload
+	if err := run.Decode(&payload); err != nil {
+		return err
+	}
+
+	var tasks []clearTask
+	for i, emailID := range payload.EmailIDs {
+		if !run.Completed(i + 1) {
+			tasks = append(tasks, clearTask{index: i + 1, emailID: emailID})
+		}
+	}
+
+	s.clearMailboxes(ctx, run.Job.UserID, tasks, junk, run.Job.Client(), run.Record)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 记录批量操作日志
+	operation, label := "batch_clear_inbox", "收件箱"
+	if junk {
+		operation, label = "batch_clear_junk", "垃圾箱"
+	}
+	_, success, failed := run.Counts()
+	s.logRepo.LogEmail(run.Job.UserID, operation, 0,
+		fmt.Sprintf("批量清空%s，成功: %d, 失败: %d", label, success, failed),
+		run.Job.Client())
+
+	return nil
+}
+
+// uniqueEmailIDs 去除重复的邮箱ID，保留第一次出现的顺序
+func uniqueEmailIDs(emailIDs []int) []int {
+	seen := make(map[int]bool, len(emailIDs))
+	unique := make([]int, 0, len(emailIDs))
+	for _, id := range emailIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// runBatchTag 分块为邮箱添加或移除标记，不属于当前用户的邮箱记为失败
+func (s *EmailService) runBatchTag(ctx context.Context, run *JobRun, remove bool) error {
+	var payload batchEmailsPayload
+	if err := run.Decode(&payload); err != nil {
+		return err
+	}
+	userID := run.Job.UserID
+
+	// 验证标记是否对当前用户可见
+	if _, err := s.tagRepo.GetUserTag(userID, payload.TagID); err != nil {
+		return errors.New("标记不存在")
+	}
+
+	status, apply := "tagged", s.tagRepo.BatchAddEmailTags
+	if remove {
+		status, apply = "untagged", s.tagRepo.BatchRemoveEmailTags
+	}
+
+	for start := 0; start < len(payload.EmailIDs); start += batchTagChunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var indexes, emailIDs []int
+		for i := start; i < min(start+batchTagChunkSize, len(payload.EmailIDs)); i++ {
+			if !run.Completed(i + 1) {
+				indexes = append(indexes, i+1)
+				emailIDs = append(emailIDs, payload.EmailIDs[i])
+			}
+		}
+		if len(emailIDs) == 0 {
+			continue
+		}
+
+		// 只处理属于当前用户的邮箱
+		emails, err := s.emailRepo.GetEmailsByIDs(userID, emailIDs)
+		if err != nil {
+			return err
+		}
+		owned := make(map[int]string, len(emails))
+		validIDs := make([]int, 0, len(emails))
+		for _, email := range emails {
+			owned[email.ID] = email.EmailAddress
+			validIDs = append(validIDs, email.ID)
+		}
+
+		if err := apply(validIDs, payload.TagID); err != nil {
+			return err
+		}
+
+		for i, emailID := range emailIDs {
+			item := models.JobItem{Index: indexes[i], EmailID: emailID, Status: "failed"}
+			if address, ok := owned[emailID]; ok {
+				item.EmailAddress = address
+				item.Status = status
+				item.Success = true
+			} else {
+				item.Error = "邮箱不存在或无权访问"
+			}
+			run.Record(item)
+		}
+	}
+
+	// 记录操作日志
+	operation, description := "batch_tag_emails", "批量标记邮箱"
+	if remove {
+		operation, description = "batch_untag_emails", "批量取消标记邮箱"
+	}
+	_, success, _ := run.Counts()
+	s.logRepo.LogTag(userID, operation, payload.TagID,
+		fmt.Sprintf("%s，邮箱数量: %d", description, success),
+		run.Job.Client())
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen 熔断器打开，请求未发送到上游
+var ErrCircuitOpen = errors.New("上游服务熔断中")
+
+// BreakerStatus 熔断器状态快照
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Threshold           int        `json:"threshold"`
+	CooldownSeconds     int        `json:"cooldown_seconds"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAfterSeconds   int        `json:"retry_after_seconds,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// CircuitBreaker 上游熔断器：连续失败达到阈值后打开，冷却后放行一个探测请求
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// NewCircuitBreaker 创建熔断器，threshold<=0 时不启用熔断
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow 判断是否允许发送请求，不允许时返回剩余冷却时间
+func (b *CircuitBreaker) Allow() (time.Duration, bool) {
+	if b.threshold <= 0 {
+		return 0, true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		remaining := b.cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return remaining, false
+		}
+		// 冷却结束，放行一个探测请求
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return 0, true
+	case BreakerHalfOpen:
+		if b.probing {
+			return b.cooldown, false
+		}
+		b.probing = true
+		return 0, true
+	}
+	return 0, true
+}
+
+// Record 记录请求结果，只有上游不可用类错误计为失败
+func (b *CircuitBreaker) Record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isUpstreamFailure(err) {
+		if b.state != BreakerClosed {
+			log.Printf("Circuit breaker %s closed", b.name)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = truncateError(err.Error())
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			log.Printf("Circuit breaker %s opened after %d consecutive failures: %v", b.name, b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release 放弃本次请求的结果（如调用方取消），不计成功或失败。
+// 被放弃的是半开状态的探测请求时，允许下一个请求重新探测
+func (b *CircuitBreaker) Release() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Failures 当前连续失败次数
+func (b *CircuitBreaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// Status 获取熔断器状态
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Threshold:           b.threshold,
+		CooldownSeconds:     int(b.cooldown.Seconds()),
+		LastError:           b.lastError,
+	}
+	if b.threshold <= 0 {
+		status.State = "disabled"
+	}
+	if b.state == BreakerOpen {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			status.RetryAfterSeconds = int(remaining.Seconds()) + 1
+		}
+	}
+	return status
+}
+
+// isUpstreamFailure 判断是否为上游整体故障（网络错误、5xx、响应异常），账户级错误不计入。
+// 限流（MailboxConcurrency、ApplicationThrottled 等）针对单个邮箱，计入会让少数邮箱的限流
+// 打开整个端点的熔断器，影响所有账户；限流只按 Retry-After 推迟当前请求的重试
+func isUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrRateLimited) {
+		return false
+	}
+	return errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrBadResponse)
+}
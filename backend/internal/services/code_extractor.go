@@ -0,0 +1,358 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+)
+
+// 规则类型
+const (
+	RuleTypeCode = "code"
+	RuleTypeLink = "link"
+)
+
+// compiledRule 已编译的提取规则
+type compiledRule struct {
+	senderDomain string
+	ruleType     string
+	re           *regexp.Regexp
+	validate     func(string) bool
+}
+
+// 内置规则，在用户自定义规则之后按顺序尝试
+var builtinRules = []compiledRule{
+	// 关键字在前：验证码：123456 / Your verification code for Contoso is 123456。
+	// 英文关键字要求单词边界，间隔内不能换行，避免 shopping、barcode 等单词中的片段被当作关键字
+	{ruleType: RuleTypeCode, re: regexp.MustCompile(`(?i)(?:验证码|校验码|动态码|确认码|驗證碼|\b(?:verification|security|one-time|login|access)\s+code\b|\bpasscode\b|\botp\b)[^\d\n]{0,20}?(\d{4,8})(?:\D|$)`)},
+	// 单独的 code / pin 含义太宽（zip code、order code），只接受紧跟冒号或 is 的形式：Your code is 123456 / PIN: 1234
+	{ruleType: RuleTypeCode, re: regexp.MustCompile(`(?i)\b(?:code|pin)\s*(?:is\s*[:：]?|[:：])\s*(\d{4,8})(?:\D|$)`)},
+	// 关键字在后：123456 is your code / 123456 是您的验证码
+	{ruleType: RuleTypeCode, re: regexp.MustCompile(`(?i)(?:^|\D)(\d{4,8})\s*(?:is your|is the|是您的|为您的|為您的)`)},
+	// 字母数字混合验证码：Code: A1B2C3
+	{ruleType: RuleTypeCode, re: regexp.MustCompile(`(?i)(?:(?:验证码|校验码|\botp\b)\s*[:：是为]?|\bcode\s*(?:is\s*[:：]?|[:：]))\s*([A-Z0-9]{5,8})\b`), validate: isMixedCode},
+	// 单独成行的6位数字
+	{ruleType: RuleTypeCode, re: regexp.MustCompile(`(?m)^\s*(\d{6})\s*$`)},
+	// 魔法链接：包含验证/确认/登录等关键字的链接
+	{ruleType: RuleTypeLink, re: regexp.MustCompile(`(?i)^https?://\S*(?:verify|verification|confirm|activate|activation|magic|signin|sign-in|login|auth|token)\S*$`)},
+}
+
+var (
+	scriptStylePattern = regexp.MustCompile(`(?is)<(?:script|style)[^>]*>.*?</(?:script|style)>`)
+	blockTagPattern    = regexp.MustCompile(`(?i)<(?:br|/p|/div|/tr|/li|/h[1-6])[^>]*>`)
+	tagPattern         = regexp.MustCompile(`<[^>]+>`)
+	hrefPattern        = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+	bareURLPattern     = regexp.MustCompile(`https?://[^\s<>"']+`)
+	spacePattern       = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+// CodeExtractor 本地验证码提取引擎
+type CodeExtractor struct {
+	ruleRepo *database.CodeRuleRepository
+
+	mu    sync.RWMutex
+	cache map[int][]compiledRule // userID -> 已编译的用户规则
+}
+
+// NewCodeExtractor 创建验证码提取引擎
+func NewCodeExtractor(db *database.DB) *CodeExtractor {
+	return &CodeExtractor{
+		ruleRepo: db.CodeRule,
+		cache:    make(map[int][]compiledRule),
+	}
+}
+
+// Enrich 上游未返回验证码或链接时，使用本地规则从邮件中提取
+func (e *CodeExtractor) Enrich(userID int, mail *models.OutlookMail) {
+	if e == nil || mail == nil {
+		return
+	}
+	if mail.VerifyCode != "" && mail.VerifyLink != "" {
+		return
+	}
+
+	code, link := e.Extract(userID, mail.From, mail.Subject, mail.Body)
+	if mail.VerifyCode == "" {
+		mail.VerifyCode = code
+	}
+	if mail.VerifyLink == "" {
+		mail.VerifyLink = link
+	}
+}
+
+// Extract 按“发件人域名规则 → 通用自定义规则 → 内置规则”的顺序提取验证码和链接
+func (e *CodeExtractor) Extract(userID int, from, subject, body string) (string, string) {
+	domain := senderDomain(from)
+	text := subject + "\n" + htmlToText(body)
+	links := extractLinks(body)
+
+	var code, link string
+	for _, rule := range e.rulesFor(userID, domain) {
+		if code == "" && rule.ruleType == RuleTypeCode {
+			code = matchRule(rule, text)
+		}
+		if link == "" && rule.ruleType == RuleTypeLink {
+			for _, candidate := range links {
+				if link = matchRule(rule, candidate); link != "" {
+					break
+				}
+			}
+		}
+		if code != "" && link != "" {
+			break
+		}
+	}
+
+	return code, link
+}
+
+// ValidatePattern 校验规则正则是否合法
+func ValidatePattern(pattern string) error {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("正则表达式无效: %v", err)
+	}
+	return nil
+}
+
+// Invalidate 清除用户规则缓存（规则变更后调用）
+func (e *CodeExtractor) Invalidate(userID int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.cache, userID)
+}
+
+// GetRules 获取用户的规则列表
+func (e *CodeExtractor) GetRules(userID int) ([]models.CodeRule, error) {
+	return e.ruleRepo.GetRulesByUserID(userID)
+}
+
+// CreateRule 创建规则
+func (e *CodeExtractor) CreateRule(userID int, req *models.CodeRuleRequest) (*models.CodeRule, error) {
+	if err := ValidatePattern(req.Pattern); err != nil {
+		return nil, err
+	}
+
+	rule := &models.CodeRule{
+		UserID:       userID,
+		SenderDomain: normalizeDomain(req.SenderDomain),
+		RuleType:     req.RuleType,
+		Pattern:      req.Pattern,
+		Priority:     req.Priority,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		Description:  req.Description,
+	}
+
+	created, err := e.ruleRepo.CreateRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Invalidate(userID)
+	return created, nil
+}
+
+// UpdateRule 更新规则
+func (e *CodeExtractor) UpdateRule(userID, ruleID int, req *models.CodeRuleRequest) (*models.CodeRule, error) {
+	rule, err := e.getOwnedRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePattern(req.Pattern); err != nil {
+		return nil, err
+	}
+
+	rule.SenderDomain = normalizeDomain(req.SenderDomain)
+	rule.RuleType = req.RuleType
+	rule.Pattern = req.Pattern
+	rule.Priority = req.Priority
+	rule.Description = req.Description
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	if err := e.ruleRepo.UpdateRule(rule); err != nil {
+		return nil, err
+	}
+
+	e.Invalidate(userID)
+	return e.ruleRepo.GetRuleByID(ruleID)
+}
+
+// DeleteRule 删除规则
+func (e *CodeExtractor) DeleteRule(userID, ruleID int) (*models.CodeRule, error) {
+	rule, err := e.getOwnedRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.ruleRepo.DeleteRule(ruleID); err != nil {
+		return nil, err
+	}
+
+	e.Invalidate(userID)
+	return rule, nil
+}
+
+// getOwnedRule 获取属于当前用户的规则
+func (e *CodeExtractor) getOwnedRule(userID, ruleID int) (*models.CodeRule, error) {
+	rule, err := e.ruleRepo.GetRuleByID(ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if rule.UserID != userID {
+		return nil, errors.New("无权访问此规则")
+	}
+	return rule, nil
+}
+
+// rulesFor 获取适用于发件人域名的有序规则列表
+func (e *CodeExtractor) rulesFor(userID int, domain string) []compiledRule {
+	userRules := e.loadUserRules(userID)
+
+	var domainRules, genericRules []compiledRule
+	for _, rule := range userRules {
+		if rule.senderDomain == "" {
+			genericRules = append(genericRules, rule)
+		} else if domainMatches(domain, rule.senderDomain) {
+			domainRules = append(domainRules, rule)
+		}
+	}
+
+	rules := make([]compiledRule, 0, len(domainRules)+len(genericRules)+len(builtinRules))
+	rules = append(rules, domainRules...)
+	rules = append(rules, genericRules...)
+	rules = append(rules, builtinRules...)
+	return rules
+}
+
+// loadUserRules 加载并缓存用户已启用的规则
+func (e *CodeExtractor) loadUserRules(userID int) []compiledRule {
+	e.mu.RLock()
+	rules, ok := e.cache[userID]
+	e.mu.RUnlock()
+	if ok {
+		return rules
+	}
+
+	stored, err := e.ruleRepo.GetRulesByUserID(userID)
+	if err != nil {
+		log.Printf("Code extractor: failed to load rules for user %d: %v", userID, err)
+		return nil
+	}
+
+	rules = make([]compiledRule, 0, len(stored))
+	for _, rule := range stored {
+		if !rule.Enabled {
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Code extractor: skip invalid rule %d: %v", rule.ID, err)
+			continue
+		}
+		rules = append(rules, compiledRule{
+			senderDomain: rule.SenderDomain,
+			ruleType:     rule.RuleType,
+			re:           re,
+		})
+	}
+
+	e.mu.Lock()
+	e.cache[userID] = rules
+	e.mu.Unlock()
+
+	return rules
+}
+
+// matchRule 使用规则匹配内容，有捕获组时返回第一个捕获组
+func matchRule(rule compiledRule, content string) string {
+	match := rule.re.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+
+	value := match[0]
+	if len(match) > 1 && match[1] != "" {
+		value = match[1]
+	}
+	value = strings.TrimSpace(value)
+
+	if rule.validate != nil && !rule.validate(value) {
+		return ""
+	}
+	return value
+}
+
+// htmlToText 将HTML正文转换为纯文本，纯文本正文原样返回
+func htmlToText(body string) string {
+	if !strings.Contains(body, "<") {
+		return body
+	}
+
+	text := scriptStylePattern.ReplaceAllString(body, " ")
+	text = blockTagPattern.ReplaceAllString(text, "\n")
+	text = tagPattern.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+	text = spacePattern.ReplaceAllString(text, " ")
+	return text
+}
+
+// extractLinks 提取正文中的链接（HTML href 和纯文本URL）
+func extractLinks(body string) []string {
+	var links []string
+	seen := make(map[string]bool)
+
+	add := func(link string) {
+		link = html.UnescapeString(strings.TrimSpace(link))
+		if link == "" || seen[link] {
+			return
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+
+	for _, match := range hrefPattern.FindAllStringSubmatch(body, -1) {
+		add(match[1])
+	}
+	for _, match := range bareURLPattern.FindAllString(body, -1) {
+		add(match)
+	}
+
+	return links
+}
+
+// senderDomain 从发件人中解析域名，支持“名称 <user@domain>”格式
+func senderDomain(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			from = from[start+1 : end]
+		}
+	}
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		from = from[at+1:]
+	}
+	return normalizeDomain(from)
+}
+
+// normalizeDomain 统一域名格式
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(domain), ".@"))
+}
+
+// domainMatches 判断发件人域名是否匹配规则域名（包含子域名）
+func domainMatches(domain, ruleDomain string) bool {
+	return domain == ruleDomain || strings.HasSuffix(domain, "."+ruleDomain)
+}
+
+// isMixedCode 字母数字混合验证码必须同时包含字母和数字，纯数字由前面的规则处理
+func isMixedCode(code string) bool {
+	return strings.ContainsAny(code, "0123456789") && strings.IndexFunc(code, unicode.IsLetter) >= 0
+}
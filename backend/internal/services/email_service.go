@@ -1,9 +1,15 @@
 package services
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"outlook-helper/backend/internal/config"
 	"outlook-helper/backend/internal/database"
@@ -13,23 +19,60 @@ import (
 // EmailService 邮件服务
 type EmailService struct {
 	emailRepo      *database.EmailRepository
+	tagRepo        *database.TagRepository
 	logRepo        *database.LogRepository
+	messageRepo    *database.MessageRepository
+	monitorRepo    *database.MonitorRepository
 	outlookService *OutlookService
+	extractor      *CodeExtractor
+	events         *EventHub
+	jobs           *JobManager
 	config         *config.Config
+
 }
 
-// NewEmailService 创建邮件服务
-func NewEmailService(db *database.DB, outlookService *OutlookService, cfg *config.Config) *EmailService {
-	return &EmailService{
+// NewEmailService 创建邮件服务并注册批量操作的后台任务
+func NewEmailService(db *database.DB, outlookService *OutlookService, extractor *CodeExtractor, events *EventHub, jobs *JobManager, cfg *config.Config) *EmailService {
+	s := &EmailService{
 		emailRepo:      db.Email,
+		tagRepo:        db.Tag,
 		logRepo:        db.Log,
+		messageRepo:    db.Message,
+		monitorRepo:    db.Monitor,
 		outlookService: outlookService,
+		extractor:      extractor,
+		events:         events,
+		jobs:           jobs,
 		config:         cfg,
 	}
+
+	// 上游轮换刷新令牌后立即保存，避免旧令牌过期导致账户失效
+	outlookService.OnTokenRotated(s.persistRefreshToken)
+
+	s.registerBatchJobs()
+
+	return s
+}
+
+// persistRefreshToken 保存轮换后的刷新令牌。数据库中的令牌已被修改（如用户更新了凭据或其他请求已轮换）时放弃保存
+func (s *EmailService) persistRefreshToken(email *models.Email, oldRefreshToken string) {
+	rotated, err := s.emailRepo.RotateRefreshToken(email.ID, oldRefreshToken, email.RefreshToken)
+	if err != nil {
+		log.Printf("Failed to persist rotated refresh token for email %d: %v", email.ID, err)
+		return
+	}
+	if !rotated {
+		log.Printf("Refresh token of email %d changed concurrently, skip rotation", email.ID)
+		return
+	}
+
+	s.logRepo.LogEmail(email.UserID, "refresh_token_rotated", email.ID,
+		fmt.Sprintf("刷新令牌已轮换，邮箱: %s", email.EmailAddress),
+		models.ClientInfo{UserAgent: "token_rotation"})
 }
 
 // AddEmail 添加邮箱
-func (s *EmailService) AddEmail(userID int, req *models.AddEmailRequest, ipAddress, userAgent string) (*models.Email, error) {
+func (s *EmailService) AddEmail(userID int, req *models.AddEmailRequest, client models.ClientInfo) (*models.Email, error) {
 	// 检查邮箱是否已存在
 	exists, err := s.emailRepo.EmailExists(userID, req.EmailAddress)
 	if err != nil {
@@ -47,22 +90,24 @@ func (s *EmailService) AddEmail(userID int, req *models.AddEmailRequest, ipAddre
 		ClientID:     req.ClientID,
 		RefreshToken: req.RefreshToken,
 		Remark:       req.Remark,
+		Provider:     req.Provider,
 	}
 
 	// 验证邮箱凭据（如果配置允许跳过验证则跳过）
 	if !s.config.SkipEmailValidation {
-		if err := s.outlookService.ValidateEmailCredentials(email); err != nil {
+		if err := s.outlookService.ValidateEmailCredentials(context.Background(), email); err != nil {
 			// 记录验证失败日志，包含详细错误信息
 			s.logRepo.LogEmail(userID, "email_validation_failed", 0,
 				fmt.Sprintf("邮箱 %s 凭据验证失败: %v", req.EmailAddress, err),
-				ipAddress, userAgent)
+				client)
 			return nil, err // 直接返回详细的错误信息
 		}
+		markEmailChecked(email)
 	} else {
 		// 记录跳过验证的日志
 		s.logRepo.LogEmail(userID, "email_validation_skipped", 0,
 			fmt.Sprintf("邮箱 %s 跳过凭据验证（调试模式）", req.EmailAddress),
-			ipAddress, userAgent)
+			client)
 	}
 
 	// 保存到数据库
@@ -74,22 +119,17 @@ func (s *EmailService) AddEmail(userID int, req *models.AddEmailRequest, ipAddre
 	// 记录添加成功日志
 	s.logRepo.LogEmail(userID, "email_added", savedEmail.ID,
 		fmt.Sprintf("添加邮箱: %s", req.EmailAddress),
-		ipAddress, userAgent)
+		client)
 
 	return savedEmail, nil
 }
 
 // BatchAddEmails 批量添加邮箱 - 并发验证和批量处理
-func (s *EmailService) BatchAddEmails(userID int, req *models.BatchAddEmailRequest, ipAddress, userAgent string) ([]models.Email, []string, error) {
+func (s *EmailService) BatchAddEmails(userID int, req *models.BatchAddEmailRequest, client models.ClientInfo) ([]models.Email, []string, error) {
 	if len(req.Emails) == 0 {
 		return []models.Email{}, []string{}, nil
 	}
 
-	// 限制最大数量为30
-	if len(req.Emails) > 30 {
-		return nil, nil, errors.New("批量添加邮箱数量不能超过30个")
-	}
-
 	// 使用worker pool控制并发度，避免对API造成过大压力
 	maxWorkers := s.config.EmailValidationWorkers
 	if maxWorkers <= 0 {
@@ -137,16 +177,18 @@ func (s *EmailService) BatchAddEmails(userID int, req *models.BatchAddEmailReque
 					ClientID:     task.req.ClientID,
 					RefreshToken: task.req.RefreshToken,
 					Remark:       task.req.Remark,
+					Provider:     task.req.Provider,
 				}
 
 				// 验证邮箱凭据（如果配置允许跳过验证则跳过）
 				if !s.config.SkipEmailValidation {
-					if err := s.outlookService.ValidateEmailCredentials(email); err != nil {
+					if err := s.outlookService.ValidateEmailCredentials(context.Background(), email); err != nil {
 						// 提供更详细的错误信息，包含具体的API响应
 						result.error = fmt.Sprintf("邮箱 %s: %v", task.req.EmailAddress, err)
 						resultChan <- result
 						continue
 					}
+					markEmailChecked(email)
 				}
 
 				result.email = email
@@ -166,9 +208,17 @@ func (s *EmailService) BatchAddEmails(userID int, req *models.BatchAddEmailReque
 	var errors []string
 	results := make([]emailResult, len(req.Emails))
 
+	var validCount, failedCount int
 	for i := 0; i < len(req.Emails); i++ {
 		result := <-resultChan
 		results[result.index] = result
+
+		if result.error != "" {
+			failedCount++
+		} else {
+			validCount++
+		}
+		s.publishProgress(userID, "batch_add_emails", len(req.Emails), validCount, failedCount)
 	}
 
 	// 按原始顺序处理结果
@@ -193,26 +243,26 @@ func (s *EmailService) BatchAddEmails(userID int, req *models.BatchAddEmailReque
 		for _, email := range savedEmails {
 			s.logRepo.LogEmail(userID, "email_added", email.ID,
 				fmt.Sprintf("添加邮箱: %s", email.EmailAddress),
-				ipAddress, userAgent)
+				client)
 		}
 	}
 
 	// 记录批量添加日志
 	s.logRepo.LogEmail(userID, "batch_add_emails", 0,
 		fmt.Sprintf("批量添加邮箱，成功: %d, 失败: %d", len(successEmails), len(errors)),
-		ipAddress, userAgent)
+		client)
 
 	return successEmails, errors, nil
 }
 
-// GetUserEmails 获取用户邮箱列表
+// GetUserEmails 获取用户可查看的邮箱列表（自己的邮箱和团队共享的邮箱）
 func (s *EmailService) GetUserEmails(userID int, limit, offset int) ([]models.Email, error) {
-	return s.emailRepo.GetEmailsByUserID(userID, limit, offset)
+	return s.emailRepo.GetReadableEmails(userID, limit, offset)
 }
 
-// SearchEmails 搜索邮箱
-func (s *EmailService) SearchEmails(userID int, keyword string, limit, offset int) ([]models.Email, error) {
-	return s.emailRepo.SearchEmails(userID, keyword, limit, offset)
+// SearchEmails 在用户可查看的邮箱中搜索，status为空时不按账户状态过滤
+func (s *EmailService) SearchEmails(userID int, keyword, status string, limit, offset int) ([]models.Email, error) {
+	return s.emailRepo.SearchEmails(userID, keyword, status, limit, offset)
 }
 
 // GetEmailByID 根据ID获取邮箱
@@ -230,13 +280,33 @@ func (s *EmailService) GetEmailByID(userID, emailID int) (*models.Email, error)
 	return email, nil
 }
 
+// GetReadableEmail 获取用户可查看的邮箱：自己的邮箱，或所有者设置为共享的其他用户邮箱。
+// 只用于读取邮件，修改邮箱和清空邮件仍使用 GetEmailByID 校验所有者
+func (s *EmailService) GetReadableEmail(userID, emailID int) (*models.Email, error) {
+	email, err := s.emailRepo.GetEmailByID(emailID)
+	if err != nil {
+		return nil, err
+	}
+	if email.UserID == userID {
+		return email, nil
+	}
+	if !email.Shared {
+		return nil, errors.New("无权访问此邮箱")
+	}
+
+	// 其他用户的私有标记不对外展示
+	email.Tags = database.VisibleEmailTags(email.Tags, email, userID)
+	return email, nil
+}
+
 // UpdateEmail 更新邮箱
-func (s *EmailService) UpdateEmail(userID int, emailID int, req *models.AddEmailRequest, ipAddress, userAgent string) (*models.Email, error) {
+func (s *EmailService) UpdateEmail(userID int, emailID int, req *models.AddEmailRequest, client models.ClientInfo) (*models.Email, error) {
 	// 获取现有邮箱
 	email, err := s.GetEmailByID(userID, emailID)
 	if err != nil {
 		return nil, err
 	}
+	previous := *email
 
 	// 更新字段
 	email.EmailAddress = req.EmailAddress
@@ -244,10 +314,11 @@ func (s *EmailService) UpdateEmail(userID int, emailID int, req *models.AddEmail
 	email.ClientID = req.ClientID
 	email.RefreshToken = req.RefreshToken
 	email.Remark = req.Remark
+	email.Provider = req.Provider
 
 	// 验证新的凭据
-	if err := s.outlookService.ValidateEmailCredentials(email); err != nil {
-		return nil, fmt.Errorf("邮箱凭据验证失败: %v", err)
+	if err := s.outlookService.ValidateEmailCredentials(context.Background(), email); err != nil {
+		return nil, fmt.Errorf("邮箱凭据验证失败: %w", err)
 	}
 
 	// 更新数据库
@@ -255,16 +326,41 @@ func (s *EmailService) UpdateEmail(userID int, emailID int, req *models.AddEmail
 		return nil, err
 	}
 
+	// 旧凭据换取的访问令牌不再使用
+	if previous.ClientID != email.ClientID || previous.RefreshToken != email.RefreshToken {
+		s.outlookService.ForgetAccessToken(&previous)
+	}
+
 	// 记录更新日志
 	s.logRepo.LogEmail(userID, "email_updated", emailID,
 		fmt.Sprintf("更新邮箱: %s", req.EmailAddress),
-		ipAddress, userAgent)
+		client)
 
 	return email, nil
 }
 
+// SetEmailShared 设置邮箱是否共享给其他用户查看，只有所有者可以设置
+func (s *EmailService) SetEmailShared(userID, emailID int, shared bool, client models.ClientInfo) error {
+	email, err := s.GetEmailByID(userID, emailID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.emailRepo.SetEmailShared(emailID, shared); err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("取消共享邮箱: %s", email.EmailAddress)
+	if shared {
+		details = fmt.Sprintf("共享邮箱: %s", email.EmailAddress)
+	}
+	s.logRepo.LogEmail(userID, "email_shared", emailID, details, client)
+
+	return nil
+}
+
 // DeleteEmail 删除邮箱
-func (s *EmailService) DeleteEmail(userID, emailID int, ipAddress, userAgent string) error {
+func (s *EmailService) DeleteEmail(userID, emailID int, client models.ClientInfo) error {
 	// 检查邮箱是否存在且属于当前用户
 	email, err := s.GetEmailByID(userID, emailID)
 	if err != nil {
@@ -279,13 +375,13 @@ func (s *EmailService) DeleteEmail(userID, emailID int, ipAddress, userAgent str
 	// 记录删除日志
 	s.logRepo.LogEmail(userID, "email_deleted", emailID,
 		fmt.Sprintf("删除邮箱: %s", email.EmailAddress),
-		ipAddress, userAgent)
+		client)
 
 	return nil
 }
 
 // BatchDeleteEmails 批量删除邮箱
-func (s *EmailService) BatchDeleteEmails(userID int, emailIDs []int, ipAddress, userAgent string) error {
+func (s *EmailService) BatchDeleteEmails(userID int, emailIDs []int, client models.ClientInfo) error {
 	if len(emailIDs) == 0 {
 		return nil
 	}
@@ -306,71 +402,118 @@ func (s *EmailService) BatchDeleteEmails(userID int, emailIDs []int, ipAddress,
 	// 记录批量删除日志
 	s.logRepo.LogEmail(userID, "batch_delete_emails", 0,
 		fmt.Sprintf("批量删除邮箱，数量: %d", len(emailIDs)),
-		ipAddress, userAgent)
+		client)
 
 	return nil
 }
 
 // GetLatestMail 获取最新邮件
-func (s *EmailService) GetLatestMail(userID, emailID int, mailbox string, ipAddress, userAgent string) (*models.OutlookMail, error) {
+func (s *EmailService) GetLatestMail(userID, emailID int, mailbox string, client models.ClientInfo) (*models.OutlookMail, error) {
 	// 获取邮箱信息
-	email, err := s.GetEmailByID(userID, emailID)
+	email, err := s.GetReadableEmail(userID, emailID)
 	if err != nil {
 		return nil, err
 	}
 
 	// 调用Outlook API
-	mail, err := s.outlookService.GetLatestMail(email, mailbox, "json")
+	mail, err := s.outlookService.GetLatestMail(context.Background(), email, mailbox, "json")
 	if err != nil {
 		// 记录操作失败日志
 		s.logRepo.LogEmail(userID, "get_latest_mail_failed", emailID,
 			fmt.Sprintf("获取最新邮件失败: %v", err),
-			ipAddress, userAgent)
+			client)
 		return nil, err
 	}
 
+	// 上游未返回验证码时本地提取
+	s.extractor.Enrich(userID, mail)
+
+	// 保存到本地邮件存储
+	inserted := s.storeMessages(emailID, mailbox, []models.OutlookMail{*mail})
+
 	// 更新最后操作时间
 	s.emailRepo.UpdateLastOperation(emailID)
 
 	// 记录操作成功日志
 	s.logRepo.LogEmail(userID, "get_latest_mail", emailID,
 		fmt.Sprintf("获取最新邮件成功，邮箱: %s", email.EmailAddress),
-		ipAddress, userAgent)
+		client)
+
+	// 只有之前未见过的邮件才推送新邮件事件
+	if inserted > 0 && s.markMonitorSeen(emailID, mailbox, mail) {
+		s.events.PublishMail(NewMailEvent{
+			UserID:       userID,
+			EmailID:      emailID,
+			EmailAddress: email.EmailAddress,
+			Mail:         mail,
+			DetectedAt:   time.Now(),
+		})
+	}
 
 	return mail, nil
 }
 
+// markMonitorSeen 手动获取到收件箱新邮件时同步邮箱监控的最新邮件ID，避免轮询时重复推送。
+// 监控已记录过该邮件时返回false
+func (s *EmailService) markMonitorSeen(emailID int, mailbox string, mail *models.OutlookMail) bool {
+	if mail.ID == "" || !strings.EqualFold(mailbox, "INBOX") {
+		return true
+	}
+
+	monitor, err := s.monitorRepo.GetMonitorByEmailID(emailID)
+	if err != nil {
+		// 未开启监控
+		return true
+	}
+	if monitor.LastMailID == mail.ID {
+		return false
+	}
+
+	if err := s.monitorRepo.SetLastMailID(emailID, mail.ID); err != nil {
+		log.Printf("Failed to update monitor for email %d: %v", emailID, err)
+	}
+	return true
+}
+
 // GetAllMails 获取全部邮件
-func (s *EmailService) GetAllMails(userID, emailID int, mailbox string, ipAddress, userAgent string) ([]models.OutlookMail, error) {
+func (s *EmailService) GetAllMails(userID, emailID int, mailbox string, client models.ClientInfo) ([]models.OutlookMail, error) {
 	// 获取邮箱信息
-	email, err := s.GetEmailByID(userID, emailID)
+	email, err := s.GetReadableEmail(userID, emailID)
 	if err != nil {
 		return nil, err
 	}
 
 	// 调用Outlook API
-	mails, err := s.outlookService.GetAllMails(email, mailbox)
+	mails, err := s.outlookService.GetAllMails(context.Background(), email, mailbox)
 	if err != nil {
 		// 记录操作失败日志
 		s.logRepo.LogEmail(userID, "get_all_mails_failed", emailID,
 			fmt.Sprintf("获取全部邮件失败: %v", err),
-			ipAddress, userAgent)
+			client)
 		return nil, err
 	}
 
+	// 上游未返回验证码时本地提取
+	for i := range mails {
+		s.extractor.Enrich(userID, &mails[i])
+	}
+
+	// 保存到本地邮件存储
+	s.storeMessages(emailID, mailbox, mails)
+
 	// 更新最后操作时间
 	s.emailRepo.UpdateLastOperation(emailID)
 
 	// 记录操作成功日志
 	s.logRepo.LogEmail(userID, "get_all_mails", emailID,
 		fmt.Sprintf("获取全部邮件成功，邮箱: %s，邮件数量: %d", email.EmailAddress, len(mails)),
-		ipAddress, userAgent)
+		client)
 
 	return mails, nil
 }
 
 // ClearInbox 清空收件箱
-func (s *EmailService) ClearInbox(userID, emailID int, ipAddress, userAgent string) error {
+func (s *EmailService) ClearInbox(userID, emailID int, client models.ClientInfo) error {
 	// 获取邮箱信息
 	email, err := s.GetEmailByID(userID, emailID)
 	if err != nil {
@@ -378,11 +521,11 @@ func (s *EmailService) ClearInbox(userID, emailID int, ipAddress, userAgent stri
 	}
 
 	// 调用Outlook API
-	if err := s.outlookService.ClearInbox(email); err != nil {
+	if err := s.outlookService.ClearInbox(context.Background(), email); err != nil {
 		// 记录操作失败日志
 		s.logRepo.LogEmail(userID, "clear_inbox_failed", emailID,
 			fmt.Sprintf("清空收件箱失败: %v", err),
-			ipAddress, userAgent)
+			client)
 		return err
 	}
 
@@ -392,70 +535,287 @@ func (s *EmailService) ClearInbox(userID, emailID int, ipAddress, userAgent stri
 	// 记录操作成功日志
 	s.logRepo.LogEmail(userID, "clear_inbox", emailID,
 		fmt.Sprintf("清空收件箱成功，邮箱: %s", email.EmailAddress),
-		ipAddress, userAgent)
+		client)
 
 	return nil
 }
 
-// BatchClearInbox 批量清空收件箱
-func (s *EmailService) BatchClearInbox(userID int, emailIDs []int, ipAddress, userAgent string) (int, []string, error) {
-	if len(emailIDs) == 0 {
-		return 0, []string{}, nil
+// BatchClearInbox 并发批量清空收件箱，返回按请求顺序排列的逐个结果。
+// ctx 结束（如客户端断开）后不再开始新的邮箱，未开始的邮箱标记为已取消并返回 ctx.Err()
+func (s *EmailService) BatchClearInbox(ctx context.Context, userID int, emailIDs []int, client models.ClientInfo) ([]models.JobItem, error) {
+	return s.batchClear(ctx, userID, emailIDs, false, client)
+}
+
+// ClearJunk 清空垃圾箱
+func (s *EmailService) ClearJunk(userID, emailID int, client models.ClientInfo) error {
+	// 获取邮箱信息
+	email, err := s.GetEmailByID(userID, emailID)
+	if err != nil {
+		return err
 	}
 
-	var successCount int
-	var errors []string
+	// 调用Outlook API
+	if err := s.outlookService.ClearJunk(context.Background(), email); err != nil {
+		// 记录操作失败日志
+		s.logRepo.LogEmail(userID, "clear_junk_failed", emailID,
+			fmt.Sprintf("清空垃圾箱失败: %v", err),
+			client)
+		return err
+	}
 
-	// 逐个清空收件箱
-	for _, emailID := range emailIDs {
-		// 验证邮箱是否属于当前用户
-		email, err := s.GetEmailByID(userID, emailID)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("邮箱ID %d: %v", emailID, err))
-			continue
+	// 更新最后操作时间
+	s.emailRepo.UpdateLastOperation(emailID)
+
+	// 记录操作成功日志
+	s.logRepo.LogEmail(userID, "clear_junk", emailID,
+		fmt.Sprintf("清空垃圾箱成功，邮箱: %s", email.EmailAddress),
+		client)
+
+	return nil
+}
+
+// BatchClearJunk 并发批量清空垃圾箱，结果和取消行为与 BatchClearInbox 相同
+func (s *EmailService) BatchClearJunk(ctx context.Context, userID int, emailIDs []int, client models.ClientInfo) ([]models.JobItem, error) {
+	return s.batchClear(ctx, userID, emailIDs, true, client)
+}
+
+// batchClear 并发清空收件箱（junk 为true时清空垃圾箱），推送进度并记录批量操作日志
+func (s *EmailService) batchClear(ctx context.Context, userID int, emailIDs []int, junk bool, client models.ClientInfo) ([]models.JobItem, error) {
+	operation, label := "batch_clear_inbox", "收件箱"
+	if junk {
+		operation, label = "batch_clear_junk", "垃圾箱"
+	}
+
+	// 重复的邮箱只清空一次，避免同一邮箱被并发清空
+	emailIDs = uniqueEmailIDs(emailIDs)
+	tasks := make([]clearTask, len(emailIDs))
+	for i, emailID := range emailIDs {
+		tasks[i] = clearTask{index: i + 1, emailID: emailID}
+	}
+
+	results := make([]models.JobItem, len(emailIDs))
+	var mu sync.Mutex
+	var successCount, failedCount int
+	s.clearMailboxes(ctx, userID, tasks, junk, client, func(item models.JobItem) {
+		mu.Lock()
+		results[item.Index-1] = item
+		if item.Success {
+			successCount++
+		} else {
+			failedCount++
 		}
+		success, failed := successCount, failedCount
+		mu.Unlock()
+
+		s.publishProgress(userID, operation, len(emailIDs), success, failed)
+	})
+
+	// 请求取消后未开始或被中断的邮箱
+	var cancelled int
+	for i := range results {
+		if results[i].Index == 0 {
+			results[i] = models.JobItem{Index: i + 1, EmailID: emailIDs[i], Status: "cancelled", Error: "请求已取消"}
+			cancelled++
+		}
+	}
 
-		// 调用Outlook API清空收件箱
-		if err := s.outlookService.ClearInbox(email); err != nil {
-			// 记录操作失败日志
-			s.logRepo.LogEmail(userID, "clear_inbox_failed", emailID,
-				fmt.Sprintf("批量清空收件箱失败: %v", err),
-				ipAddress, userAgent)
-			errors = append(errors, fmt.Sprintf("邮箱 %s: %v", email.EmailAddress, err))
-			continue
+	// 记录批量操作日志
+	description := fmt.Sprintf("批量清空%s，成功: %d, 失败: %d", label, successCount, failedCount)
+	if cancelled > 0 {
+		description += fmt.Sprintf(", 已取消: %d", cancelled)
+	}
+	s.logRepo.LogEmail(userID, operation, 0, description, client)
+
+	if cancelled > 0 {
+		return results, ctx.Err()
+	}
+	return results, nil
+}
+
+// clearTask 待清空的邮箱，index 为结果序号（从1开始）
+type clearTask struct {
+	index   int
+	emailID int
+}
+
+// clearMailboxes 使用有界worker pool并发清空邮箱，每个邮箱处理完后调用 done（可能并发调用）。
+// ctx 结束后不再开始新的邮箱并取消进行中的清空，被中断的邮箱不调用 done，返回时所有worker都已退出
+func (s *EmailService) clearMailboxes(ctx context.Context, userID int, tasks []clearTask, junk bool, client models.ClientInfo, done func(models.JobItem)) {
+	maxWorkers := s.config.EmailValidationWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = 5 // 默认并发数
+	}
+
+	taskChan := make(chan clearTask)
+	var wg sync.WaitGroup
+
+	// 启动worker goroutines
+	for w := 0; w < maxWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				if item, ok := s.clearMailbox(ctx, userID, task.emailID, junk, client); ok {
+					item.Index = task.index
+					done(item)
+				}
+			}
+		}()
+	}
+
+	// 发送任务到worker pool，ctx 结束时不再发送
+send:
+	for _, task := range tasks {
+		select {
+		case taskChan <- task:
+		case <-ctx.Done():
+			break send
 		}
+	}
+	close(taskChan)
+	wg.Wait()
+}
+
+// clearMailbox 清空单个邮箱的收件箱（junk 为true时清空垃圾箱）并记录操作日志，返回的结果不含序号。
+// ctx 结束导致清空中断时返回false
+func (s *EmailService) clearMailbox(ctx context.Context, userID, emailID int, junk bool, client models.ClientInfo) (models.JobItem, bool) {
+	operation, label, clear := "clear_inbox", "收件箱", s.outlookService.ClearInbox
+	if junk {
+		operation, label, clear = "clear_junk", "垃圾箱", s.outlookService.ClearJunk
+	}
 
-		// 更新最后操作时间
-		s.emailRepo.UpdateLastOperation(emailID)
+	item := models.JobItem{EmailID: emailID, Status: "failed"}
 
-		// 记录操作成功日志
-		s.logRepo.LogEmail(userID, "clear_inbox", emailID,
-			fmt.Sprintf("批量清空收件箱成功，邮箱: %s", email.EmailAddress),
-			ipAddress, userAgent)
+	// 验证邮箱是否属于当前用户
+	email, err := s.GetEmailByID(userID, emailID)
+	if err != nil {
+		item.Error = "邮箱不存在或无权访问"
+		return item, true
+	}
+	item.EmailAddress = email.EmailAddress
 
-		successCount++
+	start := time.Now()
+	err = clear(ctx, email)
+	item.DurationMs = time.Since(start).Milliseconds()
+	if isCanceled(err) {
+		return item, false
+	}
+	if err != nil {
+		// 记录操作失败日志
+		s.logRepo.LogEmail(userID, operation+"_failed", emailID,
+			fmt.Sprintf("批量清空%s失败: %v", label, err),
+			client)
+		item.Error = truncateError(err.Error())
+		return item, true
 	}
 
-	// 记录批量操作日志
-	s.logRepo.LogEmail(userID, "batch_clear_inbox", 0,
-		fmt.Sprintf("批量清空收件箱，成功: %d, 失败: %d", successCount, len(errors)),
-		ipAddress, userAgent)
+	// 更新最后操作时间
+	s.emailRepo.UpdateLastOperation(emailID)
+
+	// 记录操作成功日志
+	s.logRepo.LogEmail(userID, operation, emailID,
+		fmt.Sprintf("批量清空%s成功，邮箱: %s", label, email.EmailAddress),
+		client)
+
+	item.Status = "cleared"
+	item.Success = true
+	return item, true
+}
+
+// GetStoredMessages 获取本地存储的邮件（分页，不请求Outlook API）
+func (s *EmailService) GetStoredMessages(userID, emailID int, mailbox string, limit, offset int) ([]models.StoredMessage, int, error) {
+	// 检查邮箱是否属于当前用户
+	if _, err := s.GetReadableEmail(userID, emailID); err != nil {
+		return nil, 0, err
+	}
+
+	messages, err := s.messageRepo.GetMessagesByEmailID(emailID, mailbox, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.messageRepo.CountMessagesByEmailID(emailID, mailbox)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, total, nil
+}
+
+// SearchMessages 全文搜索用户所有邮箱的本地邮件
+func (s *EmailService) SearchMessages(userID int, keyword string, tagID int, since time.Time, limit, offset int) ([]models.MessageSearchResult, int, error) {
+	if strings.TrimSpace(keyword) == "" {
+		return nil, 0, errors.New("搜索关键词不能为空")
+	}
+	return s.messageRepo.SearchMessages(userID, keyword, tagID, since, limit, offset)
+}
+
+// storeMessages 将获取到的邮件保存到本地，返回新保存的邮件数量，保存失败不影响主流程
+func (s *EmailService) storeMessages(emailID int, mailbox string, mails []models.OutlookMail) int {
+	messages := make([]models.StoredMessage, 0, len(mails))
+	for _, mail := range mails {
+		messages = append(messages, models.StoredMessage{
+			EmailID:    emailID,
+			MessageID:  messageKey(mail),
+			Mailbox:    mailbox,
+			Subject:    mail.Subject,
+			From:       mail.From,
+			To:         mail.To,
+			Body:       mail.Body,
+			BodyText:   htmlToText(mail.Body),
+			IsRead:     mail.IsRead,
+			VerifyCode: mail.VerifyCode,
+			VerifyLink: mail.VerifyLink,
+			ReceivedAt: mail.ReceivedAt,
+		})
+	}
+
+	inserted, err := s.messageRepo.SaveMessages(messages)
+	if err != nil {
+		log.Printf("Failed to store messages for email %d: %v", emailID, err)
+	}
+	return inserted
+}
+
+// messageKey 获取邮件去重键，上游未返回ID时使用主题、发件人和时间的哈希
+func messageKey(mail models.OutlookMail) string {
+	if mail.ID != "" {
+		return mail.ID
+	}
+	sum := sha1.Sum([]byte(mail.Subject + "\x00" + mail.From + "\x00" + mail.ReceivedAt.UTC().Format(time.RFC3339Nano)))
+	return "local-" + hex.EncodeToString(sum[:])
+}
+
+// markEmailChecked 添加前凭据验证通过，将账户状态初始化为正常
+func markEmailChecked(email *models.Email) {
+	now := time.Now()
+	email.Status = models.EmailStatusActive
+	email.LastCheckedAt = &now
+}
 
-	return successCount, errors, nil
+// publishProgress 推送批量操作进度事件
+func (s *EmailService) publishProgress(userID int, operation string, total, success, failed int) {
+	s.events.Publish(userID, EventBatchProgress, BatchProgressEvent{
+		Operation: operation,
+		Total:     total,
+		Done:      success + failed,
+		Success:   success,
+		Failed:    failed,
+	})
 }
 
-// CountUserEmails 统计用户邮箱数量
+// CountUserEmails 统计用户可查看的邮箱数量
 func (s *EmailService) CountUserEmails(userID int) (int, error) {
-	return s.emailRepo.CountEmailsByUserID(userID)
+	return s.emailRepo.CountReadableEmails(userID)
 }
 
 // CountSearchEmails 统计搜索结果数量
-func (s *EmailService) CountSearchEmails(userID int, keyword string) (int, error) {
-	return s.emailRepo.CountSearchEmails(userID, keyword)
+func (s *EmailService) CountSearchEmails(userID int, keyword, status string) (int, error) {
+	return s.emailRepo.CountSearchEmails(userID, keyword, status)
 }
 
 // ExportEmails 导出邮箱数据
-func (s *EmailService) ExportEmails(userID int, req *models.ExportEmailRequest, ipAddress, userAgent string) (*models.ExportEmailResponse, error) {
+func (s *EmailService) ExportEmails(userID int, req *models.ExportEmailRequest, client models.ClientInfo) (*models.ExportEmailResponse, error) {
 	var emails []models.Email
 	var err error
 
@@ -494,7 +854,7 @@ func (s *EmailService) ExportEmails(userID int, req *models.ExportEmailRequest,
 	s.logRepo.LogEmail(userID, "export_emails", 0,
 		fmt.Sprintf("导出邮箱数据，范围: %s，格式: %s，字段顺序: [%s]，数量: %d",
 			req.Range, req.Format, strings.Join(fieldNames, ", "), len(emails)),
-		ipAddress, userAgent)
+		client)
 
 	response := &models.ExportEmailResponse{
 		Content: content,
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventMailArrived   = "mail_arrived"   // 新邮件到达
+	EventVerifyCode    = "verify_code"    // 提取到验证码
+	EventBatchProgress = "batch_progress" // 批量操作进度
+	EventJobStatus     = "job_status"     // 后台任务状态变化
+)
+
+// Event 推送给前端的实时事件
+type Event struct {
+	Type   string      `json:"type"`
+	UserID int         `json:"-"`
+	Data   interface{} `json:"data"`
+	Time   time.Time   `json:"time"`
+}
+
+// VerifyCodeEvent 验证码事件数据
+type VerifyCodeEvent struct {
+	EmailID      int    `json:"email_id"`
+	EmailAddress string `json:"email_address"`
+	MailID       string `json:"mail_id"`
+	Subject      string `json:"subject"`
+	From         string `json:"from"`
+	VerifyCode   string `json:"verify_code"`
+}
+
+// BatchProgressEvent 批量操作进度事件数据
+type BatchProgressEvent struct {
+	JobID     string `json:"job_id,omitempty"` // 后台任务ID，同步执行的批量操作为空
+	Operation string `json:"operation"`
+	Total     int    `json:"total"`
+	Done      int    `json:"done"`
+	Success   int    `json:"success"`
+	Failed    int    `json:"failed"`
+}
+
+// subscriberBuffer 每个订阅者的事件缓冲大小，缓冲满时丢弃新事件，避免慢连接阻塞发布方
+const subscriberBuffer = 64
+
+// EventHub 实时事件分发中心，支持同一用户多个连接同时订阅
+type EventHub struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]map[int]chan Event // userID -> subscriberID -> channel
+}
+
+// NewEventHub 创建事件分发中心
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[int]map[int]chan Event),
+	}
+}
+
+// Subscribe 订阅用户事件，返回事件通道和取消订阅函数
+func (h *EventHub) Subscribe(userID int) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	id := h.nextID
+	ch := make(chan Event, subscriberBuffer)
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[int]chan Event)
+	}
+	h.subscribers[userID][id] = ch
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(h.subscribers[userID], id)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish 向用户的所有订阅者发布事件
+func (h *EventHub) Publish(userID int, eventType string, data interface{}) {
+	if h == nil {
+		return
+	}
+
+	event := Event{
+		Type:   eventType,
+		UserID: userID,
+		Data:   data,
+		Time:   time.Now(),
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, ch := range h.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+			// 订阅者处理过慢，丢弃该事件
+		}
+	}
+}
+
+// SubscriberCount 获取当前订阅连接数
+func (h *EventHub) SubscriberCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := 0
+	for _, subs := range h.subscribers {
+		count += len(subs)
+	}
+	return count
+}
+
+// PublishMail 发布邮件到达事件，邮件带有验证码时同时发布验证码事件
+func (h *EventHub) PublishMail(event NewMailEvent) {
+	if h == nil || event.Mail == nil {
+		return
+	}
+
+	h.Publish(event.UserID, EventMailArrived, event)
+
+	if event.Mail.VerifyCode != "" {
+		h.Publish(event.UserID, EventVerifyCode, VerifyCodeEvent{
+			EmailID:      event.EmailID,
+			EmailAddress: event.EmailAddress,
+			MailID:       event.Mail.ID,
+			Subject:      event.Mail.Subject,
+			From:         event.Mail.From,
+			VerifyCode:   event.Mail.VerifyCode,
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/models"
+)
+
+// Graph 后端参数
+const (
+	graphPageSize        = 50              // 每页获取的邮件数量
+	graphMaxMails        = 200             // 获取全部邮件时的数量上限
+	graphMaxClearRounds  = 20              // 清空文件夹时最多删除的页数
+	graphTokenExpirySkew = 2 * time.Minute // 访问令牌提前过期的余量
+)
+
+// 获取邮件时请求的字段
+const graphMessageFields = "id,subject,from,toRecipients,body,isRead,receivedDateTime"
+
+// graphProvider 直接使用 OAuth2 刷新令牌换取访问令牌，通过 Microsoft Graph 读取邮件
+type graphProvider struct {
+	tokenURL   string
+	graphURL   string
+	scope      string
+	httpClient *http.Client
+	retry      RetryPolicy
+	breaker    *CircuitBreaker
+
+	mu     sync.Mutex
+	tokens map[string]graphAccessToken
+
+	// 令牌端点返回新的刷新令牌时回调
+	onTokenRotated func(email *models.Email, refreshToken string)
+}
+
+// graphAccessToken 缓存的访问令牌
+type graphAccessToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// graphTokenResponse 令牌端点响应
+type graphTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// graphEmailAddress Graph 邮件地址
+type graphEmailAddress struct {
+	EmailAddress struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+// graphMessage Graph 邮件
+type graphMessage struct {
+	ID           string              `json:"id"`
+	Subject      string              `json:"subject"`
+	From         graphEmailAddress   `json:"from"`
+	ToRecipients []graphEmailAddress `json:"toRecipients"`
+	Body         struct {
+		ContentType string `json:"contentType"`
+		Content     string `json:"content"`
+	} `json:"body"`
+	IsRead           bool      `json:"isRead"`
+	ReceivedDateTime time.Time `json:"receivedDateTime"`
+}
+
+// graphMessageList Graph 邮件列表
+type graphMessageList struct {
+	Value    []graphMessage `json:"value"`
+	NextLink string         `json:"@odata.nextLink"`
+}
+
+// newGraphProvider 创建 Graph 邮件后端
+func newGraphProvider(cfg *config.Config) *graphProvider {
+	return &graphProvider{
+		tokenURL: cfg.MailTokenURL,
+		graphURL: strings.TrimRight(cfg.MailGraphBaseURL, "/"),
+		scope:    cfg.MailGraphScope,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		retry: NewRetryPolicy(cfg),
+		breaker: NewCircuitBreaker(models.MailProviderGraph, cfg.OutlookBreakerThreshold,
+			time.Duration(cfg.OutlookBreakerCooldown)*time.Second),
+		tokens: make(map[string]graphAccessToken),
+	}
+}
+
+// Name 后端名称
+func (p *graphProvider) Name() string {
+	return models.MailProviderGraph
+}
+
+// GetLatestMail 获取最新邮件
+func (p *graphProvider) GetLatestMail(ctx context.Context, email *models.Email, mailbox string, responseType string) (*models.OutlookMail, error) {
+	query := url.Values{}
+	query.Set("$top", "1")
+	query.Set("$orderby", "receivedDateTime desc")
+	query.Set("$select", graphMessageFields)
+
+	var list graphMessageList
+	if err := p.getJSON(ctx, email, p.folderURL(mailbox)+"?"+query.Encode(), &list); err != nil {
+		return nil, err
+	}
+	if len(list.Value) == 0 {
+		return nil, ErrNoMail
+	}
+
+	mail := list.Value[0].toOutlookMail()
+	return &mail, nil
+}
+
+// GetAllMails 获取全部邮件，最多返回 graphMaxMails 封
+func (p *graphProvider) GetAllMails(ctx context.Context, email *models.Email, mailbox string) ([]models.OutlookMail, error) {
+	query := url.Values{}
+	query.Set("$top", fmt.Sprintf("%d", graphPageSize))
+	query.Set("$orderby", "receivedDateTime desc")
+	query.Set("$select", graphMessageFields)
+
+	var mails []models.OutlookMail
+	next := p.folderURL(mailbox) + "?" + query.Encode()
+	for next != "" && len(mails) < graphMaxMails {
+		var list graphMessageList
+		if err := p.getJSON(ctx, email, next, &list); err != nil {
+			return nil, err
+		}
+		for _, message := range list.Value {
+			mails = append(mails, message.toOutlookMail())
+		}
+		next = list.NextLink
+	}
+
+	return mails, nil
+}
+
+// ClearInbox 清空收件箱
+func (p *graphProvider) ClearInbox(ctx context.Context, email *models.Email) error {
+	return p.clearFolder(ctx, email, "INBOX")
+}
+
+// ClearJunk 清空垃圾箱
+func (p *graphProvider) ClearJunk(ctx context.Context, email *models.Email) error {
+	return p.clearFolder(ctx, email, "Junk")
+}
+
+// clearFolder 分页删除文件夹中的邮件，删除 graphMaxClearRounds 页后仍有邮件时返回 ErrPartiallyCleared。
+// ctx 结束时停止删除，已删除的邮件不会恢复
+func (p *graphProvider) clearFolder(ctx context.Context, email *models.Email, mailbox string) error {
+	query := url.Values{}
+	query.Set("$top", fmt.Sprintf("%d", graphPageSize))
+	query.Set("$select", "id")
+	listURL := p.folderURL(mailbox) + "?" + query.Encode()
+
+	deleted := 0
+	for round := 0; round <= graphMaxClearRounds; round++ {
+		var list graphMessageList
+		if err := p.getJSON(ctx, email, listURL, &list); err != nil {
+			return err
+		}
+		if len(list.Value) == 0 {
+			return nil
+		}
+		if round == graphMaxClearRounds {
+			break
+		}
+
+		for _, message := range list.Value {
+			deleteURL := p.graphURL + "/me/messages/" + url.PathEscape(message.ID)
+			if _, err := p.send(ctx, email, http.MethodDelete, deleteURL); err != nil {
+				return err
+			}
+			deleted++
+		}
+	}
+
+	log.Printf("Graph clear %s for %s stopped after %d pages", mailbox, email.EmailAddress, graphMaxClearRounds)
+	return &UpstreamError{
+		Kind:    ErrPartiallyCleared,
+		Message: fmt.Sprintf("文件夹未完全清空：已删除 %d 封邮件，仍有邮件未删除，请再次清空", deleted),
+	}
+}
+
+// folderURL 文件夹邮件列表地址
+func (p *graphProvider) folderURL(mailbox string) string {
+	folder := "inbox"
+	if mailbox == "Junk" {
+		folder = "junkemail"
+	}
+	return p.graphURL + "/me/mailFolders/" + folder + "/messages"
+}
+
+// getJSON 发送GET请求并解析JSON响应
+func (p *graphProvider) getJSON(ctx context.Context, email *models.Email, requestURL string, out interface{}) error {
+	body, err := p.send(ctx, email, http.MethodGet, requestURL)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return newBadResponseError(err, body)
+	}
+	return nil
+}
+
+// send 携带访问令牌请求 Graph，按重试策略重试临时错误。
+// 访问令牌被拒绝时清除缓存并重新换取一次。ctx 结束时停止重试，取消的请求不计入熔断
+func (p *graphProvider) send(ctx context.Context, email *models.Email, method, requestURL string) ([]byte, error) {
+	tokenRetried := false
+	for attempt := 1; ; attempt++ {
+		if wait, ok := p.breaker.Allow(); !ok {
+			return nil, &UpstreamError{
+				Kind:       ErrUpstreamUnavailable,
+				Message:    "Graph 服务熔断中，请稍后重试",
+				RetryAfter: wait,
+				Cause:      ErrCircuitOpen,
+			}
+		}
+
+		body, err := p.sendOnce(ctx, email, method, requestURL)
+		if isCanceled(err) {
+			p.breaker.Release()
+			return nil, err
+		}
+		p.breaker.Record(err)
+		if err == nil {
+			return body, nil
+		}
+
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusUnauthorized && !tokenRetried {
+			tokenRetried = true
+			p.forgetToken(email)
+			continue
+		}
+
+		if attempt >= p.retry.MaxAttempts || !p.retry.ShouldRetry(err) {
+			return nil, err
+		}
+
+		delay := p.retry.Backoff(attempt)
+		if retryAfter := RetryAfterOf(err); retryAfter > delay {
+			if retryAfter > p.retry.MaxDelay {
+				return nil, err
+			}
+			delay = retryAfter
+		}
+
+		log.Printf("Graph %s attempt %d/%d failed, retrying in %v: %v", method, attempt, p.retry.MaxAttempts, delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sendOnce 发送单次 Graph 请求，非2xx响应转换为上游错误，ctx 结束时返回 ctx.Err()
+func (p *graphProvider) sendOnce(ctx context.Context, email *models.Email, method, requestURL string) ([]byte, error) {
+	token, err := p.accessToken(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	// 邮件正文统一返回HTML格式
+	req.Header.Set("Prefer", `outlook.body-content-type="html"`)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("请求失败", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("读取响应失败", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, parseErrorResponse(resp, body)
+	}
+
+	return body, nil
+}
+
+// accessToken 获取访问令牌，缓存未过期时直接返回。缓存按刷新令牌区分，
+// 无效的刷新令牌总会请求令牌端点，不会复用其他账户换取的访问令牌
+func (p *graphProvider) accessToken(ctx context.Context, email *models.Email) (string, error) {
+	key := graphTokenKey(email)
+
+	p.mu.Lock()
+	cached, ok := p.tokens[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.value, nil
+	}
+
+	token, err := p.refreshAccessToken(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	if token.RefreshToken != "" && token.RefreshToken != email.RefreshToken && p.onTokenRotated != nil {
+		p.onTokenRotated(email, token.RefreshToken)
+	}
+
+	p.mu.Lock()
+	// 回调已把邮箱更新为轮换后的刷新令牌，访问令牌缓存到新令牌下
+	now := time.Now()
+	delete(p.tokens, key)
+	for cachedKey, cachedToken := range p.tokens {
+		if now.After(cachedToken.expiresAt) {
+			delete(p.tokens, cachedKey)
+		}
+	}
+	p.tokens[graphTokenKey(email)] = graphAccessToken{
+		value:     token.AccessToken,
+		expiresAt: now.Add(time.Duration(token.ExpiresIn)*time.Second - graphTokenExpirySkew),
+	}
+	p.mu.Unlock()
+
+	return token.AccessToken, nil
+}
+
+// forgetToken 清除邮箱缓存的访问令牌
+func (p *graphProvider) forgetToken(email *models.Email) {
+	p.mu.Lock()
+	delete(p.tokens, graphTokenKey(email))
+	p.mu.Unlock()
+}
+
+// refreshAccessToken 使用客户端ID和刷新令牌在令牌端点换取访问令牌
+func (p *graphProvider) refreshAccessToken(ctx context.Context, email *models.Email) (*graphTokenResponse, error) {
+	form := url.Values{}
+	form.Set("client_id", email.ClientID)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", email.RefreshToken)
+	if p.scope != "" {
+		form.Set("scope", p.scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("换取访问令牌失败", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("读取令牌响应失败", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		upstreamErr := parseErrorResponse(resp, body).(*UpstreamError)
+		// 令牌端点的401同样表示凭据无效，避免被当作访问令牌过期再次重试
+		if upstreamErr.StatusCode == http.StatusUnauthorized {
+			upstreamErr.StatusCode = http.StatusBadRequest
+		}
+		return nil, upstreamErr
+	}
+
+	var token graphTokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, newBadResponseError(err, body)
+	}
+	if token.AccessToken == "" {
+		return nil, newBadResponseError(errors.New("缺少 access_token"), body)
+	}
+
+	return &token, nil
+}
+
+// graphTokenKey 访问令牌缓存键，取客户端ID和刷新令牌的哈希。邮箱地址和客户端ID不能区分账户：
+// 其他用户可以添加相同地址，常用客户端ID也由大量账户共用
+func graphTokenKey(email *models.Email) string {
+	sum := sha256.Sum256([]byte(email.ClientID + "\x00" + email.RefreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// toOutlookMail 转换为统一的邮件结构
+func (m graphMessage) toOutlookMail() models.OutlookMail {
+	var recipients []string
+	for _, recipient := range m.ToRecipients {
+		recipients = append(recipients, formatGraphAddress(recipient))
+	}
+
+	return models.OutlookMail{
+		ID:         m.ID,
+		Subject:    m.Subject,
+		From:       formatGraphAddress(m.From),
+		To:         strings.Join(recipients, ", "),
+		Body:       m.Body.Content,
+		IsRead:     m.IsRead,
+		ReceivedAt: m.ReceivedDateTime,
+	}
+}
+
+// formatGraphAddress 格式化邮件地址，名称与地址不同时使用“名称 <地址>”格式
+func formatGraphAddress(address graphEmailAddress) string {
+	name := address.EmailAddress.Name
+	addr := address.EmailAddress.Address
+	if name == "" || name == addr {
+		return addr
+	}
+	if addr == "" {
+		return name
+	}
+	return fmt.Sprintf("%s <%s>", name, addr)
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/models"
+)
+
+// fakeGraph 模拟令牌端点和 Graph 邮件接口，每次换取访问令牌都会轮换刷新令牌
+type fakeGraph struct {
+	mu            sync.Mutex
+	refreshToken  string              // 当前有效的刷新令牌
+	accessToken   string              // 当前有效的访问令牌
+	tokenRequests int                 // 令牌端点成功换取的次数
+	deletes       int                 // 删除请求次数
+	folders       map[string][]string // 文件夹 -> 邮件ID（按收件时间倒序）
+}
+
+func newFakeGraph(refreshToken string) *fakeGraph {
+	return &fakeGraph{refreshToken: refreshToken, folders: make(map[string][]string)}
+}
+
+// addMessages 向文件夹添加 n 封邮件
+func (f *fakeGraph) addMessages(folder string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.folders[folder] = append(f.folders[folder], fmt.Sprintf("%s-%d", folder, len(f.folders[folder])))
+	}
+}
+
+func (f *fakeGraph) count(folder string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.folders[folder])
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		f.serveToken(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+f.accessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"code":"InvalidAuthenticationToken","message":"Access token has expired."}}`))
+		return
+	}
+
+	const foldersPrefix, messagesPrefix = "/v1.0/me/mailFolders/", "/v1.0/me/messages/"
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, foldersPrefix):
+		folder := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, foldersPrefix), "/messages")
+		f.serveList(w, r, folder)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, messagesPrefix):
+		id := strings.TrimPrefix(r.URL.Path, messagesPrefix)
+		for folder, ids := range f.folders {
+			for i, existing := range ids {
+				if existing == id {
+					f.folders[folder] = append(ids[:i:i], ids[i+1:]...)
+					f.deletes++
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGraph) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("client_id") != "client" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	}
+	if r.PostForm.Get("refresh_token") != f.refreshToken {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"AADSTS70008: The refresh token has expired."}`))
+		return
+	}
+
+	f.tokenRequests++
+	f.refreshToken = fmt.Sprintf("refresh-%d", f.tokenRequests)
+	f.accessToken = fmt.Sprintf("access-%d", f.tokenRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  f.accessToken,
+		"refresh_token": f.refreshToken,
+		"expires_in":    3600,
+	})
+}
+
+// serveList 按 $top 和 $skip 分页返回邮件，还有下一页时返回 @odata.nextLink
+func (f *fakeGraph) serveList(w http.ResponseWriter, r *http.Request, folder string) {
+	ids := f.folders[folder]
+	top, _ := strconv.Atoi(r.URL.Query().Get("$top"))
+	skip, _ := strconv.Atoi(r.URL.Query().Get("$skip"))
+	end := min(skip+top, len(ids))
+	start := min(skip, end)
+
+	messages := make([]map[string]interface{}, 0, end-start)
+	for _, id := range ids[start:end] {
+		messages = append(messages, map[string]interface{}{
+			"id":               id,
+			"subject":          "Subject " + id,
+			"from":             map[string]interface{}{"emailAddress": map[string]string{"name": "Sender", "address": "sender@example.com"}},
+			"toRecipients":     []interface{}{map[string]interface{}{"emailAddress": map[string]string{"address": "user@example.com"}}},
+			"body":             map[string]string{"contentType": "html", "content": "<p>" + id + "</p>"},
+			"isRead":           false,
+			"receivedDateTime": "2026-10-01T12:00:00Z",
+		})
+	}
+
+	response := map[string]interface{}{"value": messages}
+	if end < len(ids) {
+		query := r.URL.Query()
+		query.Set("$skip", strconv.Itoa(end))
+		response["@odata.nextLink"] = "http://" + r.Host + r.URL.Path + "?" + query.Encode()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// newTestGraphProvider 创建指向 fakeGraph 的 Graph 后端，轮换的刷新令牌写回邮箱
+func newTestGraphProvider(t *testing.T, fake *fakeGraph) *graphProvider {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	p := newGraphProvider(&config.Config{
+		MailTokenURL:            srv.URL + "/token",
+		MailGraphBaseURL:        srv.URL + "/v1.0",
+		OutlookRetryMaxAttempts: 1,
+	})
+	p.onTokenRotated = func(email *models.Email, refreshToken string) {
+		email.RefreshToken = refreshToken
+	}
+	return p
+}
+
+func newTestGraphEmail() *models.Email {
+	return &models.Email{ID: 1, EmailAddress: "user@example.com", ClientID: "client", RefreshToken: "refresh-0"}
+}
+
+// 换取访问令牌时轮换的刷新令牌写回邮箱，访问令牌缓存到新的刷新令牌下
+func TestGraphProviderTokenRotation(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	fake.addMessages("inbox", 1)
+	p := newTestGraphProvider(t, fake)
+	email := newTestGraphEmail()
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetLatestMail(context.Background(), email, "INBOX", "json"); err != nil {
+			t.Fatalf("GetLatestMail #%d error = %v", i+1, err)
+		}
+	}
+	if email.RefreshToken != "refresh-1" {
+		t.Fatalf("refresh token = %q, want rotated %q", email.RefreshToken, "refresh-1")
+	}
+	if fake.tokenRequests != 1 {
+		t.Fatalf("token requests = %d, want 1 (cached access token)", fake.tokenRequests)
+	}
+}
+
+// 令牌端点拒绝刷新令牌时返回 ErrInvalidGrant
+func TestGraphProviderInvalidRefreshToken(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	p := newTestGraphProvider(t, fake)
+	email := newTestGraphEmail()
+	email.RefreshToken = "revoked"
+
+	_, err := p.GetLatestMail(context.Background(), email, "INBOX", "json")
+	if !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("error = %v, want ErrInvalidGrant", err)
+	}
+}
+
+// 访问令牌被拒绝时重新换取一次
+func TestGraphProviderExpiredAccessTokenRefreshed(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	fake.addMessages("inbox", 1)
+	p := newTestGraphProvider(t, fake)
+	email := newTestGraphEmail()
+
+	if _, err := p.GetLatestMail(context.Background(), email, "INBOX", "json"); err != nil {
+		t.Fatalf("GetLatestMail error = %v", err)
+	}
+
+	// 服务端使访问令牌失效
+	fake.mu.Lock()
+	fake.accessToken = "revoked"
+	fake.mu.Unlock()
+
+	if _, err := p.GetLatestMail(context.Background(), email, "INBOX", "json"); err != nil {
+		t.Fatalf("GetLatestMail after access token revoked error = %v", err)
+	}
+	if fake.tokenRequests != 2 {
+		t.Fatalf("token requests = %d, want 2", fake.tokenRequests)
+	}
+}
+
+func TestGraphProviderGetLatestMail(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	p := newTestGraphProvider(t, fake)
+	email := newTestGraphEmail()
+
+	if _, err := p.GetLatestMail(context.Background(), email, "Junk", "json"); !errors.Is(err, ErrNoMail) {
+		t.Fatalf("empty folder error = %v, want ErrNoMail", err)
+	}
+
+	fake.addMessages("junkemail", 2)
+	mail, err := p.GetLatestMail(context.Background(), email, "Junk", "json")
+	if err != nil {
+		t.Fatalf("GetLatestMail error = %v", err)
+	}
+	if mail.ID != "junkemail-0" || mail.From != "Sender <sender@example.com>" || mail.To != "user@example.com" || mail.Body != "<p>junkemail-0</p>" {
+		t.Fatalf("unexpected mail: %+v", mail)
+	}
+}
+
+// 获取全部邮件时跟随 @odata.nextLink 翻页，最多返回 graphMaxMails 封
+func TestGraphProviderGetAllMailsFollowsNextLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages int
+		want     int
+	}{
+		{"single page", 3, 3},
+		{"multiple pages", graphPageSize*2 + 1, graphPageSize*2 + 1},
+		{"capped", graphMaxMails + graphPageSize, graphMaxMails},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeGraph("refresh-0")
+			fake.addMessages("inbox", tt.messages)
+			p := newTestGraphProvider(t, fake)
+
+			mails, err := p.GetAllMails(context.Background(), newTestGraphEmail(), "INBOX")
+			if err != nil {
+				t.Fatalf("GetAllMails error = %v", err)
+			}
+			if len(mails) != tt.want {
+				t.Fatalf("got %d mails, want %d", len(mails), tt.want)
+			}
+			if mails[len(mails)-1].ID != fmt.Sprintf("inbox-%d", tt.want-1) {
+				t.Fatalf("last mail = %s, pages out of order", mails[len(mails)-1].ID)
+			}
+		})
+	}
+}
+
+// 清空文件夹时逐页删除直到没有邮件
+func TestGraphProviderClearInbox(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	fake.addMessages("inbox", graphPageSize*2+1)
+	fake.addMessages("junkemail", 1)
+	p := newTestGraphProvider(t, fake)
+
+	if err := p.ClearInbox(context.Background(), newTestGraphEmail()); err != nil {
+		t.Fatalf("ClearInbox error = %v", err)
+	}
+	if n := fake.count("inbox"); n != 0 {
+		t.Fatalf("inbox has %d messages left, want 0", n)
+	}
+	if n := fake.count("junkemail"); n != 1 {
+		t.Fatalf("junk has %d messages, want 1 (untouched)", n)
+	}
+}
+
+// 删除 graphMaxClearRounds 页后仍有邮件时返回 ErrPartiallyCleared
+func TestGraphProviderClearInboxPartiallyCleared(t *testing.T) {
+	fake := newFakeGraph("refresh-0")
+	fake.addMessages("inbox", graphPageSize*graphMaxClearRounds+1)
+	p := newTestGraphProvider(t, fake)
+
+	err := p.ClearInbox(context.Background(), newTestGraphEmail())
+	if !errors.Is(err, ErrPartiallyCleared) {
+		t.Fatalf("ClearInbox error = %v, want ErrPartiallyCleared", err)
+	}
+	if fake.deletes != graphPageSize*graphMaxClearRounds {
+		t.Fatalf("deleted %d messages, want %d", fake.deletes, graphPageSize*graphMaxClearRounds)
+	}
+	if n := fake.count("inbox"); n != 1 {
+		t.Fatalf("inbox has %d messages left, want 1", n)
+	}
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+)
+
+// ErrHealthCheckRunning 当前用户已有正在运行的健康检查任务
+var ErrHealthCheckRunning = errors.New("已有健康检查任务正在运行")
+
+// HealthCheckResult 单个邮箱的检查结果
+type HealthCheckResult struct {
+	EmailID      int    `json:"email_id"`
+	EmailAddress string `json:"email_address"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// HealthCheckJob 健康检查任务报告，由后台任务及其逐项结果整理而来
+type HealthCheckJob struct {
+	ID          string              `json:"id"`
+	Status      string              `json:"status"`
+	Total       int                 `json:"total"`
+	Done        int                 `json:"done"`
+	Success     int                 `json:"success"`
+	Failed      int                 `json:"failed"`
+	Dead        []HealthCheckResult `json:"dead"`   // 令牌失效或账户锁定
+	Errors      []HealthCheckResult `json:"errors"` // 临时错误（如网络异常），无法判断账户状态
+	TagName     string              `json:"tag_name,omitempty"`
+	TaggedCount int                 `json:"tagged_count"`
+	Error       string              `json:"error,omitempty"`
+	StartedAt   time.Time           `json:"started_at"`
+	FinishedAt  *time.Time          `json:"finished_at,omitempty"`
+}
+
+// healthCheckPayload 健康检查任务参数，创建任务时确定需要检查的邮箱
+type healthCheckPayload struct {
+	EmailIDs []int  `json:"email_ids"`
+	TagName  string `json:"tag_name,omitempty"`
+}
+
+// healthCheckSummary 健康检查任务的汇总结果
+type healthCheckSummary struct {
+	TagName     string `json:"tag_name,omitempty"`
+	TaggedCount int    `json:"tagged_count"`
+}
+
+// HealthCheckService 账户健康检查服务
+type HealthCheckService struct {
+	emailRepo      *database.EmailRepository
+	tagRepo        *database.TagRepository
+	logRepo        *database.LogRepository
+	outlookService *OutlookService
+	jobs           *JobManager
+	config         *config.Config
+
+	mu sync.Mutex // 串行化“检查是否有运行中的任务-创建任务”
+}
+
+// NewHealthCheckService 创建账户健康检查服务并注册健康检查任务
+func NewHealthCheckService(db *database.DB, outlookService *OutlookService, jobs *JobManager, cfg *config.Config) *HealthCheckService {
+	s := &HealthCheckService{
+		emailRepo:      db.Email,
+		tagRepo:        db.Tag,
+		logRepo:        db.Log,
+		outlookService: outlookService,
+		jobs:           jobs,
+		config:         cfg,
+	}
+
+	jobs.Register(JobHealthCheck, s.run, nil)
+	return s
+}
+
+// Start 创建健康检查后台任务，每个用户同时只能有一个未结束的健康检查任务
+func (s *HealthCheckService) Start(userID int, req *models.HealthCheckRequest, client models.ClientInfo) (*HealthCheckJob, error) {
+	emails, err := s.selectEmails(userID, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, errors.New("没有需要检查的邮箱")
+	}
+
+	payload := healthCheckPayload{EmailIDs: make([]int, len(emails))}
+	for i, email := range emails {
+		payload.EmailIDs[i] = email.ID
+	}
+	if req.TagInvalid {
+		payload.TagName = s.config.HealthCheckInvalidTag
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active, err := s.jobs.HasActive(userID, JobHealthCheck)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, ErrHealthCheckRunning
+	}
+
+	job, err := s.jobs.Submit(userID, JobHealthCheck, payload, len(emails), client)
+	if err != nil {
+		return nil, err
+	}
+	return newHealthCheckJob(job, payload.TagName), nil
+}
+
+// GetJob 获取健康检查任务的当前进度和失效账户报告
+func (s *HealthCheckService) GetJob(userID int, jobID string) (*HealthCheckJob, error) {
+	job, err := s.jobs.Get(userID, jobID)
+	if err != nil || job.Type != JobHealthCheck {
+		return nil, errors.New("健康检查任务不存在")
+	}
+
+	var summary healthCheckSummary
+	if len(job.Result) > 0 {
+		json.Unmarshal(job.Result, &summary)
+	}
+
+	report := newHealthCheckJob(job, summary.TagName)
+	report.TaggedCount = summary.TaggedCount
+	for _, item := range job.Items {
+		result := HealthCheckResult{
+			EmailID:      item.EmailID,
+			EmailAddress: item.EmailAddress,
+			Status:       item.Status,
+			Error:        item.Error,
+		}
+		switch item.Status {
+		case models.EmailStatusInvalidToken, models.EmailStatusLocked:
+			report.Dead = append(report.Dead, result)
+		case models.EmailStatusUnknown:
+			report.Errors = append(report.Errors, result)
+		}
+	}
+	return report, nil
+}
+
+// newHealthCheckJob 由后台任务生成健康检查报告（不含逐项结果）
+func newHealthCheckJob(job *models.Job, tagName string) *HealthCheckJob {
+	startedAt := job.CreatedAt
+	if job.StartedAt != nil {
+		startedAt = *job.StartedAt
+	}
+
+	return &HealthCheckJob{
+		ID:         job.ID,
+		Status:     job.Status,
+		Total:      job.Total,
+		Done:       job.Done,
+		Success:    job.Success,
+		Failed:     job.Failed,
+		Dead:       []HealthCheckResult{},
+		Errors:     []HealthCheckResult{},
+		TagName:    tagName,
+		Error:      job.Error,
+		StartedAt:  startedAt,
+		FinishedAt: job.FinishedAt,
+	}
+}
+
+// selectEmails 根据请求选择需要检查的邮箱：指定ID > 指定标签 > 全部
+func (s *HealthCheckService) selectEmails(userID int, req *models.HealthCheckRequest) ([]models.Email, error) {
+	if len(req.EmailIDs) > 0 {
+		return s.emailRepo.GetEmailsByIDs(userID, req.EmailIDs)
+	}
+	if req.TagID > 0 {
+		if _, err := s.tagRepo.GetUserTag(userID, req.TagID); err != nil {
+			return nil, errors.New("标签不存在")
+		}
+		return s.emailRepo.GetAllEmailsByTag(userID, req.TagID)
+	}
+	return s.emailRepo.GetAllEmailsByUserID(userID)
+}
+
+// run 使用worker pool并发验证邮箱凭据，全部完成后为失效账户添加标签。继续执行时跳过已检查的邮箱
+func (s *HealthCheckService) run(ctx context.Context, run *JobRun) error {
+	var payload healthCheckPayload
+	if err := run.Decode(&payload); err != nil {
+		return err
+	}
+	userID := run.Job.UserID
+
+	emails, err := s.emailRepo.GetEmailsByIDs(userID, payload.EmailIDs)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]models.Email, len(emails))
+	for _, email := range emails {
+		byID[email.ID] = email
+	}
+
+	maxWorkers := s.config.EmailValidationWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = 5 // 默认并发数
+	}
+
+	type checkTask struct {
+		index   int
+		emailID int
+	}
+
+	taskChan := make(chan checkTask)
+	var wg sync.WaitGroup
+
+	// 启动worker goroutines
+	for w := 0; w < maxWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				// 任务取消或服务停止时中断的检查不记录，下次继续
+				if item, ok := s.checkEmail(ctx, task.index, task.emailID, byID); ok {
+					run.Record(item)
+				}
+			}
+		}()
+	}
+
+	// 发送任务到worker pool，任务取消或服务停止时不再发送
+send:
+	for i, emailID := range payload.EmailIDs {
+		if run.Completed(i + 1) {
+			continue
+		}
+		select {
+		case taskChan <- checkTask{index: i + 1, emailID: emailID}:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(taskChan)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 按原始顺序整理失败结果（包括重启前已检查的邮箱）
+	items, err := run.Items()
+	if err != nil {
+		return err
+	}
+	var deadIDs []int
+	var transient int
+	for _, item := range items {
+		switch item.Status {
+		case models.EmailStatusInvalidToken, models.EmailStatusLocked:
+			deadIDs = append(deadIDs, item.EmailID)
+		case models.EmailStatusUnknown:
+			transient++
+		}
+	}
+
+	// 为失效账户添加标签
+	summary := healthCheckSummary{TagName: payload.TagName}
+	var tagErr error
+	if payload.TagName != "" && len(deadIDs) > 0 {
+		tagErr = s.tagInvalidEmails(userID, deadIDs, payload.TagName)
+		if tagErr == nil {
+			summary.TaggedCount = len(deadIDs)
+		} else {
+			log.Printf("Health check %s: failed to tag invalid emails: %v", run.Job.ID, tagErr)
+		}
+	}
+	run.SetResult(summary)
+
+	// 记录健康检查日志
+	_, success, _ := run.Counts()
+	s.logRepo.LogEmail(userID, "health_check", 0,
+		fmt.Sprintf("账户健康检查，共: %d, 正常: %d, 失效: %d, 临时错误: %d", len(payload.EmailIDs), success, len(deadIDs), transient),
+		run.Job.Client())
+
+	if tagErr != nil {
+		return fmt.Errorf("添加失效标签失败: %v", tagErr)
+	}
+	return nil
+}
+
+// checkEmail 验证单个邮箱的凭据，结果同时会更新到邮箱的账户状态。ctx 结束导致检查中断时返回false
+func (s *HealthCheckService) checkEmail(ctx context.Context, index, emailID int, emails map[int]models.Email) (models.JobItem, bool) {
+	item := models.JobItem{Index: index, EmailID: emailID}
+
+	email, ok := emails[emailID]
+	if !ok {
+		item.Status = "failed"
+		item.Error = "邮箱不存在"
+		return item, true
+	}
+	item.EmailAddress = email.EmailAddress
+
+	start := time.Now()
+	err := s.outlookService.ValidateEmailCredentials(ctx, &email)
+	item.DurationMs = time.Since(start).Milliseconds()
+	if isCanceled(err) {
+		return item, false
+	}
+	if err != nil {
+		item.Status = classifyUpstreamError(err)
+		if item.Status == "" {
+			item.Status = models.EmailStatusUnknown
+		}
+		item.Error = truncateError(err.Error())
+		return item, true
+	}
+
+	item.Status = models.EmailStatusActive
+	item.Success = true
+	return item, true
+}
+
+// tagInvalidEmails 为失效账户添加用户自己的失效标签，标签不存在时自动创建
+func (s *HealthCheckService) tagInvalidEmails(userID int, emailIDs []int, tagName string) error {
+	tag, err := s.tagRepo.GetTagByName(userID, tagName)
+	if errors.Is(err, sql.ErrNoRows) {
+		tag, err = s.tagRepo.CreateTag(&models.Tag{
+			UserID:      userID,
+			Name:        tagName,
+			Description: "账户健康检查自动标记的失效账户",
+			Color:       "#dc3545",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.tagRepo.BatchAddEmailTags(emailIDs, tag.ID)
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+)
+
+// 单行导入结果
+const (
+	ImportLineAdded  = "added"
+	ImportLineFailed = "failed"
+)
+
+// maxImportLineSize 单行最大长度，RefreshToken 一般不超过几KB
+const maxImportLineSize = 64 * 1024
+
+// importInputBatch 上传文件每批写入数据库的行数
+const importInputBatch = 500
+
+// importPayload 导入任务参数：上传文件的各行保存在任务输入中，或为已解析的邮箱列表
+type importPayload struct {
+	Staged bool                     `json:"staged,omitempty"`
+	Emails []models.AddEmailRequest `json:"emails,omitempty"`
+}
+
+// importLine 待导入的一行，解析失败时 err 不为空
+type importLine struct {
+	line int
+	req  models.AddEmailRequest
+	err  string
+}
+
+// importResult 单行验证结果，验证通过时 email 不为空，等待分块保存
+type importResult struct {
+	item  models.JobItem
+	email *models.Email
+}
+
+// ImportService 邮箱批量导入服务：流式读取、并发验证、分块保存
+type ImportService struct {
+	emailRepo      *database.EmailRepository
+	logRepo        *database.LogRepository
+	outlookService *OutlookService
+	jobs           *JobManager
+	config         *config.Config
+	limiter        *paceLimiter // 所有导入任务共享的凭据验证限速
+}
+
+// NewImportService 创建邮箱导入服务并注册导入任务
+func NewImportService(db *database.DB, outlookService *OutlookService, jobs *JobManager, cfg *config.Config) *ImportService {
+	s := &ImportService{
+		emailRepo:      db.Email,
+		logRepo:        db.Log,
+		outlookService: outlookService,
+		jobs:           jobs,
+		config:         cfg,
+		limiter:        newPaceLimiter(cfg.ImportRatePerSecond),
+	}
+
+	jobs.Register(JobImportEmails, s.run, nil)
+	return s
+}
+
+// StartFile 流式读取上传的文件并创建后台导入任务。每个非空行作为任务输入加密保存到数据库，
+// 文件不会以明文写入磁盘或整体读入内存，任务结束后删除
+func (s *ImportService) StartFile(userID int, src io.Reader, client models.ClientInfo) (*models.Job, error) {
+	stage := func(save func([]models.JobInput) error) (int, error) {
+		return stageImportLines(src, save)
+	}
+	return s.jobs.SubmitWithInputs(userID, JobImportEmails, importPayload{Staged: true}, stage, client)
+}
+
+// StartBatch 创建后台任务导入已解析的邮箱列表
+func (s *ImportService) StartBatch(userID int, emails []models.AddEmailRequest, client models.ClientInfo) (*models.Job, error) {
+	if len(emails) == 0 {
+		return nil, errors.New("没有需要添加的邮箱")
+	}
+	return s.jobs.Submit(userID, JobImportEmails, importPayload{Emails: emails}, len(emails), client)
+}
+
+// run 使用worker pool并发验证，验证通过的邮箱按块保存。继续执行时跳过已有结果的行
+func (s *ImportService) run(ctx context.Context, run *JobRun) error {
+	var payload importPayload
+	if err := run.Decode(&payload); err != nil {
+		return err
+	}
+
+	maxWorkers := s.config.EmailValidationWorkers
+	if maxWorkers <= 0 {
+		maxWorkers = 5 // 默认并发数
+	}
+	chunkSize := s.config.ImportChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 100
+	}
+
+	// 读取错误在 lines 关闭前写入，所有结果收集完后再读取
+	lines := make(chan importLine)
+	var readErr error
+	go func() {
+		defer close(lines)
+		send := func(line importLine) error {
+			if run.Completed(line.line) {
+				return nil
+			}
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if payload.Staged {
+			readErr = readImportInputs(run, send)
+		} else {
+			readErr = sendImportBatch(payload.Emails, send)
+		}
+	}()
+
+	results := make(chan importResult, maxWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < maxWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				// 任务取消或服务停止后不再验证，未处理的行下次继续
+				if result, ok := s.validateLine(ctx, run.Job.UserID, line); ok {
+					results <- result
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// 收集验证结果，攒够一块后保存
+	pending := make([]importResult, 0, chunkSize)
+	for result := range results {
+		if result.email == nil {
+			run.Record(result.item)
+			continue
+		}
+
+		pending = append(pending, result)
+		if len(pending) >= chunkSize {
+			s.commit(run, pending)
+			pending = pending[:0]
+		}
+	}
+	s.commit(run, pending)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return fmt.Errorf("读取导入数据失败: %v", readErr)
+	}
+
+	// 记录批量添加日志
+	_, success, failed := run.Counts()
+	s.logRepo.LogEmail(run.Job.UserID, "batch_add_emails", 0,
+		fmt.Sprintf("导入邮箱，共: %d, 成功: %d, 失败: %d", run.Job.Total, success, failed),
+		run.Job.Client())
+
+	return nil
+}
+
+// validateLine 检查邮箱是否已存在并验证凭据，ctx 结束时返回false
+func (s *ImportService) validateLine(ctx context.Context, userID int, line importLine) (importResult, bool) {
+	result := importResult{item: models.JobItem{
+		Index:        line.line,
+		EmailAddress: line.req.EmailAddress,
+		Status:       ImportLineFailed,
+	}}
+	if line.err != "" {
+		result.item.Error = line.err
+		return result, true
+	}
+
+	exists, err := s.emailRepo.EmailExists(userID, line.req.EmailAddress)
+	if err != nil {
+		result.item.Error = fmt.Sprintf("检查邮箱存在性失败: %v", err)
+		return result, true
+	}
+	if exists {
+		result.item.Error = "邮箱已存在"
+		return result, true
+	}
+
+	email := &models.Email{
+		UserID:       userID,
+		EmailAddress: line.req.EmailAddress,
+		Password:     line.req.Password,
+		ClientID:     line.req.ClientID,
+		RefreshToken: line.req.RefreshToken,
+		Remark:       line.req.Remark,
+		Provider:     line.req.Provider,
+	}
+
+	// 验证邮箱凭据（如果配置允许跳过验证则跳过）
+	if !s.config.SkipEmailValidation {
+		if err := s.limiter.Wait(ctx); err != nil {
+			return result, false
+		}
+		start := time.Now()
+		err := s.outlookService.ValidateEmailCredentials(ctx, email)
+		result.item.DurationMs = time.Since(start).Milliseconds()
+		if isCanceled(err) {
+			return result, false
+		}
+		if err != nil {
+			result.item.Error = truncateError(err.Error())
+			return result, true
+		}
+		markEmailChecked(email)
+	}
+
+	result.email = email
+	return result, true
+}
+
+// commit 保存一块验证通过的邮箱。整块保存失败时逐个保存，避免一个邮箱的错误影响整块
+func (s *ImportService) commit(run *JobRun, pending []importResult) {
+	if len(pending) == 0 {
+		return
+	}
+
+	emails := make([]*models.Email, len(pending))
+	for i := range pending {
+		emails[i] = pending[i].email
+	}
+
+	saved, err := s.emailRepo.BatchCreateEmails(emails)
+	if err != nil {
+		log.Printf("Import %s: chunk save failed, retrying one by one: %v", run.Job.ID, err)
+		for i := range pending {
+			email, err := s.emailRepo.CreateEmail(pending[i].email)
+			if err != nil {
+				pending[i].item.Error = fmt.Sprintf("保存失败: %v", err)
+				run.Record(pending[i].item)
+				continue
+			}
+			s.recordAdded(run, &pending[i], email)
+		}
+		return
+	}
+
+	for i := range saved {
+		s.recordAdded(run, &pending[i], &saved[i])
+	}
+}
+
+// recordAdded 记录保存成功的邮箱
+func (s *ImportService) recordAdded(run *JobRun, result *importResult, email *models.Email) {
+	result.item.Status = ImportLineAdded
+	result.item.Success = true
+	result.item.EmailID = email.ID
+	run.Record(result.item)
+
+	s.logRepo.LogEmail(run.Job.UserID, "email_added", email.ID,
+		fmt.Sprintf("添加邮箱: %s", email.EmailAddress),
+		run.Job.Client())
+}
+
+// stageImportLines 逐行读取上传的文件，非空行以行号为序号分批交给 save，返回非空行数
+func stageImportLines(src io.Reader, save func([]models.JobInput) error) (int, error) {
+	scanner := newImportScanner(src)
+	batch := make([]models.JobInput, 0, importInputBatch)
+	total, lineNo := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		total++
+		batch = append(batch, models.JobInput{Index: lineNo, Data: text})
+		if len(batch) >= importInputBatch {
+			if err := save(batch); err != nil {
+				return 0, fmt.Errorf("保存上传文件失败: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("读取上传文件失败: %v", err)
+	}
+	if total == 0 {
+		return 0, errors.New("文件中没有有效的邮箱数据")
+	}
+
+	if len(batch) > 0 {
+		if err := save(batch); err != nil {
+			return 0, fmt.Errorf("保存上传文件失败: %v", err)
+		}
+	}
+	return total, nil
+}
+
+// readImportInputs 按行号读取上传文件的各行，解析后交给 send，同一文件中重复的邮箱只导入第一次出现的
+func readImportInputs(run *JobRun, send func(importLine) error) error {
+	seen := make(map[string]bool)
+	return run.ReadInputs(func(input models.JobInput) error {
+		line := importLine{line: input.Index}
+		req, err := ParseEmailLine(input.Data)
+		if err != nil {
+			line.err = err.Error()
+		} else {
+			line.req = *req
+		}
+		return send(dedupeImportLine(seen, line))
+	})
+}
+
+// sendImportBatch 把已解析的邮箱列表逐个交给 send，序号从1开始
+func sendImportBatch(emails []models.AddEmailRequest, send func(importLine) error) error {
+	seen := make(map[string]bool)
+	for i, req := range emails {
+		if err := send(dedupeImportLine(seen, importLine{line: i + 1, req: req})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newImportScanner 创建按行读取的扫描器
+func newImportScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineSize)
+	return scanner
+}
+
+// dedupeImportLine 标记本次导入中重复出现的邮箱
+func dedupeImportLine(seen map[string]bool, line importLine) importLine {
+	if line.err != "" {
+		return line
+	}
+
+	key := strings.ToLower(line.req.EmailAddress)
+	if seen[key] {
+		line.err = "与前面的行重复"
+	}
+	seen[key] = true
+	return line
+}
+
+// ParseEmailLine 解析一行邮箱数据，支持两种格式：
+// 1. 邮箱----密码----客户端ID----RefreshToken
+// 2. CSV格式：邮箱,密码,客户端ID,RefreshToken,备注
+func ParseEmailLine(line string) (*models.AddEmailRequest, error) {
+	var parts []string
+	if strings.Contains(line, "----") {
+		parts = strings.Split(line, "----")
+	} else if strings.Contains(line, ",") {
+		parts = strings.Split(line, ",")
+	} else {
+		return nil, errors.New("格式错误")
+	}
+
+	if len(parts) < 4 {
+		return nil, errors.New("数据不完整")
+	}
+
+	email := &models.AddEmailRequest{
+		EmailAddress: strings.TrimSpace(parts[0]),
+		Password:     strings.TrimSpace(parts[1]),
+		ClientID:     strings.TrimSpace(parts[2]),
+		RefreshToken: strings.TrimSpace(parts[3]),
+	}
+
+	// 如果有第5个字段，作为备注
+	if len(parts) > 4 {
+		email.Remark = strings.TrimSpace(parts[4])
+	}
+
+	// 基本验证
+	if email.EmailAddress == "" || email.Password == "" || email.ClientID == "" || email.RefreshToken == "" {
+		return nil, errors.New("必填字段为空")
+	}
+
+	return email, nil
+}
+
+// paceLimiter 按固定间隔放行请求的全局限速器，nil表示不限速
+type paceLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newPaceLimiter 创建每秒放行 perSecond 个请求的限速器，perSecond 不大于0时返回nil
+func newPaceLimiter(perSecond int) *paceLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &paceLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// Wait 等待下一个放行时间，ctx 取消时提前返回错误
+func (l *paceLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,564 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+)
+
+// 后台任务类型
+const (
+	JobImportEmails    = "import_emails"
+	JobHealthCheck     = "health_check"
+	JobBatchClearInbox = "batch_clear_inbox"
+	JobBatchClearJunk  = "batch_clear_junk"
+	JobBatchTag        = "batch_tag_emails"
+	JobBatchUntag      = "batch_untag_emails"
+)
+
+const (
+	// jobPollInterval 没有新任务通知时检查等待队列的间隔
+	jobPollInterval = 5 * time.Second
+	// jobPruneInterval 清理已结束任务的间隔
+	jobPruneInterval = time.Hour
+	// jobInputPageSize 每次从数据库读取的任务输入数量
+	jobInputPageSize = 200
+)
+
+// 后台任务错误
+var (
+	ErrJobNotFound = errors.New("任务不存在")
+	ErrJobFinished = errors.New("任务已结束")
+)
+
+// JobFunc 任务的执行逻辑。ctx 在任务被取消或服务停止时结束，此时应尽快返回 ctx.Err()，
+// 服务停止时任务会放回等待队列，重启后再次执行，已记录结果的对象可通过 JobRun.Completed 跳过
+type JobFunc func(ctx context.Context, run *JobRun) error
+
+// jobHandler 已注册的任务类型
+type jobHandler struct {
+	run     JobFunc
+	cleanup func(run *JobRun) // 任务结束（完成、失败或取消）后清理资源，可为nil
+}
+
+// runningJob 正在执行的任务
+type runningJob struct {
+	cancel    context.CancelFunc
+	cancelled bool // 是否由用户取消（否则为服务停止）
+}
+
+// JobManager 后台任务执行器：任务保存在数据库中，由固定数量的worker按创建顺序执行
+type JobManager struct {
+	repo      *database.JobRepository
+	logRepo   *database.LogRepository
+	events    *EventHub
+	workers   int
+	retention time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]jobHandler
+	running  map[string]*runningJob
+	wake     chan struct{}
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewJobManager 创建后台任务执行器，注册任务类型后调用 Start 开始执行
+func NewJobManager(db *database.DB, events *EventHub, cfg *config.Config) *JobManager {
+	workers := cfg.JobWorkers
+	if workers <= 0 {
+		workers = 2
+	}
+
+	return &JobManager{
+		repo:      db.Job,
+		logRepo:   db.Log,
+		events:    events,
+		workers:   workers,
+		retention: time.Duration(cfg.JobRetentionHours) * time.Hour,
+		handlers:  make(map[string]jobHandler),
+		running:   make(map[string]*runningJob),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Register 注册任务类型，cleanup 可为nil
+func (m *JobManager) Register(jobType string, run JobFunc, cleanup func(run *JobRun)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[jobType] = jobHandler{run: run, cleanup: cleanup}
+}
+
+// Resume 把上次退出时未完成的任务放回等待队列，返回等待执行的任务数。
+// 同时删除创建任务前服务退出时遗留的任务输入
+func (m *JobManager) Resume() (int, error) {
+	if count, err := m.repo.DeleteOrphanJobInputs(); err != nil {
+		return 0, err
+	} else if count > 0 {
+		log.Printf("Deleted %d inputs of unsubmitted jobs", count)
+	}
+	return m.repo.RequeueRunningJobs()
+}
+
+// Start 启动worker和过期任务清理
+func (m *JobManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopChan != nil {
+		return
+	}
+
+	m.stopChan = make(chan struct{})
+	for i := 0; i < m.workers; i++ {
+		m.wg.Add(1)
+		go m.worker(m.stopChan)
+	}
+	m.wg.Add(1)
+	go m.pruneLoop(m.stopChan)
+
+	log.Printf("Job manager started, workers: %d", m.workers)
+}
+
+// Stop 停止执行，正在执行的任务放回等待队列，下次启动时继续
+func (m *JobManager) Stop() {
+	m.mu.Lock()
+	if m.stopChan == nil {
+		m.mu.Unlock()
+		return
+	}
+	close(m.stopChan)
+	m.stopChan = nil
+	for _, job := range m.running {
+		job.cancel()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+// Submit 创建任务，payload 序列化为JSON保存，total 为需要处理的对象数（未知时为0）
+func (m *JobManager) Submit(userID int, jobType string, payload interface{}, total int, client models.ClientInfo) (*models.Job, error) {
+	if err := m.checkType(jobType); err != nil {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	return m.create(id, userID, jobType, payload, total, client)
+}
+
+// SubmitWithInputs 创建带逐项输入的任务。stage 通过 save 分批写入输入（加密保存在数据库中），
+// 返回需要处理的对象数；全部写入后任务才进入等待队列，出错时删除已写入的输入。
+// 任务执行时通过 JobRun.ReadInputs 读取输入
+func (m *JobManager) SubmitWithInputs(userID int, jobType string, payload interface{}, stage func(save func([]models.JobInput) error) (int, error), client models.ClientInfo) (*models.Job, error) {
+	if err := m.checkType(jobType); err != nil {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := stage(func(inputs []models.JobInput) error {
+		return m.repo.SaveJobInputs(id, inputs)
+	})
+	if err == nil {
+		var job *models.Job
+		if job, err = m.create(id, userID, jobType, payload, total, client); err == nil {
+			return job, nil
+		}
+	}
+
+	if delErr := m.repo.DeleteJobInputs(id); delErr != nil {
+		log.Printf("Failed to delete inputs of job %s: %v", id, delErr)
+	}
+	return nil, err
+}
+
+// checkType 检查任务类型是否已注册
+func (m *JobManager) checkType(jobType string) error {
+	m.mu.Lock()
+	_, ok := m.handlers[jobType]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("未知的任务类型: %s", jobType)
+	}
+	return nil
+}
+
+// create 保存等待执行的任务并通知空闲的worker
+func (m *JobManager) create(id string, userID int, jobType string, payload interface{}, total int, client models.ClientInfo) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.Job{
+		ID:           id,
+		UserID:       userID,
+		Type:         jobType,
+		Payload:      string(data),
+		Total:        total,
+		IPAddress:    client.IPAddress,
+		UserAgent:    client.UserAgent,
+		APIKeyID:     client.APIKeyID,
+		APIKeyPrefix: client.APIKeyPrefix,
+	}
+	if err := m.repo.CreateJob(job); err != nil {
+		return nil, err
+	}
+
+	// 通知空闲的worker，没有空闲worker时任务留在等待队列
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// Get 获取用户的任务及逐项结果
+func (m *JobManager) Get(userID int, jobID string) (*models.Job, error) {
+	job, err := m.getJob(userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := m.repo.GetJobItems(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	job.Items = items
+	return job, nil
+}
+
+// HasActive 用户是否有指定类型的未结束任务
+func (m *JobManager) HasActive(userID int, jobType string) (bool, error) {
+	return m.repo.HasActiveJob(userID, jobType)
+}
+
+// Cancel 取消任务。等待中的任务直接取消，执行中的任务在当前对象处理完后停止
+func (m *JobManager) Cancel(userID int, jobID string, client models.ClientInfo) (*models.Job, error) {
+	job, err := m.getJob(userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Finished() {
+		return nil, ErrJobFinished
+	}
+
+	m.mu.Lock()
+	handler := m.handlers[job.Type]
+	running, isRunning := m.running[job.ID]
+	if isRunning {
+		running.cancelled = true
+		running.cancel()
+	}
+	m.mu.Unlock()
+
+	if !isRunning {
+		if err := m.repo.CancelPendingJob(job.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// 任务刚被worker取出，重试一次走执行中的取消流程
+				return m.Cancel(userID, jobID, client)
+			}
+			return nil, err
+		}
+		if handler.cleanup != nil {
+			handler.cleanup(&JobRun{Job: job, manager: m})
+		}
+		m.publishStatus(job.UserID, job.ID)
+	}
+
+	m.logRepo.LogEmail(userID, "job_cancelled", 0,
+		fmt.Sprintf("取消后台任务 %s（%s）", job.ID, job.Type),
+		client)
+
+	return m.getJob(userID, jobID)
+}
+
+// getJob 获取任务（不含明细），任务不属于该用户时视为不存在
+func (m *JobManager) getJob(userID int, jobID string) (*models.Job, error) {
+	job, err := m.repo.GetJob(jobID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && job.UserID != userID) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// worker 按创建顺序取出等待中的任务并执行
+func (m *JobManager) worker(stopChan chan struct{}) {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+
+		job, err := m.repo.ClaimNextJob()
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to claim job: %v", err)
+			}
+			select {
+			case <-stopChan:
+				return
+			case <-m.wake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		m.execute(job)
+	}
+}
+
+// execute 执行任务并记录结束状态
+func (m *JobManager) execute(job *models.Job) {
+	m.mu.Lock()
+	handler, ok := m.handlers[job.Type]
+	ctx, cancel := context.WithCancel(context.Background())
+	running := &runningJob{cancel: cancel}
+	m.running[job.ID] = running
+	m.mu.Unlock()
+
+	run := newJobRun(m, job)
+	m.publishStatus(job.UserID, job.ID)
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("未知的任务类型: %s", job.Type)
+	} else {
+		err = runJob(ctx, handler.run, run)
+	}
+
+	m.mu.Lock()
+	delete(m.running, job.ID)
+	cancelled := running.cancelled
+	m.mu.Unlock()
+	cancel()
+
+	status, errMsg := models.JobStatusCompleted, ""
+	switch {
+	case cancelled:
+		status = models.JobStatusCancelled
+	case err != nil && ctx.Err() != nil:
+		// 服务停止，放回等待队列，下次启动时继续执行
+		if err := m.repo.RequeueJob(job.ID); err != nil {
+			log.Printf("Failed to requeue job %s: %v", job.ID, err)
+		}
+		return
+	case err != nil:
+		status, errMsg = models.JobStatusFailed, err.Error()
+	}
+
+	if err := m.repo.FinishJob(job.ID, status, errMsg, run.resultJSON()); err != nil {
+		log.Printf("Failed to finish job %s: %v", job.ID, err)
+	}
+	if ok && handler.cleanup != nil {
+		handler.cleanup(run)
+	}
+	m.publishStatus(job.UserID, job.ID)
+}
+
+// runJob 执行任务，任务panic时视为失败，避免影响worker
+func runJob(ctx context.Context, fn JobFunc, run *JobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", run.Job.ID, r)
+			err = fmt.Errorf("任务执行异常: %v", r)
+		}
+	}()
+	return fn(ctx, run)
+}
+
+// publishStatus 推送任务状态变化（不含明细）
+func (m *JobManager) publishStatus(userID int, jobID string) {
+	job, err := m.repo.GetJob(jobID)
+	if err != nil {
+		return
+	}
+	m.events.Publish(userID, EventJobStatus, job)
+}
+
+// pruneLoop 定期删除超过保留时间的已结束任务
+func (m *JobManager) pruneLoop(stopChan chan struct{}) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(jobPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if m.retention > 0 {
+			if count, err := m.repo.DeleteFinishedJobs(time.Now().Add(-m.retention)); err != nil {
+				log.Printf("Failed to prune finished jobs: %v", err)
+			} else if count > 0 {
+				log.Printf("Pruned %d finished jobs", count)
+			}
+		}
+
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobRun 一次任务执行的上下文，用于读取参数和记录逐项结果
+type JobRun struct {
+	Job     *models.Job
+	manager *JobManager
+
+	mu        sync.Mutex
+	completed map[int]bool
+	result    interface{}
+}
+
+// newJobRun 创建任务执行上下文，加载之前执行时已记录结果的对象
+func newJobRun(m *JobManager, job *models.Job) *JobRun {
+	run := &JobRun{Job: job, manager: m, completed: make(map[int]bool)}
+	if job.Done > 0 {
+		items, err := m.repo.GetJobItems(job.ID)
+		if err != nil {
+			log.Printf("Failed to load items of job %s: %v", job.ID, err)
+		}
+		for _, item := range items {
+			run.completed[item.Index] = true
+		}
+	}
+	return run
+}
+
+// Decode 解析任务参数
+func (r *JobRun) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(r.Job.Payload), v)
+}
+
+// ReadInputs 按序号逐个读取任务输入，fn 返回错误时停止读取并返回该错误
+func (r *JobRun) ReadInputs(fn func(models.JobInput) error) error {
+	after := 0
+	for {
+		inputs, err := r.manager.repo.GetJobInputs(r.Job.ID, after, jobInputPageSize)
+		if err != nil {
+			return err
+		}
+		for _, input := range inputs {
+			if err := fn(input); err != nil {
+				return err
+			}
+			after = input.Index
+		}
+		if len(inputs) < jobInputPageSize {
+			return nil
+		}
+	}
+}
+
+// Completed 对象是否已在之前的执行中记录过结果（服务重启后继续执行时跳过）
+func (r *JobRun) Completed(index int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.completed[index]
+}
+
+// SetTotal 更新需要处理的对象总数
+func (r *JobRun) SetTotal(total int) {
+	r.mu.Lock()
+	r.Job.Total = total
+	r.mu.Unlock()
+
+	if err := r.manager.repo.SetTotal(r.Job.ID, total); err != nil {
+		log.Printf("Failed to update total of job %s: %v", r.Job.ID, err)
+	}
+}
+
+// Record 保存单个对象的处理结果并推送进度，可在多个goroutine中调用
+func (r *JobRun) Record(item models.JobItem) {
+	if err := r.manager.repo.SaveJobItem(r.Job.ID, &item); err != nil {
+		log.Printf("Failed to save item %d of job %s: %v", item.Index, r.Job.ID, err)
+	}
+
+	r.mu.Lock()
+	if !r.completed[item.Index] {
+		r.completed[item.Index] = true
+		r.Job.Done++
+		if item.Success {
+			r.Job.Success++
+		} else {
+			r.Job.Failed++
+		}
+	}
+	progress := BatchProgressEvent{
+		JobID:     r.Job.ID,
+		Operation: r.Job.Type,
+		Total:     r.Job.Total,
+		Done:      r.Job.Done,
+		Success:   r.Job.Success,
+		Failed:    r.Job.Failed,
+	}
+	r.mu.Unlock()
+
+	r.manager.events.Publish(r.Job.UserID, EventBatchProgress, progress)
+}
+
+// Counts 返回已处理、成功和失败的对象数（包括之前执行时记录的）
+func (r *JobRun) Counts() (done, success, failed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Job.Done, r.Job.Success, r.Job.Failed
+}
+
+// Items 获取已记录的全部结果（包括之前执行时记录的），按序号排序
+func (r *JobRun) Items() ([]models.JobItem, error) {
+	return r.manager.repo.GetJobItems(r.Job.ID)
+}
+
+// SetResult 设置任务的汇总结果，任务结束时序列化为JSON保存
+func (r *JobRun) SetResult(result interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = result
+}
+
+// resultJSON 序列化汇总结果
+func (r *JobRun) resultJSON() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.result == nil {
+		return ""
+	}
+	data, err := json.Marshal(r.result)
+	if err != nil {
+		log.Printf("Failed to marshal result of job %s: %v", r.Job.ID, err)
+		return ""
+	}
+	return string(data)
+}
+
+// newJobID 生成随机任务ID
+func newJobID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
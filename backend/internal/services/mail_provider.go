@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// MailProvider 邮件后端，负责使用邮箱凭据读取和清理邮件
+// 返回的错误应使用 UpstreamError 分类，以便统一更新账户状态和映射API错误码。
+// ctx 结束时应取消进行中的请求、不再重试，并返回 ctx.Err()
+type MailProvider interface {
+	// Name 后端名称，与邮箱的 provider 字段对应
+	Name() string
+	// GetLatestMail 获取文件夹中的最新邮件，没有邮件时返回 ErrNoMail
+	GetLatestMail(ctx context.Context, email *models.Email, mailbox string, responseType string) (*models.OutlookMail, error)
+	// GetAllMails 获取文件夹中的全部邮件
+	GetAllMails(ctx context.Context, email *models.Email, mailbox string) ([]models.OutlookMail, error)
+	// ClearInbox 清空收件箱
+	ClearInbox(ctx context.Context, email *models.Email) error
+	// ClearJunk 清空垃圾箱
+	ClearJunk(ctx context.Context, email *models.Email) error
+}
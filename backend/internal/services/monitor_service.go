@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/database"
+	"outlook-helper/backend/internal/models"
+)
+
+// NewMailEvent 新邮件事件
+type NewMailEvent struct {
+	UserID       int                 `json:"user_id"`
+	EmailID      int                 `json:"email_id"`
+	EmailAddress string              `json:"email_address"`
+	Mail         *models.OutlookMail `json:"mail"`
+	DetectedAt   time.Time           `json:"detected_at"`
+}
+
+// NewMailHandler 新邮件事件处理函数
+type NewMailHandler func(event NewMailEvent)
+
+// MonitorStatus 轮询器运行状态
+type MonitorStatus struct {
+	Running         bool       `json:"running"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	Workers         int        `json:"workers"`
+	LastRunAt       *time.Time `json:"last_run_at"`
+	LastRunMillis   int64      `json:"last_run_millis"`
+	LastRunChecked  int        `json:"last_run_checked"`
+}
+
+// MonitorService 后台邮箱轮询服务
+type MonitorService struct {
+	monitorRepo    *database.MonitorRepository
+	emailRepo      *database.EmailRepository
+	logRepo        *database.LogRepository
+	outlookService *OutlookService
+	extractor      *CodeExtractor
+
+	mu       sync.RWMutex
+	interval time.Duration
+	workers  int
+	stopChan chan struct{}
+	handlers []NewMailHandler
+	status   MonitorStatus
+}
+
+// NewMonitorService 创建邮箱轮询服务
+func NewMonitorService(db *database.DB, outlookService *OutlookService, extractor *CodeExtractor, cfg *config.Config) *MonitorService {
+	interval := cfg.MonitorInterval
+	if interval < 10 {
+		interval = 10 // 最小轮询间隔，避免对API造成过大压力
+	}
+	workers := cfg.MonitorWorkers
+	if workers <= 0 {
+		workers = 3 // 默认并发数
+	}
+
+	return &MonitorService{
+		monitorRepo:    db.Monitor,
+		emailRepo:      db.Email,
+		logRepo:        db.Log,
+		outlookService: outlookService,
+		extractor:      extractor,
+		interval:       time.Duration(interval) * time.Second,
+		workers:        workers,
+	}
+}
+
+// OnNewMail 注册新邮件事件处理函数
+func (s *MonitorService) OnNewMail(handler NewMailHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
+}
+
+// Start 启动后台轮询
+func (s *MonitorService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopChan != nil {
+		return
+	}
+
+	s.stopChan = make(chan struct{})
+	s.status.Running = true
+	go s.run(s.stopChan)
+
+	log.Printf("Mail monitor started, interval: %s, workers: %d", s.interval, s.workers)
+}
+
+// Stop 停止后台轮询
+func (s *MonitorService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopChan == nil {
+		return
+	}
+
+	close(s.stopChan)
+	s.stopChan = nil
+	s.status.Running = false
+}
+
+// UpdateSettings 更新轮询间隔和并发数，值为0时保持不变
+func (s *MonitorService) UpdateSettings(intervalSeconds, workers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if intervalSeconds > 0 {
+		s.interval = time.Duration(intervalSeconds) * time.Second
+	}
+	if workers > 0 {
+		s.workers = workers
+	}
+}
+
+// GetStatus 获取轮询器运行状态
+func (s *MonitorService) GetStatus() MonitorStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := s.status
+	status.IntervalSeconds = int(s.interval / time.Second)
+	status.Workers = s.workers
+	return status
+}
+
+// GetUserMonitors 获取用户的邮箱监控列表
+func (s *MonitorService) GetUserMonitors(userID int) ([]models.MailMonitor, error) {
+	return s.monitorRepo.GetMonitorsByUserID(userID)
+}
+
+// SetMonitor 开启或关闭邮箱监控
+func (s *MonitorService) SetMonitor(userID, emailID int, enabled bool, client models.ClientInfo) (*models.MailMonitor, error) {
+	email, err := s.emailRepo.GetEmailByID(emailID)
+	if err != nil {
+		return nil, err
+	}
+	if email.UserID != userID {
+		return nil, errors.New("无权访问此邮箱")
+	}
+
+	if err := s.monitorRepo.SetMonitorEnabled(emailID, enabled); err != nil {
+		return nil, err
+	}
+
+	operation, action := "monitor_enabled", "开启"
+	if !enabled {
+		operation, action = "monitor_disabled", "关闭"
+	}
+	s.logRepo.LogEmail(userID, operation, emailID,
+		fmt.Sprintf("%s邮箱监控: %s", action, email.EmailAddress),
+		client)
+
+	return s.monitorRepo.GetMonitorByEmailID(emailID)
+}
+
+// DeleteMonitor 删除邮箱监控
+func (s *MonitorService) DeleteMonitor(userID, emailID int, client models.ClientInfo) error {
+	monitor, err := s.monitorRepo.GetMonitorByEmailID(emailID)
+	if err != nil {
+		return err
+	}
+	if monitor.UserID != userID {
+		return errors.New("无权访问此邮箱")
+	}
+
+	if err := s.monitorRepo.DeleteMonitor(emailID); err != nil {
+		return err
+	}
+
+	s.logRepo.LogEmail(userID, "monitor_disabled", emailID,
+		fmt.Sprintf("删除邮箱监控: %s", monitor.EmailAddress),
+		client)
+
+	return nil
+}
+
+// run 轮询主循环
+func (s *MonitorService) run(stopChan chan struct{}) {
+	for {
+		s.pollOnce()
+
+		s.mu.RLock()
+		interval := s.interval
+		s.mu.RUnlock()
+
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pollOnce 对所有已开启监控的邮箱执行一次检查
+func (s *MonitorService) pollOnce() {
+	startedAt := time.Now()
+
+	monitors, err := s.monitorRepo.GetEnabledMonitors()
+	if err != nil {
+		log.Printf("Mail monitor: failed to load monitors: %v", err)
+		return
+	}
+
+	s.mu.RLock()
+	maxWorkers := s.workers
+	s.mu.RUnlock()
+
+	// 使用worker pool控制并发度，避免对API造成过大压力
+	taskChan := make(chan models.MailMonitor, len(monitors))
+	var wg sync.WaitGroup
+	for w := 0; w < maxWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for monitor := range taskChan {
+				s.checkMonitor(monitor)
+			}
+		}()
+	}
+
+	for _, monitor := range monitors {
+		taskChan <- monitor
+	}
+	close(taskChan)
+	wg.Wait()
+
+	s.mu.Lock()
+	s.status.LastRunAt = &startedAt
+	s.status.LastRunMillis = time.Since(startedAt).Milliseconds()
+	s.status.LastRunChecked = len(monitors)
+	s.mu.Unlock()
+}
+
+// checkMonitor 检查单个邮箱是否有新邮件
+func (s *MonitorService) checkMonitor(monitor models.MailMonitor) {
+	email, err := s.emailRepo.GetEmailByID(monitor.EmailID)
+	if err != nil {
+		s.monitorRepo.UpdateCheckResult(monitor.EmailID, monitor.LastMailID, err.Error(), false)
+		return
+	}
+
+	mail, err := s.outlookService.GetLatestMail(context.Background(), email, "INBOX", "json")
+	if errors.Is(err, ErrNoMail) {
+		// 收件箱为空，等待下一封邮件
+		s.monitorRepo.UpdateCheckResult(monitor.EmailID, monitor.LastMailID, "", false)
+		return
+	}
+	if err != nil {
+		// 仅在错误内容变化时记录日志，避免轮询刷屏
+		if monitor.LastError != err.Error() {
+			s.logRepo.LogEmail(email.UserID, "monitor_check_failed", email.ID,
+				fmt.Sprintf("邮箱监控检查失败: %v", err), models.ClientInfo{UserAgent: "monitor"})
+		}
+		s.monitorRepo.UpdateCheckResult(monitor.EmailID, monitor.LastMailID, err.Error(), false)
+		return
+	}
+
+	// 首次成功检查只记录基线，不触发新邮件事件；基线时收件箱为空的，之后的第一封邮件就是新邮件
+	newMail := monitor.BaselineTaken && mail.ID != "" && mail.ID != monitor.LastMailID
+	lastMailID := monitor.LastMailID
+	if mail.ID != "" {
+		lastMailID = mail.ID
+	}
+
+	if err := s.monitorRepo.UpdateCheckResult(monitor.EmailID, lastMailID, "", newMail); err != nil {
+		log.Printf("Mail monitor: failed to update monitor %d: %v", monitor.EmailID, err)
+	}
+
+	if !newMail {
+		return
+	}
+
+	// 上游未返回验证码时本地提取
+	s.extractor.Enrich(email.UserID, mail)
+
+	s.logRepo.LogEmail(email.UserID, "new_mail_detected", email.ID,
+		fmt.Sprintf("检测到新邮件，邮箱: %s，主题: %s", email.EmailAddress, mail.Subject),
+		models.ClientInfo{UserAgent: "monitor"})
+
+	event := NewMailEvent{
+		UserID:       email.UserID,
+		EmailID:      email.ID,
+		EmailAddress: email.EmailAddress,
+		Mail:         mail,
+		DetectedAt:   time.Now(),
+	}
+
+	s.mu.RLock()
+	handlers := append([]NewMailHandler(nil), s.handlers...)
+	s.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
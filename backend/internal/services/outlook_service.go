@@ -1,375 +1,193 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"time"
+	"unicode/utf8"
 
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/database"
 	"outlook-helper/backend/internal/models"
 )
 
-// OutlookService Outlook API服务
+// 记录到账户状态中的错误信息最大长度
+const maxLastErrorLength = 500
+
+// OutlookService Outlook邮件服务，根据部署配置或邮箱设置选择邮件后端
 type OutlookService struct {
-	baseURL    string
-	httpClient *http.Client
+	providers       map[string]MailProvider
+	defaultProvider string
+	proxy           *proxyProvider
+	graph           *graphProvider
+	emailRepo       *database.EmailRepository
+
+	// 刷新令牌轮换后的持久化回调
+	tokenRotated func(email *models.Email, oldRefreshToken string)
 }
 
 // NewOutlookService 创建Outlook服务
-func NewOutlookService(baseURL string) *OutlookService {
-	return &OutlookService{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+func NewOutlookService(db *database.DB, cfg *config.Config) *OutlookService {
+	proxy := newProxyProvider(cfg)
+	graph := newGraphProvider(cfg)
+
+	s := &OutlookService{
+		providers: map[string]MailProvider{
+			models.MailProviderProxy: proxy,
+			models.MailProviderGraph: graph,
 		},
-	}
-}
-
-// OutlookAPIResponse Outlook API响应结构
-type OutlookAPIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
-	Error   string      `json:"error,omitempty"`
-}
-
-// MailData 邮件数据结构
-type MailData struct {
-	ID          string    `json:"id"`
-	Subject     string    `json:"subject"`
-	From        string    `json:"from"`
-	To          string    `json:"to"`
-	Body        string    `json:"body"`
-	BodyPreview string    `json:"bodyPreview"`
-	IsRead      bool      `json:"isRead"`
-	ReceivedAt  time.Time `json:"receivedDateTime"`
-	VerifyCode  string    `json:"verifyCode,omitempty"`
-}
-
-// GetLatestMail 获取最新邮件
-func (s *OutlookService) GetLatestMail(email *models.Email, mailbox string, responseType string) (*models.OutlookMail, error) {
-	// 构建请求体
-	requestData := map[string]string{
-		"refresh_token": email.RefreshToken,
-		"client_id":     email.ClientID,
-		"email":         email.EmailAddress,
-		"mailbox":       mailbox,
-	}
-	if responseType != "" {
-		requestData["response_type"] = responseType
+		defaultProvider: cfg.MailProvider,
+		proxy:           proxy,
+		graph:           graph,
+		emailRepo:       db.Email,
 	}
 
-	// 序列化请求体
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, fmt.Errorf("序列化请求数据失败: %v", err)
+	if _, ok := s.providers[s.defaultProvider]; !ok {
+		log.Printf("Unknown mail provider %q, falling back to %s", s.defaultProvider, models.MailProviderProxy)
+		s.defaultProvider = models.MailProviderProxy
 	}
+	graph.onTokenRotated = s.rotateRefreshToken
 
-	requestURL := fmt.Sprintf("%s/api/mail-new", s.baseURL)
+	return s
+}
 
-	// 创建POST请求
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %v", err)
-	}
+// OnTokenRotated 设置刷新令牌轮换后的持久化回调，回调收到已更新令牌的邮箱和旧令牌
+func (s *OutlookService) OnTokenRotated(handler func(email *models.Email, oldRefreshToken string)) {
+	s.tokenRotated = handler
+}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Connection", "keep-alive")
+// rotateRefreshToken 更新邮箱的刷新令牌，已入库的邮箱交由回调持久化；
+// 未入库的邮箱（如添加前验证）保存时会直接写入新令牌
+func (s *OutlookService) rotateRefreshToken(email *models.Email, refreshToken string) {
+	oldRefreshToken := email.RefreshToken
+	now := time.Now()
+	email.RefreshToken = refreshToken
+	email.TokenRefreshedAt = &now
 
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("请求失败: %v", err)
+	if email.ID != 0 && s.tokenRotated != nil {
+		s.tokenRotated(email, oldRefreshToken)
 	}
-	defer resp.Body.Close()
+}
 
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %v", err)
-	}
+// ForgetAccessToken 清除邮箱旧凭据缓存的访问令牌，更新凭据后调用
+func (s *OutlookService) ForgetAccessToken(email *models.Email) {
+	s.graph.forgetToken(email)
+}
 
-	// 检查HTTP状态码
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
+// Start 启动上游端点健康探测
+func (s *OutlookService) Start() {
+	s.proxy.pool.Start()
+}
 
-	// 尝试解析邮件数据，支持两种格式：单个对象或数组
-	var mailData map[string]interface{}
+// Stop 停止上游端点健康探测
+func (s *OutlookService) Stop() {
+	s.proxy.pool.Stop()
+}
 
-	// 首先尝试解析为单个对象
-	if err := json.Unmarshal(body, &mailData); err != nil {
-		// 如果失败，尝试解析为数组格式
-		var mailsArray []map[string]interface{}
-		if arrayErr := json.Unmarshal(body, &mailsArray); arrayErr != nil {
-			// 两种格式都解析失败，记录完整的响应内容
-			return nil, fmt.Errorf("解析响应失败: %v, 响应内容: %s", err, string(body))
-		}
+// DefaultProvider 默认邮件后端
+func (s *OutlookService) DefaultProvider() string {
+	return s.defaultProvider
+}
 
-		// 如果是数组格式，取第一个元素作为最新邮件
-		if len(mailsArray) == 0 {
-			return nil, fmt.Errorf("API返回空邮件数组")
-		}
-		mailData = mailsArray[0]
-	}
+// UpstreamStrategy 上游端点选择策略
+func (s *OutlookService) UpstreamStrategy() string {
+	return s.proxy.pool.Strategy()
+}
 
-	// 解析时间字段
-	var receivedAt time.Time
-	if dateStr := getStringFromMap(mailData, "date"); dateStr != "" {
-		if parsedTime, err := time.Parse(time.RFC3339, dateStr); err == nil {
-			receivedAt = parsedTime
-		}
-	}
+// UpstreamStats 获取上游端点统计
+func (s *OutlookService) UpstreamStats() []EndpointStats {
+	return s.proxy.pool.Stats()
+}
 
-	// 优先获取HTML格式的邮件内容，如果没有则使用text字段
-	mailBody := getStringFromMap(mailData, "html")
-	if mailBody == "" {
-		mailBody = getStringFromMap(mailData, "text")
-	}
+// UpstreamSummary 获取上游端点状态摘要
+func (s *OutlookService) UpstreamSummary() []EndpointSummary {
+	return s.proxy.pool.Summary()
+}
 
-	mail := &models.OutlookMail{
-		ID:         getStringFromMap(mailData, "id"),
-		Subject:    getStringFromMap(mailData, "subject"),
-		From:       getStringFromMap(mailData, "send"), // API返回的字段名是"send"
-		To:         getStringFromMap(mailData, "to"),
-		Body:       mailBody, // 优先使用HTML格式，否则使用text字段
-		IsRead:     getBoolFromMap(mailData, "isRead"),
-		VerifyCode: getStringFromMap(mailData, "verifyCode"),
-		ReceivedAt: receivedAt,
-	}
+// ProbeUpstreams 立即探测所有上游端点
+func (s *OutlookService) ProbeUpstreams() {
+	s.proxy.pool.Probe()
+}
 
-	// 解析时间
-	if receivedAtStr := getStringFromMap(mailData, "receivedDateTime"); receivedAtStr != "" {
-		if parsedTime, err := time.Parse(time.RFC3339, receivedAtStr); err == nil {
-			mail.ReceivedAt = parsedTime
+// providerFor 选择邮箱使用的邮件后端，邮箱未单独设置时使用默认后端
+func (s *OutlookService) providerFor(email *models.Email) MailProvider {
+	if email != nil && email.Provider != "" {
+		if provider, ok := s.providers[email.Provider]; ok {
+			return provider
 		}
 	}
+	return s.providers[s.defaultProvider]
+}
 
-	return mail, nil
+// GetLatestMail 获取最新邮件
+func (s *OutlookService) GetLatestMail(ctx context.Context, email *models.Email, mailbox string, responseType string) (*models.OutlookMail, error) {
+	mail, err := s.providerFor(email).GetLatestMail(ctx, email, mailbox, responseType)
+	s.recordHealth(email, err)
+	return mail, err
 }
 
 // GetAllMails 获取全部邮件
-func (s *OutlookService) GetAllMails(email *models.Email, mailbox string) ([]models.OutlookMail, error) {
-	// 构建请求体
-	requestData := map[string]string{
-		"refresh_token": email.RefreshToken,
-		"client_id":     email.ClientID,
-		"email":         email.EmailAddress,
-		"mailbox":       mailbox,
-	}
-
-	// 序列化请求体
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, fmt.Errorf("序列化请求数据失败: %v", err)
-	}
-
-	requestURL := fmt.Sprintf("%s/api/mail-all", s.baseURL)
-
-	// 创建POST请求
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %v", err)
-	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Connection", "keep-alive")
-
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %v", err)
-	}
-
-	// 检查HTTP状态码
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
-
-	// 直接解析邮件数组（API直接返回邮件对象数组，不包装在通用响应中）
-	var mailsData []map[string]interface{}
-	if err := json.Unmarshal(body, &mailsData); err != nil {
-		// 记录完整的响应内容以便调试
-		return nil, fmt.Errorf("解析响应失败: %v, 响应内容: %s", err, string(body))
-	}
-
-	var mails []models.OutlookMail
-	for _, mailData := range mailsData {
-		// 解析时间字段
-		var receivedAt time.Time
-		if dateStr := getStringFromMap(mailData, "date"); dateStr != "" {
-			if parsedTime, err := time.Parse(time.RFC3339, dateStr); err == nil {
-				receivedAt = parsedTime
-			}
-		}
-
-		// 优先获取HTML格式的邮件内容，如果没有则使用text字段
-		mailBody := getStringFromMap(mailData, "html")
-		if mailBody == "" {
-			mailBody = getStringFromMap(mailData, "text")
-		}
-
-		mail := models.OutlookMail{
-			ID:         getStringFromMap(mailData, "id"),
-			Subject:    getStringFromMap(mailData, "subject"),
-			From:       getStringFromMap(mailData, "send"), // API返回的字段名是"send"
-			To:         getStringFromMap(mailData, "to"),
-			Body:       mailBody, // 优先使用HTML格式，否则使用text字段
-			IsRead:     getBoolFromMap(mailData, "isRead"),
-			VerifyCode: getStringFromMap(mailData, "verifyCode"),
-			ReceivedAt: receivedAt,
-		}
-
-		mails = append(mails, mail)
-	}
-
-	return mails, nil
+func (s *OutlookService) GetAllMails(ctx context.Context, email *models.Email, mailbox string) ([]models.OutlookMail, error) {
+	mails, err := s.providerFor(email).GetAllMails(ctx, email, mailbox)
+	s.recordHealth(email, err)
+	return mails, err
 }
 
-// ClearInbox 清空收件箱
-func (s *OutlookService) ClearInbox(email *models.Email) error {
-	// 构建请求体
-	requestData := map[string]string{
-		"refresh_token": email.RefreshToken,
-		"client_id":     email.ClientID,
-		"email":         email.EmailAddress,
-	}
-
-	// 序列化请求体
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return fmt.Errorf("序列化请求数据失败: %v", err)
-	}
-
-	requestURL := fmt.Sprintf("%s/api/process-inbox", s.baseURL)
-
-	// 创建POST请求
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("创建请求失败: %v", err)
-	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Connection", "keep-alive")
-
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应失败: %v", err)
-	}
-
-	// 检查HTTP状态码
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
+// ClearInbox 清空收件箱，ctx 结束时取消进行中的请求
+func (s *OutlookService) ClearInbox(ctx context.Context, email *models.Email) error {
+	err := s.providerFor(email).ClearInbox(ctx, email)
+	s.recordHealth(email, err)
+	return err
+}
 
-	// 解析响应（API直接返回消息对象）
-	var response map[string]interface{}
-	if err := json.Unmarshal(body, &response); err != nil {
-		// 记录完整的响应内容以便调试
-		return fmt.Errorf("解析响应失败: %v, 响应内容: %s", err, string(body))
-	}
+// ClearJunk 清空垃圾箱，ctx 结束时取消进行中的请求
+func (s *OutlookService) ClearJunk(ctx context.Context, email *models.Email) error {
+	err := s.providerFor(email).ClearJunk(ctx, email)
+	s.recordHealth(email, err)
+	return err
+}
 
-	// 检查是否有错误消息
-	if errorMsg := getStringFromMap(response, "error"); errorMsg != "" {
-		return fmt.Errorf("API返回错误: %s", errorMsg)
+// ValidateEmailCredentials 验证邮箱凭据
+func (s *OutlookService) ValidateEmailCredentials(ctx context.Context, email *models.Email) error {
+	// 尝试获取最新邮件来验证凭据，邮箱为空时凭据仍然有效
+	_, err := s.GetLatestMail(ctx, email, "INBOX", "json")
+	if err != nil && !errors.Is(err, ErrNoMail) {
+		// 为验证失败提供更详细的错误信息
+		return fmt.Errorf("验证邮箱 %s 凭据失败: %w", email.EmailAddress, err)
 	}
-
 	return nil
 }
 
-// ClearJunk 清空垃圾箱
-func (s *OutlookService) ClearJunk(email *models.Email) error {
-	// 构建请求体
-	requestData := map[string]string{
-		"refresh_token": email.RefreshToken,
-		"client_id":     email.ClientID,
-		"email":         email.EmailAddress,
+// recordHealth 根据上游调用结果更新账户状态，未入库的邮箱（如添加前验证）不记录
+func (s *OutlookService) recordHealth(email *models.Email, err error) {
+	if s.emailRepo == nil || email == nil || email.ID == 0 {
+		return
 	}
-
-	// 序列化请求体
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return fmt.Errorf("序列化请求数据失败: %v", err)
-	}
-
-	requestURL := fmt.Sprintf("%s/api/process-junk", s.baseURL)
-
-	// 创建POST请求
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("创建请求失败: %v", err)
+	// 熔断期间请求未发送到上游，调用方取消的请求没有结果，都不影响账户状态
+	if errors.Is(err, ErrCircuitOpen) || isCanceled(err) {
+		return
 	}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Connection", "keep-alive")
-
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("请求失败: %v", err)
+	// 没有邮件和未完全清空时上游请求都已成功，账户正常
+	var updateErr error
+	if err == nil || errors.Is(err, ErrNoMail) || errors.Is(err, ErrPartiallyCleared) {
+		updateErr = s.emailRepo.MarkEmailHealthy(email.ID)
+	} else {
+		updateErr = s.emailRepo.MarkEmailFailed(email.ID, classifyUpstreamError(err), truncateError(err.Error()))
 	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("读取响应失败: %v", err)
+	if updateErr != nil {
+		log.Printf("Failed to update status for email %d: %v", email.ID, updateErr)
 	}
-
-	// 检查HTTP状态码
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
-
-	// 解析响应（API直接返回消息对象）
-	var response map[string]interface{}
-	if err := json.Unmarshal(body, &response); err != nil {
-		// 记录完整的响应内容以便调试
-		return fmt.Errorf("解析响应失败: %v, 响应内容: %s", err, string(body))
-	}
-
-	// 检查是否有错误消息
-	if errorMsg := getStringFromMap(response, "error"); errorMsg != "" {
-		return fmt.Errorf("API返回错误: %s", errorMsg)
-	}
-
-	return nil
 }
 
-// ValidateEmailCredentials 验证邮箱凭据
-func (s *OutlookService) ValidateEmailCredentials(email *models.Email) error {
-	// 尝试获取最新邮件来验证凭据
-	_, err := s.GetLatestMail(email, "INBOX", "json")
-	if err != nil {
-		// 为验证失败提供更详细的错误信息
-		return fmt.Errorf("验证邮箱 %s 凭据失败: %v", email.EmailAddress, err)
+// truncateError 截断过长的错误信息
+func truncateError(message string) string {
+	if utf8.RuneCountInString(message) <= maxLastErrorLength {
+		return message
 	}
-	return nil
+	return string([]rune(message)[:maxLastErrorLength]) + "..."
 }
 
 // 辅助函数
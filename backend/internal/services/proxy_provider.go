@@ -0,0 +1,367 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/models"
+)
+
+// proxyProvider 通过外部 msOauth2api 代理服务读取邮件
+type proxyProvider struct {
+	pool       *UpstreamPool
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// newProxyProvider 创建代理邮件后端
+func newProxyProvider(cfg *config.Config) *proxyProvider {
+	return &proxyProvider{
+		pool: NewUpstreamPool(cfg),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		retry: NewRetryPolicy(cfg),
+	}
+}
+
+// Name 后端名称
+func (p *proxyProvider) Name() string {
+	return models.MailProviderProxy
+}
+
+// OutlookAPIResponse Outlook API响应结构
+type OutlookAPIResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// MailData 邮件数据结构
+type MailData struct {
+	ID          string    `json:"id"`
+	Subject     string    `json:"subject"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Body        string    `json:"body"`
+	BodyPreview string    `json:"bodyPreview"`
+	IsRead      bool      `json:"isRead"`
+	ReceivedAt  time.Time `json:"receivedDateTime"`
+	VerifyCode  string    `json:"verifyCode,omitempty"`
+}
+
+// GetLatestMail 获取最新邮件
+func (p *proxyProvider) GetLatestMail(ctx context.Context, email *models.Email, mailbox string, responseType string) (*models.OutlookMail, error) {
+	// 构建请求体
+	requestData := map[string]string{
+		"refresh_token": email.RefreshToken,
+		"client_id":     email.ClientID,
+		"email":         email.EmailAddress,
+		"mailbox":       mailbox,
+	}
+	if responseType != "" {
+		requestData["response_type"] = responseType
+	}
+
+	body, err := p.post(ctx, "/api/mail-new", requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 尝试解析邮件数据，支持两种格式：单个对象或数组
+	var mailData map[string]interface{}
+
+	// 首先尝试解析为单个对象
+	if err := json.Unmarshal(body, &mailData); err != nil {
+		// 如果失败，尝试解析为数组格式
+		var mailsArray []map[string]interface{}
+		if arrayErr := json.Unmarshal(body, &mailsArray); arrayErr != nil {
+			// 两种格式都解析失败，记录完整的响应内容
+			return nil, newBadResponseError(err, body)
+		}
+
+		// 如果是数组格式，取第一个元素作为最新邮件
+		if len(mailsArray) == 0 {
+			return nil, ErrNoMail
+		}
+		mailData = mailsArray[0]
+	}
+
+	// 解析时间字段
+	var receivedAt time.Time
+	if dateStr := getStringFromMap(mailData, "date"); dateStr != "" {
+		if parsedTime, err := time.Parse(time.RFC3339, dateStr); err == nil {
+			receivedAt = parsedTime
+		}
+	}
+
+	// 优先获取HTML格式的邮件内容，如果没有则使用text字段
+	mailBody := getStringFromMap(mailData, "html")
+	if mailBody == "" {
+		mailBody = getStringFromMap(mailData, "text")
+	}
+
+	mail := &models.OutlookMail{
+		ID:         getStringFromMap(mailData, "id"),
+		Subject:    getStringFromMap(mailData, "subject"),
+		From:       getStringFromMap(mailData, "send"), // API返回的字段名是"send"
+		To:         getStringFromMap(mailData, "to"),
+		Body:       mailBody, // 优先使用HTML格式，否则使用text字段
+		IsRead:     getBoolFromMap(mailData, "isRead"),
+		VerifyCode: getStringFromMap(mailData, "verifyCode"),
+		ReceivedAt: receivedAt,
+	}
+
+	// 解析时间
+	if receivedAtStr := getStringFromMap(mailData, "receivedDateTime"); receivedAtStr != "" {
+		if parsedTime, err := time.Parse(time.RFC3339, receivedAtStr); err == nil {
+			mail.ReceivedAt = parsedTime
+		}
+	}
+
+	return mail, nil
+}
+
+// GetAllMails 获取全部邮件
+func (p *proxyProvider) GetAllMails(ctx context.Context, email *models.Email, mailbox string) ([]models.OutlookMail, error) {
+	// 构建请求体
+	requestData := map[string]string{
+		"refresh_token": email.RefreshToken,
+		"client_id":     email.ClientID,
+		"email":         email.EmailAddress,
+		"mailbox":       mailbox,
+	}
+
+	body, err := p.post(ctx, "/api/mail-all", requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 直接解析邮件数组（API直接返回邮件对象数组，不包装在通用响应中）
+	var mailsData []map[string]interface{}
+	if err := json.Unmarshal(body, &mailsData); err != nil {
+		// 记录完整的响应内容以便调试
+		return nil, newBadResponseError(err, body)
+	}
+
+	var mails []models.OutlookMail
+	for _, mailData := range mailsData {
+		// 解析时间字段
+		var receivedAt time.Time
+		if dateStr := getStringFromMap(mailData, "date"); dateStr != "" {
+			if parsedTime, err := time.Parse(time.RFC3339, dateStr); err == nil {
+				receivedAt = parsedTime
+			}
+		}
+
+		// 优先获取HTML格式的邮件内容，如果没有则使用text字段
+		mailBody := getStringFromMap(mailData, "html")
+		if mailBody == "" {
+			mailBody = getStringFromMap(mailData, "text")
+		}
+
+		mail := models.OutlookMail{
+			ID:         getStringFromMap(mailData, "id"),
+			Subject:    getStringFromMap(mailData, "subject"),
+			From:       getStringFromMap(mailData, "send"), // API返回的字段名是"send"
+			To:         getStringFromMap(mailData, "to"),
+			Body:       mailBody, // 优先使用HTML格式，否则使用text字段
+			IsRead:     getBoolFromMap(mailData, "isRead"),
+			VerifyCode: getStringFromMap(mailData, "verifyCode"),
+			ReceivedAt: receivedAt,
+		}
+
+		mails = append(mails, mail)
+	}
+
+	return mails, nil
+}
+
+// ClearInbox 清空收件箱
+func (p *proxyProvider) ClearInbox(ctx context.Context, email *models.Email) error {
+	// 构建请求体
+	requestData := map[string]string{
+		"refresh_token": email.RefreshToken,
+		"client_id":     email.ClientID,
+		"email":         email.EmailAddress,
+	}
+
+	body, err := p.post(ctx, "/api/process-inbox", requestData)
+	if err != nil {
+		return err
+	}
+
+	// 解析响应（API直接返回消息对象）
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		// 记录完整的响应内容以便调试
+		return newBadResponseError(err, body)
+	}
+
+	// 检查是否有错误消息
+	if errorMsg := getStringFromMap(response, "error"); errorMsg != "" {
+		return parsePayloadError(errorMsg)
+	}
+
+	return nil
+}
+
+// ClearJunk 清空垃圾箱
+func (p *proxyProvider) ClearJunk(ctx context.Context, email *models.Email) error {
+	// 构建请求体
+	requestData := map[string]string{
+		"refresh_token": email.RefreshToken,
+		"client_id":     email.ClientID,
+		"email":         email.EmailAddress,
+	}
+
+	body, err := p.post(ctx, "/api/process-junk", requestData)
+	if err != nil {
+		return err
+	}
+
+	// 解析响应（API直接返回消息对象）
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		// 记录完整的响应内容以便调试
+		return newBadResponseError(err, body)
+	}
+
+	// 检查是否有错误消息
+	if errorMsg := getStringFromMap(response, "error"); errorMsg != "" {
+		return parsePayloadError(errorMsg)
+	}
+
+	return nil
+}
+
+// post 向上游发送POST请求：按策略选择端点，端点失败时立即切换到下一个端点，
+// 所有端点都尝试过后再按重试策略退避重试。总尝试次数不少于端点数量。
+// ctx 结束时取消进行中的请求并停止重试，取消的请求不计入端点统计和熔断
+func (p *proxyProvider) post(ctx context.Context, path string, payload map[string]string) ([]byte, error) {
+	// 序列化请求体
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求数据失败: %v", err)
+	}
+
+	candidates := p.pool.candidates()
+	if len(candidates) == 0 {
+		return nil, &UpstreamError{Kind: ErrUpstreamUnavailable, Message: "未配置Outlook API地址"}
+	}
+
+	maxAttempts := p.retry.MaxAttempts
+	if maxAttempts < len(candidates) {
+		maxAttempts = len(candidates)
+	}
+
+	var lastErr error
+	var circuitWait time.Duration
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		endpoint := candidates[(attempt-1)%len(candidates)]
+
+		// 熔断中的端点直接跳过
+		if wait, ok := endpoint.breaker.Allow(); !ok {
+			if circuitWait == 0 || wait < circuitWait {
+				circuitWait = wait
+			}
+			continue
+		}
+
+		start := time.Now()
+		body, err := p.doPost(ctx, endpoint.url+path, jsonData)
+		if isCanceled(err) {
+			endpoint.breaker.Release()
+			return nil, err
+		}
+		p.pool.record(endpoint, err, time.Since(start))
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+
+		if attempt >= maxAttempts || !p.retry.ShouldRetry(err) {
+			return nil, err
+		}
+
+		// 还有未尝试的端点时立即故障转移
+		if attempt < len(candidates) {
+			log.Printf("Outlook API %s failed on %s, failing over: %v", path, endpoint.name, err)
+			continue
+		}
+
+		// 限流时优先使用上游建议的等待时间，超过上限则不再重试
+		delay := p.retry.Backoff(attempt - len(candidates) + 1)
+		if retryAfter := RetryAfterOf(err); retryAfter > delay {
+			if retryAfter > p.retry.MaxDelay {
+				return nil, err
+			}
+			delay = retryAfter
+		}
+
+		log.Printf("Outlook API %s attempt %d/%d failed on %s, retrying in %v: %v", path, attempt, maxAttempts, endpoint.name, delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	// 所有端点都处于熔断状态
+	return nil, &UpstreamError{
+		Kind:       ErrUpstreamUnavailable,
+		Message:    "上游服务熔断中，请稍后重试",
+		RetryAfter: circuitWait,
+		Cause:      ErrCircuitOpen,
+	}
+}
+
+// doPost 发送单次POST请求，非200响应转换为上游错误，ctx 结束时返回 ctx.Err()
+func (p *proxyProvider) doPost(ctx context.Context, requestURL string, jsonData []byte) ([]byte, error) {
+	// 创建POST请求
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Connection", "keep-alive")
+
+	// 发送请求
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("请求失败", err)
+	}
+	defer resp.Body.Close()
+
+	// 读取响应
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, newUnavailableError("读取响应失败", err)
+	}
+
+	// 检查HTTP状态码
+	if resp.StatusCode != http.StatusOK {
+		return nil, parseErrorResponse(resp, body)
+	}
+
+	return body, nil
+}
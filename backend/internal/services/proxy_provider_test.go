@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+	"outlook-helper/backend/internal/models"
+)
+
+// 半开状态的探测请求被取消后，端点应允许再次探测
+func TestProxyProviderCancelledProbeReleasesBreaker(t *testing.T) {
+	// 0: 返回500  1: 挂起直到请求取消  2: 返回成功
+	var mode atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能感知客户端断开
+		io.Copy(io.Discard, r.Body)
+		switch mode.Load() {
+		case 0:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 1:
+			<-r.Context().Done()
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	p := newProxyProvider(&config.Config{
+		OutlookAPI:              srv.URL,
+		OutlookRetryMaxAttempts: 1,
+		OutlookRetryStatuses:    "500",
+		OutlookBreakerThreshold: 1,
+		OutlookBreakerCooldown:  60,
+	})
+	email := &models.Email{EmailAddress: "user@example.com", ClientID: "client", RefreshToken: "token"}
+	breaker := p.pool.endpoints[0].breaker
+
+	if err := p.ClearInbox(context.Background(), email); err == nil {
+		t.Fatal("expected upstream error")
+	}
+	if state := breaker.Status().State; state != BreakerOpen {
+		t.Fatalf("breaker state = %s, want %s", state, BreakerOpen)
+	}
+
+	// 跳过冷却时间
+	breaker.mu.Lock()
+	breaker.openedAt = time.Now().Add(-time.Hour)
+	breaker.mu.Unlock()
+
+	mode.Store(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.ClearInbox(ctx, email); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled probe error = %v, want context.DeadlineExceeded", err)
+	}
+
+	mode.Store(2)
+	if err := p.ClearInbox(context.Background(), email); err != nil {
+		t.Fatalf("second probe error = %v, want nil", err)
+	}
+	if state := breaker.Status().State; state != BreakerClosed {
+		t.Fatalf("breaker state = %s, want %s", state, BreakerClosed)
+	}
+}
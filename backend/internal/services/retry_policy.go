@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+)
+
+// RetryPolicy 上游请求重试策略
+type RetryPolicy struct {
+	MaxAttempts       int           // 最大尝试次数（含首次请求）
+	BaseDelay         time.Duration // 首次重试等待时间，之后按指数增长
+	MaxDelay          time.Duration // 单次等待时间上限
+	Jitter            float64       // 随机抖动比例（0~1）
+	RetryableStatuses map[int]bool  // 可重试的上游HTTP状态码
+}
+
+// NewRetryPolicy 根据配置创建重试策略
+func NewRetryPolicy(cfg *config.Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:       cfg.OutlookRetryMaxAttempts,
+		BaseDelay:         time.Duration(cfg.OutlookRetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:          time.Duration(cfg.OutlookRetryMaxDelayMs) * time.Millisecond,
+		Jitter:            float64(cfg.OutlookRetryJitterPercent) / 100,
+		RetryableStatuses: parseStatusList(cfg.OutlookRetryStatuses),
+	}
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	if policy.Jitter < 0 {
+		policy.Jitter = 0
+	} else if policy.Jitter > 1 {
+		policy.Jitter = 1
+	}
+
+	return policy
+}
+
+// Backoff 计算第attempt次失败后的等待时间（指数退避 + 随机抖动）
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 && delay > 0 {
+		spread := float64(delay) * p.Jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// sleepContext 等待 delay，ctx 结束时提前返回 ctx.Err()
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShouldRetry 判断错误是否可以重试：网络错误和配置的状态码可重试，令牌失效等账户错误不重试
+func (p RetryPolicy) ShouldRetry(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrInvalidGrant) || errors.Is(err, ErrAccountLocked) {
+		return false
+	}
+
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	if upstreamErr.StatusCode == 0 {
+		return upstreamErr.Kind == ErrUpstreamUnavailable
+	}
+	return p.RetryableStatuses[upstreamErr.StatusCode]
+}
+
+// parseStatusList 解析逗号分隔的状态码列表
+func parseStatusList(value string) map[int]bool {
+	statuses := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		if code, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			statuses[code] = true
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// 上游错误类型，可通过 errors.Is 判断
+var (
+	ErrInvalidGrant        = errors.New("刷新令牌无效或已过期")
+	ErrAccountLocked       = errors.New("账户已被锁定或禁用")
+	ErrRateLimited         = errors.New("上游请求过于频繁")
+	ErrUpstreamUnavailable = errors.New("上游服务不可用")
+	ErrBadResponse         = errors.New("上游响应格式错误")
+	ErrUpstreamRejected    = errors.New("上游拒绝请求")
+	ErrNoMail              = errors.New("邮箱中没有邮件")
+	ErrPartiallyCleared    = errors.New("文件夹未完全清空")
+)
+
+// 令牌失效的上游错误特征
+var invalidGrantMarkers = []string{
+	"invalid_grant",
+	"invalid_client",
+	"unauthorized_client",
+	"AADSTS70000",  // 授权无效
+	"AADSTS70008",  // 刷新令牌已过期
+	"AADSTS700082", // 刷新令牌长期未使用已过期
+	"AADSTS50173",  // 密码变更导致令牌失效
+	"AADSTS54005",  // 授权码已被使用
+	"AADSTS700016", // 应用不存在（client_id无效）
+}
+
+// 账户锁定或封禁的上游错误特征，只匹配 Microsoft 的错误代码和原文，
+// 避免代理或WAF返回的 blocked、suspended 等字样把账户误标为锁定
+var accountLockedMarkers = []string{
+	"AADSTS50053", // 账户被锁定
+	"AADSTS50057", // 账户已禁用
+	"AADSTS53003", // 被条件访问策略阻止
+	"account is locked",
+	"user account is disabled",
+}
+
+// 上游限流的错误特征
+var rateLimitMarkers = []string{
+	"throttl",
+	"too many requests",
+	"rate limit",
+	"ApplicationThrottled",
+	"MailboxConcurrency",
+}
+
+// UpstreamError 上游调用错误
+type UpstreamError struct {
+	Kind       error         // 错误类型（ErrInvalidGrant 等）
+	StatusCode int           // 上游HTTP状态码，请求未完成时为0
+	Message    string        // 错误描述
+	RetryAfter time.Duration // 限流时上游建议的等待时间
+	Cause      error         // 底层错误（如网络错误）
+}
+
+// Error 实现 error 接口
+func (e *UpstreamError) Error() string {
+	return e.Message
+}
+
+// Unwrap 支持 errors.Is 同时匹配错误类型和底层错误
+func (e *UpstreamError) Unwrap() []error {
+	if e.Cause != nil {
+		return []error{e.Kind, e.Cause}
+	}
+	return []error{e.Kind}
+}
+
+// isCanceled 判断是否因调用方取消（ctx 结束）而中止。上游请求超时等错误包装在 UpstreamError 中，不算取消；
+// 取消不反映上游或账户的状态，不计入熔断和账户健康状态
+func isCanceled(err error) bool {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return false
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// newUnavailableError 网络错误等请求未完成的情况
+func newUnavailableError(action string, cause error) error {
+	return &UpstreamError{
+		Kind:    ErrUpstreamUnavailable,
+		Message: fmt.Sprintf("%s: %v", action, cause),
+		Cause:   cause,
+	}
+}
+
+// newBadResponseError 上游响应无法解析
+func newBadResponseError(cause error, body []byte) error {
+	return &UpstreamError{
+		Kind:       ErrBadResponse,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("解析响应失败: %v, 响应内容: %s", cause, string(body)),
+		Cause:      cause,
+	}
+}
+
+// parseErrorResponse 根据上游非200响应的状态码和错误内容判断错误类型
+func parseErrorResponse(resp *http.Response, body []byte) error {
+	upstreamErr := &UpstreamError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("API请求失败，状态码: %d, 响应: %s", resp.StatusCode, string(body)),
+	}
+
+	if kind := classifyErrorText(upstreamErrorText(body)); kind != nil {
+		upstreamErr.Kind = kind
+	} else {
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			upstreamErr.Kind = ErrRateLimited
+		case resp.StatusCode == http.StatusUnauthorized:
+			upstreamErr.Kind = ErrInvalidGrant
+		case resp.StatusCode >= http.StatusInternalServerError:
+			upstreamErr.Kind = ErrUpstreamUnavailable
+		default:
+			upstreamErr.Kind = ErrUpstreamRejected
+		}
+	}
+
+	if upstreamErr.Kind == ErrRateLimited {
+		upstreamErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+
+	return upstreamErr
+}
+
+// parsePayloadError 上游返回200但响应中包含错误信息
+func parsePayloadError(message string) error {
+	kind := classifyErrorText(message)
+	if kind == nil {
+		kind = ErrUpstreamRejected
+	}
+	return &UpstreamError{
+		Kind:       kind,
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("API返回错误: %s", message),
+	}
+}
+
+// upstreamErrorText 提取上游错误内容，JSON格式时拼接 error、error_description、message 字段
+func upstreamErrorText(body []byte) string {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return string(body)
+	}
+
+	var parts []string
+	for _, key := range []string{"error", "error_description", "message", "detail"} {
+		switch value := payload[key].(type) {
+		case string:
+			parts = append(parts, value)
+		case map[string]interface{}:
+			// Graph 风格：{"error": {"code": "...", "message": "..."}}
+			for _, nested := range []string{"code", "message"} {
+				if str, ok := value[nested].(string); ok {
+					parts = append(parts, str)
+				}
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return string(body)
+	}
+	return strings.Join(parts, " ")
+}
+
+// classifyErrorText 根据错误内容中的特征判断错误类型，无法判断时返回nil
+func classifyErrorText(text string) error {
+	text = strings.ToLower(text)
+	if containsAny(text, invalidGrantMarkers) {
+		return ErrInvalidGrant
+	}
+	if containsAny(text, accountLockedMarkers) {
+		return ErrAccountLocked
+	}
+	if containsAny(text, rateLimitMarkers) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// containsAny 判断文本是否包含任意特征（不区分大小写，text需已转为小写）
+func containsAny(text string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(text, strings.ToLower(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter 解析 Retry-After 头（秒数或HTTP日期）
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// RetryAfterOf 获取限流错误建议的等待时间，无建议时返回0
+func RetryAfterOf(err error) time.Duration {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.RetryAfter
+	}
+	return 0
+}
+
+// classifyUpstreamError 根据上游错误判断账户状态，无法判断（如网络错误）时返回空字符串
+func classifyUpstreamError(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidGrant):
+		return models.EmailStatusInvalidToken
+	case errors.Is(err, ErrAccountLocked):
+		return models.EmailStatusLocked
+	}
+	return ""
+}
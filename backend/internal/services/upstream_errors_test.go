@@ -0,0 +1,27 @@
+package services
+
+import "testing"
+
+func TestClassifyErrorText(t *testing.T) {
+	tests := []struct {
+		text string
+		want error
+	}{
+		{`{"error":"invalid_grant","error_description":"AADSTS70008: The refresh token has expired"}`, ErrInvalidGrant},
+		{"AADSTS50053: The account is locked because the user tried to sign in too many times", ErrAccountLocked},
+		{"AADSTS50057: The user account is disabled.", ErrAccountLocked},
+		{"Your account is locked. Contact your administrator", ErrAccountLocked},
+		{"The user account is disabled", ErrAccountLocked},
+		{"Too Many Requests", ErrRateLimited},
+		// 代理和WAF的提示不能当作账户被锁定
+		{"Request blocked by web application firewall", nil},
+		{"Proxy service suspended, please renew your plan", nil},
+		{"upstream connection locked by another request", nil},
+	}
+
+	for _, tt := range tests {
+		if got := classifyErrorText(tt.text); got != tt.want {
+			t.Errorf("classifyErrorText(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"outlook-helper/backend/internal/config"
+)
+
+// 上游端点选择策略
+const (
+	StrategyRoundRobin    = "round_robin"    // 平滑加权轮询
+	StrategyLeastFailures = "least_failures" // 优先选择失败最少的端点
+)
+
+// 健康探测请求超时时间
+const probeTimeout = 10 * time.Second
+
+// upstreamEndpoint 单个上游端点
+type upstreamEndpoint struct {
+	name    string
+	url     string
+	weight  int
+	breaker *CircuitBreaker
+
+	// 以下字段由 UpstreamPool.mu 保护
+	currentWeight int
+	healthy       bool
+	requests      int64
+	successes     int64
+	failures      int64
+	totalLatency  time.Duration
+	lastLatency   time.Duration
+	lastError     string
+	lastSuccessAt *time.Time
+	lastFailureAt *time.Time
+	lastProbeAt   *time.Time
+}
+
+// EndpointStats 上游端点统计
+type EndpointStats struct {
+	Name          string        `json:"name"`
+	URL           string        `json:"url"`
+	Weight        int           `json:"weight"`
+	Healthy       bool          `json:"healthy"`
+	Breaker       BreakerStatus `json:"circuit_breaker"`
+	Requests      int64         `json:"requests"`
+	Successes     int64         `json:"successes"`
+	Failures      int64         `json:"failures"`
+	SuccessRate   float64       `json:"success_rate"`
+	AvgLatencyMs  int64         `json:"avg_latency_ms"`
+	LastLatencyMs int64         `json:"last_latency_ms"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastSuccessAt *time.Time    `json:"last_success_at,omitempty"`
+	LastFailureAt *time.Time    `json:"last_failure_at,omitempty"`
+	LastProbeAt   *time.Time    `json:"last_probe_at,omitempty"`
+}
+
+// EndpointSummary 上游端点状态摘要（用于公开的健康检查接口，不包含地址）
+type EndpointSummary struct {
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Healthy bool   `json:"healthy"`
+}
+
+// UpstreamPool 上游端点池：负责端点选择、故障统计和健康探测
+type UpstreamPool struct {
+	endpoints     []*upstreamEndpoint
+	strategy      string
+	probeInterval time.Duration
+	probeClient   *http.Client
+
+	mu       sync.Mutex
+	stopChan chan struct{}
+}
+
+// NewUpstreamPool 根据配置创建上游端点池
+func NewUpstreamPool(cfg *config.Config) *UpstreamPool {
+	strategy := cfg.OutlookAPIStrategy
+	if strategy != StrategyLeastFailures {
+		strategy = StrategyRoundRobin
+	}
+
+	pool := &UpstreamPool{
+		strategy:      strategy,
+		probeInterval: time.Duration(cfg.OutlookAPIProbeInterval) * time.Second,
+		probeClient:   &http.Client{Timeout: probeTimeout},
+	}
+
+	for i, endpoint := range parseEndpoints(cfg.OutlookAPIEndpoints, cfg.OutlookAPI) {
+		name := fmt.Sprintf("endpoint-%d", i+1)
+		pool.endpoints = append(pool.endpoints, &upstreamEndpoint{
+			name:    name,
+			url:     endpoint.url,
+			weight:  endpoint.weight,
+			healthy: true,
+			breaker: NewCircuitBreaker(name, cfg.OutlookBreakerThreshold,
+				time.Duration(cfg.OutlookBreakerCooldown)*time.Second),
+		})
+	}
+
+	return pool
+}
+
+// Size 端点数量
+func (p *UpstreamPool) Size() int {
+	return len(p.endpoints)
+}
+
+// Strategy 端点选择策略
+func (p *UpstreamPool) Strategy() string {
+	return p.strategy
+}
+
+// candidates 按选择策略返回本次请求的端点顺序，探测不健康的端点排在最后
+func (p *UpstreamPool) candidates() []*upstreamEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var healthy, unhealthy []*upstreamEndpoint
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy {
+			healthy = append(healthy, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+
+	switch p.strategy {
+	case StrategyLeastFailures:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			a, b := healthy[i], healthy[j]
+			fa, fb := a.breaker.Failures(), b.breaker.Failures()
+			if fa != fb {
+				return fa < fb
+			}
+			ra, rb := failureRate(a), failureRate(b)
+			if ra != rb {
+				return ra < rb
+			}
+			return a.weight > b.weight
+		})
+	default:
+		if len(healthy) > 1 {
+			// 平滑加权轮询选出首选端点，其余端点按配置顺序作为故障转移候选
+			best := 0
+			total := 0
+			for i, endpoint := range healthy {
+				endpoint.currentWeight += endpoint.weight
+				total += endpoint.weight
+				if endpoint.currentWeight > healthy[best].currentWeight {
+					best = i
+				}
+			}
+			healthy[best].currentWeight -= total
+
+			ordered := []*upstreamEndpoint{healthy[best]}
+			ordered = append(ordered, healthy[:best]...)
+			ordered = append(ordered, healthy[best+1:]...)
+			healthy = ordered
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// record 记录一次请求结果
+func (p *UpstreamPool) record(endpoint *upstreamEndpoint, err error, latency time.Duration) {
+	endpoint.breaker.Record(err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	endpoint.requests++
+	endpoint.totalLatency += latency
+	endpoint.lastLatency = latency
+	if isUpstreamFailure(err) {
+		endpoint.failures++
+		endpoint.lastError = truncateError(err.Error())
+		endpoint.lastFailureAt = &now
+	} else {
+		// 账户级错误（如令牌失效、单个邮箱限流）说明端点本身可用
+		endpoint.successes++
+		endpoint.lastSuccessAt = &now
+	}
+}
+
+// Stats 获取所有端点的统计信息
+func (p *UpstreamPool) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]EndpointStats, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		stat := EndpointStats{
+			Name:          endpoint.name,
+			URL:           endpoint.url,
+			Weight:        endpoint.weight,
+			Healthy:       endpoint.healthy,
+			Breaker:       endpoint.breaker.Status(),
+			Requests:      endpoint.requests,
+			Successes:     endpoint.successes,
+			Failures:      endpoint.failures,
+			LastLatencyMs: endpoint.lastLatency.Milliseconds(),
+			LastError:     endpoint.lastError,
+			LastSuccessAt: endpoint.lastSuccessAt,
+			LastFailureAt: endpoint.lastFailureAt,
+			LastProbeAt:   endpoint.lastProbeAt,
+		}
+		if endpoint.requests > 0 {
+			stat.SuccessRate = float64(endpoint.successes) / float64(endpoint.requests)
+			stat.AvgLatencyMs = (endpoint.totalLatency / time.Duration(endpoint.requests)).Milliseconds()
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// Summary 获取端点状态摘要
+func (p *UpstreamPool) Summary() []EndpointSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	summary := make([]EndpointSummary, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		summary = append(summary, EndpointSummary{
+			Name:    endpoint.name,
+			State:   endpoint.breaker.Status().State,
+			Healthy: endpoint.healthy,
+		})
+	}
+	return summary
+}
+
+// Start 启动后台健康探测，探测间隔为0时不启动
+func (p *UpstreamPool) Start() {
+	if p.probeInterval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	if p.stopChan != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.stopChan = make(chan struct{})
+	stopChan := p.stopChan
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.probeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				p.Probe()
+			}
+		}
+	}()
+}
+
+// Stop 停止后台健康探测
+func (p *UpstreamPool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopChan != nil {
+		close(p.stopChan)
+		p.stopChan = nil
+	}
+}
+
+// Probe 并发探测所有端点，能返回非5xx响应即视为健康（同时用于预热冷启动的部署）
+func (p *UpstreamPool) Probe() {
+	var wg sync.WaitGroup
+	for _, endpoint := range p.endpoints {
+		wg.Add(1)
+		go func(endpoint *upstreamEndpoint) {
+			defer wg.Done()
+
+			healthy := true
+			resp, err := p.probeClient.Get(endpoint.url)
+			if err != nil {
+				healthy = false
+			} else {
+				resp.Body.Close()
+				healthy = resp.StatusCode < http.StatusInternalServerError
+			}
+
+			now := time.Now()
+			p.mu.Lock()
+			if endpoint.healthy != healthy {
+				log.Printf("Upstream %s (%s) health changed: healthy=%v", endpoint.name, endpoint.url, healthy)
+			}
+			endpoint.healthy = healthy
+			endpoint.lastProbeAt = &now
+			p.mu.Unlock()
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// failureRate 计算端点失败率（调用方需持有锁）
+func failureRate(endpoint *upstreamEndpoint) float64 {
+	if endpoint.requests == 0 {
+		return 0
+	}
+	return float64(endpoint.failures) / float64(endpoint.requests)
+}
+
+// endpointConfig 端点配置
+type endpointConfig struct {
+	url    string
+	weight int
+}
+
+// parseEndpoints 解析端点列表，格式为“地址|权重”，逗号分隔；未配置时使用单个默认地址
+func parseEndpoints(value, fallback string) []endpointConfig {
+	var endpoints []endpointConfig
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		endpoint := endpointConfig{url: part, weight: 1}
+		if idx := strings.LastIndex(part, "|"); idx >= 0 {
+			endpoint.url = strings.TrimSpace(part[:idx])
+			if weight, err := strconv.Atoi(strings.TrimSpace(part[idx+1:])); err == nil && weight > 0 {
+				endpoint.weight = weight
+			}
+		}
+		endpoint.url = strings.TrimRight(endpoint.url, "/")
+		if endpoint.url != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	if len(endpoints) == 0 && fallback != "" {
+		endpoints = append(endpoints, endpointConfig{url: strings.TrimRight(fallback, "/"), weight: 1})
+	}
+	return endpoints
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"outlook-helper/backend/internal/models"
+)
+
+// ErrWaitCodeTimeout 等待验证码超时
+var ErrWaitCodeTimeout = errors.New("等待验证码超时")
+
+// 等待验证码的轮询退避参数
+const (
+	waitCodeInitialBackoff = 2 * time.Second
+	waitCodeMaxBackoff     = 10 * time.Second
+	waitCodeClockSkew      = 5 * time.Second // 允许上游邮件时间与本机时间存在的偏差
+)
+
+// WaitCodeOptions 等待验证码的过滤条件
+type WaitCodeOptions struct {
+	Mailbox      string
+	Timeout      time.Duration
+	From         string         // 发件人包含的关键字（不区分大小写）
+	SubjectRegex *regexp.Regexp // 主题需要匹配的正则
+}
+
+// WaitForVerifyCode 轮询最新邮件，直到收到晚于请求时间且匹配过滤条件的验证码邮件。
+// 到达超时时间时取消进行中的上游请求，不会因上游重试而超出请求的等待时间
+func (s *EmailService) WaitForVerifyCode(ctx context.Context, userID, emailID int, opts WaitCodeOptions, client models.ClientInfo) (*models.OutlookMail, error) {
+	// 获取邮箱信息
+	email, err := s.GetReadableEmail(userID, emailID)
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-waitCodeClockSkew)
+	deadline := time.Now().Add(opts.Timeout)
+	backoff := waitCodeInitialBackoff
+
+	// 每次轮询的上游请求和等待都不超过截止时间
+	pollCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	var baselineID string
+	var lastErr error
+poll:
+	for attempt := 0; ; attempt++ {
+		mail, err := s.outlookService.GetLatestMail(pollCtx, email, opts.Mailbox, "json")
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// 到达截止时间被取消的请求不作为失败原因
+			if !isCanceled(err) {
+				lastErr = err
+			}
+		} else {
+			if attempt == 0 {
+				baselineID = mail.ID
+			}
+			if isNewMail(mail, since, baselineID) && matchWaitFilters(mail, opts) {
+				s.extractor.Enrich(userID, mail)
+				if mail.VerifyCode != "" {
+					s.emailRepo.UpdateLastOperation(emailID)
+					s.logRepo.LogEmail(userID, "wait_verify_code", emailID,
+						fmt.Sprintf("等待验证码成功，邮箱: %s，轮询次数: %d", email.EmailAddress, attempt+1),
+						client)
+
+					s.events.PublishMail(NewMailEvent{
+						UserID:       userID,
+						EmailID:      emailID,
+						EmailAddress: email.EmailAddress,
+						Mail:         mail,
+						DetectedAt:   time.Now(),
+					})
+					return mail, nil
+				}
+			}
+		}
+
+		// 计算下一次轮询的等待时间，不超过剩余时间
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		wait := backoff
+		if wait > remaining {
+			wait = remaining
+		}
+
+		select {
+		case <-pollCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			break poll
+		case <-time.After(wait):
+		}
+
+		backoff = backoff * 3 / 2
+		if backoff > waitCodeMaxBackoff {
+			backoff = waitCodeMaxBackoff
+		}
+	}
+
+	description := fmt.Sprintf("等待验证码超时，邮箱: %s", email.EmailAddress)
+	if lastErr != nil {
+		description = fmt.Sprintf("%s，最后一次错误: %v", description, lastErr)
+	}
+	s.logRepo.LogEmail(userID, "wait_verify_code_failed", emailID, description, client)
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWaitCodeTimeout, lastErr)
+	}
+	return nil, ErrWaitCodeTimeout
+}
+
+// isNewMail 判断邮件是否为请求之后收到的新邮件
+func isNewMail(mail *models.OutlookMail, since time.Time, baselineID string) bool {
+	if mail.ReceivedAt.IsZero() {
+		// 无法解析时间时，以邮件ID变化作为判断依据
+		return mail.ID != "" && mail.ID != baselineID
+	}
+	return mail.ReceivedAt.After(since)
+}
+
+// matchWaitFilters 判断邮件是否满足发件人和主题过滤条件
+func matchWaitFilters(mail *models.OutlookMail, opts WaitCodeOptions) bool {
+	if opts.From != "" && !strings.Contains(strings.ToLower(mail.From), strings.ToLower(opts.From)) {
+		return false
+	}
+	if opts.SubjectRegex != nil && !opts.SubjectRegex.MatchString(mail.Subject) {
+		return false
+	}
+	return true
+}